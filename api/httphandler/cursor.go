@@ -0,0 +1,94 @@
+package httphandler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position of the last task of a page in the order of the query, it's
+// opaque to clients, so the content can change without breaking them
+type cursor struct {
+	After int `json:"a"`
+	// the order of the query, a cursor can't be used for another order
+	Sort string `json:"o,omitempty"`
+	Desc bool   `json:"d,omitempty"`
+	// the keys of the last task in the index of Sort, or in the index of the equal
+	// filter walked in the order of id without Sort
+	Name      string `json:"n,omitempty"`
+	Status    int    `json:"s,omitempty"`
+	Priority  int    `json:"p,omitempty"`
+	DueAt     int64  `json:"du,omitempty"`
+	CreatedAt int64  `json:"c,omitempty"`
+	UpdatedAt int64  `json:"u,omitempty"`
+	ProjectID int    `json:"pr,omitempty"`
+	ParentID  int    `json:"pa,omitempty"`
+}
+
+// newCursor returns the cursor right after the task in the order of the query
+func newCursor(task *entity.Task, q storage.Query[*entity.Task]) cursor {
+	c := cursor{After: task.ID, Sort: q.SortBy, Desc: q.Desc}
+	switch q.SortBy {
+	case "":
+		// the ids of an equal filter are walked in its index after the key of the filter
+		c.Status, c.Priority = int(task.Status), int(task.Priority)
+		c.ProjectID, c.ParentID = task.ProjectID, task.ParentID
+	case sortByName:
+		c.Name = task.Name
+	case sortByStatus:
+		c.Status = int(task.Status)
+	case sortByPriority:
+		c.Priority = int(task.Priority)
+	case sortByDueAt:
+		c.DueAt = dueKey(task)
+	case sortByCreatedAt:
+		c.CreatedAt = task.CreatedAt.UnixNano()
+	case sortByUpdatedAt:
+		c.UpdatedAt = task.UpdatedAt.UnixNano()
+	}
+	return c
+}
+
+// task returns the task with the keys of the cursor for storage.FindAfter, the due key
+// of no due date is kept, as dueKey of time.Unix(0, math.MaxInt64) is math.MaxInt64
+func (c cursor) task() *entity.Task {
+	return &entity.Task{
+		ID:        c.After,
+		Name:      c.Name,
+		Status:    entity.TaskStatus(c.Status),
+		Priority:  entity.TaskPriority(c.Priority),
+		DueAt:     time.Unix(0, c.DueAt),
+		CreatedAt: time.Unix(0, c.CreatedAt),
+		UpdatedAt: time.Unix(0, c.UpdatedAt),
+		ProjectID: c.ProjectID,
+		ParentID:  c.ParentID,
+	}
+}
+
+// encode returns the cursor as url safe string
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses the cursor string of the query
+func decodeCursor(s string, q storage.Query[*entity.Task]) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.After < 0 {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != q.SortBy || c.Desc != q.Desc {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,45 @@
+package httphandler
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"glookbs.github.com/storage"
+)
+
+// quotaExceeded counts the requests rejected by the storage quota by resource, it's
+// published at /debug/vars
+var quotaExceeded = expvar.NewMap("storage_quota_exceeded")
+
+// respStorageErr responds the error returned by the storage with storageErrCode
+func respStorageErr(c *gin.Context, err error) {
+	c.JSON(storageErrCode(err), RespErr{Err: err.Error()})
+}
+
+// storageErrCode returns the status code of the error returned by the storage, a quota
+// breach is 507 insufficient storage, a reference to a nonexistent project is 422, the
+// data too large for the storage driver is 413, and an unknown error is 500
+func storageErrCode(err error) int {
+	var qe *storage.QuotaError
+	switch {
+	case errors.As(err, &qe):
+		quotaExceeded.Add(qe.Resource, 1)
+		return http.StatusInsufficientStorage
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, storage.ErrBatchAborted):
+		return http.StatusFailedDependency
+	case errors.Is(err, storage.ErrDanglingReference), errors.Is(err, storage.ErrCycle):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, storage.ErrReferenced):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
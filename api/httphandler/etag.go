@@ -0,0 +1,63 @@
+package httphandler
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"glookbs.github.com/entity"
+)
+
+// ErrPreconditionFailed is responded with 412 if If-Match or If-None-Match doesn't hold
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// etag returns the strong entity tag of the task, it's the quoted version of the task
+func etag(task *entity.Task) string {
+	return `"` + strconv.Itoa(task.Version) + `"`
+}
+
+// matchETag reports whether the header, "*" or a list of entity tags, matches the tag.
+// The weak tags are only matched by the weak comparison
+func matchETag(header, tag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if strings.HasPrefix(t, "W/") {
+			if !weak {
+				continue
+			}
+			t = t[2:]
+		}
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// conditional reports whether the request has If-Match or If-None-Match
+func conditional(c *gin.Context) bool {
+	return c.GetHeader("If-Match") != "" || c.GetHeader("If-None-Match") != ""
+}
+
+// checkPreconditions reports whether If-Match and If-None-Match of a write request hold
+// for the current task, a nil task is nonexist
+func checkPreconditions(c *gin.Context, task *entity.Task) bool {
+	if h := c.GetHeader("If-Match"); h != "" && (task == nil || !matchETag(h, etag(task), false)) {
+		return false
+	}
+	if h := c.GetHeader("If-None-Match"); h != "" && task != nil && matchETag(h, etag(task), true) {
+		return false
+	}
+	return true
+}
+
+// notModified reports whether If-None-Match of a read request matches the task
+func notModified(c *gin.Context, task *entity.Task) bool {
+	h := c.GetHeader("If-None-Match")
+	return h != "" && matchETag(h, etag(task), true)
+}
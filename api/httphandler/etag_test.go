@@ -0,0 +1,24 @@
+package httphandler
+
+import "testing"
+
+func TestMatchETag(t *testing.T) {
+	testcases := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{header: `"2"`, want: true},
+		{header: `"1", "2"`, want: true},
+		{header: `"1"`, want: false},
+		{header: `*`, want: true},
+		{header: `W/"2"`, weak: true, want: true},
+		{header: `W/"2"`, weak: false, want: false},
+		{header: `"1",W/"2"`, weak: true, want: true},
+	}
+	for _, tt := range testcases {
+		if ans := matchETag(tt.header, `"2"`, tt.weak); ans != tt.want {
+			t.Fatalf("match of %s with weak %v should be %v, but got %v", tt.header, tt.weak, tt.want, ans)
+		}
+	}
+}
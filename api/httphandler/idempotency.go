@@ -0,0 +1,177 @@
+package httphandler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// headerIdempotencyKey is the header of the key chosen by the client for a request and its retries
+	headerIdempotencyKey = "Idempotency-Key"
+	// headerIdempotentReplayed is set on the responses replayed for the retries
+	headerIdempotentReplayed = "Idempotent-Replayed"
+	// maxIdempotencyKeyLen is the max length of an idempotency key
+	maxIdempotencyKeyLen = 255
+	// defaultIdempotencyWindow is how long the first response of a key is kept by default
+	defaultIdempotencyWindow = 24 * time.Hour
+)
+
+var (
+	// ErrIdempotencyKeyReused is responded with 422 if a key is reused with a different body
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request body")
+	// ErrIdempotencyKeyTooLong is responded with 400 if a key is longer than maxIdempotencyKeyLen
+	ErrIdempotencyKeyTooLong = errors.New("idempotency key is too long")
+)
+
+// idempotencyRecord is the first response of the requests with a key
+type idempotencyRecord struct {
+	digest [sha256.Size]byte
+	// done is closed when the first request is finished, the fields below are set before
+	done chan struct{}
+	// released is whether the response was not kept, so a retry is handled again
+	released bool
+	code     int
+	header   http.Header
+	body     []byte
+	expires  time.Time
+}
+
+// idempotencyStore keeps the first response of every idempotency key for the window
+type idempotencyStore struct {
+	mu      sync.Mutex
+	window  time.Duration
+	now     func() time.Time
+	records map[string]*idempotencyRecord
+	// expiring are the finished records in the order of expiration
+	expiring []expiringKey
+}
+
+type expiringKey struct {
+	key    string
+	record *idempotencyRecord
+}
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		window:  window,
+		now:     time.Now,
+		records: make(map[string]*idempotencyRecord),
+	}
+}
+
+// reserve returns the record of the key, it's a new record if the key is not in use,
+// and the caller must finish it
+func (s *idempotencyStore) reserve(key string, digest [sha256.Size]byte) (*idempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for len(s.expiring) > 0 && !s.expiring[0].record.expires.After(now) {
+		if e := s.expiring[0]; s.records[e.key] == e.record {
+			delete(s.records, e.key)
+		}
+		s.expiring = s.expiring[1:]
+	}
+	if r, ok := s.records[key]; ok {
+		return r, false
+	}
+	r := &idempotencyRecord{digest: digest, done: make(chan struct{})}
+	s.records[key] = r
+	return r, true
+}
+
+// finish keeps the response of the record for the window, the response is released if
+// the request was not completed or failed by the server, so it can be retried
+func (s *idempotencyStore) finish(key string, r *idempotencyRecord, w *recordingWriter, completed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(r.done)
+	if !completed || w.Status() >= http.StatusInternalServerError {
+		r.released = true
+		delete(s.records, key)
+		return
+	}
+	r.code = w.Status()
+	r.header = w.Header().Clone()
+	r.body = w.body.Bytes()
+	r.expires = s.now().Add(s.window)
+	s.expiring = append(s.expiring, expiringKey{key: key, record: r})
+}
+
+// handle is the middleware which replays the first response to the retries of a request
+// with the same Idempotency-Key and body. The retries wait for the first request if
+// it's in progress, and a key reused with a different body is rejected with 422
+func (s *idempotencyStore) handle(c *gin.Context) {
+	key := c.GetHeader(headerIdempotencyKey)
+	if len(key) == 0 || s.window <= 0 {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		c.AbortWithStatusJSON(http.StatusBadRequest, RespErr{Err: ErrIdempotencyKeyTooLong.Error()})
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	digest := sha256.Sum256(body)
+
+	for {
+		r, first := s.reserve(key, digest)
+		if first {
+			w := &recordingWriter{ResponseWriter: c.Writer}
+			c.Writer = w
+			completed := false
+			defer func() { s.finish(key, r, w, completed) }()
+			c.Next()
+			completed = true
+			return
+		}
+		if r.digest != digest {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, RespErr{Err: ErrIdempotencyKeyReused.Error()})
+			return
+		}
+		select {
+		case <-r.done:
+		case <-c.Request.Context().Done():
+			c.Abort()
+			return
+		}
+		if r.released {
+			continue
+		}
+		for k, v := range r.header {
+			c.Writer.Header()[k] = v
+		}
+		c.Header(headerIdempotentReplayed, "true")
+		c.Writer.WriteHeader(r.code)
+		_, _ = c.Writer.Write(r.body)
+		c.Abort()
+		return
+	}
+}
+
+// recordingWriter keeps a copy of the response body
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,169 @@
+package httphandler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gotest.tools/assert"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	calls := 0
+	r := gin.New()
+	r.POST("/", store.handle, func(c *gin.Context) {
+		calls++
+		body, _ := c.GetRawData()
+		if string(body) == "fail" {
+			c.JSON(http.StatusInternalServerError, RespErr{Err: "fail"})
+			return
+		}
+		c.Header("ETag", `"1"`)
+		c.String(http.StatusOK, "%d:%s", calls, body)
+	})
+
+	testcases := []struct {
+		name         string
+		key          string
+		body         string
+		elapse       time.Duration
+		wantCode     int
+		wantBody     string
+		wantReplayed bool
+		wantCalls    int
+	}{
+		{
+			name:      "first request",
+			key:       "k1",
+			body:      "a",
+			wantCode:  http.StatusOK,
+			wantBody:  "1:a",
+			wantCalls: 1,
+		},
+		{
+			name:         "retry",
+			key:          "k1",
+			body:         "a",
+			elapse:       30 * time.Second,
+			wantCode:     http.StatusOK,
+			wantBody:     "1:a",
+			wantReplayed: true,
+			wantCalls:    1,
+		},
+		{
+			name:      "reuse with a different body",
+			key:       "k1",
+			body:      "b",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantCalls: 1,
+		},
+		{
+			name:      "without key",
+			body:      "a",
+			wantCode:  http.StatusOK,
+			wantBody:  "2:a",
+			wantCalls: 2,
+		},
+		{
+			name:      "another key",
+			key:       "k2",
+			body:      "a",
+			wantCode:  http.StatusOK,
+			wantBody:  "3:a",
+			wantCalls: 3,
+		},
+		{
+			name:      "expired key",
+			key:       "k1",
+			body:      "b",
+			elapse:    30 * time.Second,
+			wantCode:  http.StatusOK,
+			wantBody:  "4:b",
+			wantCalls: 4,
+		},
+		{
+			name:      "server error",
+			key:       "k3",
+			body:      "fail",
+			wantCode:  http.StatusInternalServerError,
+			wantCalls: 5,
+		},
+		{
+			name:      "retry of server error",
+			key:       "k3",
+			body:      "fail",
+			wantCode:  http.StatusInternalServerError,
+			wantCalls: 6,
+		},
+		{
+			name:      "too long key",
+			key:       strings.Repeat("k", maxIdempotencyKeyLen+1),
+			body:      "a",
+			wantCode:  http.StatusBadRequest,
+			wantCalls: 6,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.elapse)
+			req, err := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("create http request error %v", err)
+			}
+			if tt.key != "" {
+				req.Header.Set(headerIdempotencyKey, tt.key)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.Equal(t, tt.wantReplayed, w.Header().Get(headerIdempotentReplayed) == "true")
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+				assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestIdempotencyStoreConcurrentRetries(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	var calls int
+	release := make(chan struct{})
+	r := gin.New()
+	r.POST("/", store.handle, func(c *gin.Context) {
+		calls++
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("a"))
+			req.Header.Set(headerIdempotencyKey, "k")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			codes[i] = w.Code
+		}(i)
+	}
+	// the retries wait for the first request in progress
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, calls)
+	for _, code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+}
@@ -0,0 +1,277 @@
+package httphandler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+)
+
+type Project struct {
+	db *storage.Storage[*entity.Project]
+	// tasks serves the tasks of projects
+	tasks *Task
+	// ref is the reference of tasks to projects, a task is moved to another project by
+	// changing its project_id, and the projects are deleted by it
+	ref *storage.Reference[*entity.Task, *entity.Project]
+}
+
+// Get returns projects by page in the order of id
+// @Summary returns projects
+// @tags projects
+// @Param page query uint false "1"
+// @Param page_size query uint false "10"
+// @Produce json
+// @Success 200 {object} RespProjectPagination
+// @Failure 400 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /projects [get]
+func (p *Project) Get(c *gin.Context) {
+	var query RequestGetProjectsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	data, total, err := p.db.Find(storage.Query[*entity.Project]{}, query.Page, query.PageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		return
+	}
+	result := RespProjectPagination{
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+		Projects: make([]RespProject, 0, len(data)),
+	}
+	for _, project := range data {
+		result.Projects = append(result.Projects, p.respProject(project))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetByID returns project by id with the number of its tasks
+// @Summary returns project by id
+// @tags projects
+// @Param id path string true "id"
+// @Produce json
+// @Success 200 {object} RespProject
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /projects/{id} [get]
+func (p *Project) GetByID(c *gin.Context) {
+	var req RequestProject
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	project, err := p.db.Get(req.ID)
+	if err != nil {
+		respStorageErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p.respProject(project))
+}
+
+// Post creates a project
+// @Summary create project
+// @tags projects
+// @Accept json
+// @Param request body RequestCreateProject true "request data"
+// @Produce json
+// @Success 200 {object} RespProject
+// @Failure 400 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /projects [post]
+func (p *Project) Post(c *gin.Context) {
+	var req RequestCreateProject
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	project := req.project(0)
+	project.CreatedAt = time.Now()
+	project.UpdatedAt = project.CreatedAt
+	if _, err := p.db.Insert(project); err != nil {
+		respStorageErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p.respProject(project))
+}
+
+// Put updates project by id, unlike tasks a project is never created by Put
+// @Summary update project by id
+// @tags projects
+// @Accept json
+// @Param id path string true "id"
+// @Param request body RequestCreateProject true "request data"
+// @Produce json
+// @Success 200 {object} RespProject
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /projects/{id} [put]
+func (p *Project) Put(c *gin.Context) {
+	var uri RequestProject
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	var req RequestCreateProject
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	old, err := p.db.Get(uri.ID)
+	if err != nil {
+		respStorageErr(c, err)
+		return
+	}
+	project := req.project(uri.ID)
+	project.CreatedAt = old.CreatedAt
+	project.UpdatedAt = time.Now()
+	if err := p.db.Update(uri.ID, project); err != nil {
+		respStorageErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p.respProject(project))
+}
+
+// Delete deletes project by id, a project with tasks is only deleted with cascade, which
+// deletes the tasks with it, it's responded with 409 otherwise
+// @Summary deletes project by id
+// @tags projects
+// @Param id path string true "id"
+// @Param cascade query bool false "delete the tasks of the project"
+// @Success 202
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 409 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /projects/{id} [delete]
+func (p *Project) Delete(c *gin.Context) {
+	var req RequestProject
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	var query RequestDeleteProjectQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	if _, err := p.ref.Delete(req.ID, nil, query.Cascade); err != nil {
+		respStorageErr(c, err)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusAccepted)
+}
+
+// GetTasks returns the tasks of project as GET /tasks with the project filter
+// @Summary returns the tasks of project
+// @tags projects
+// @Param id path string true "id"
+// @Param page query uint false "1"
+// @Param page_size query uint false "10"
+// @Param cursor query string false "next_cursor of the previous page, empty for the first page"
+// @Param status query int false "filter by status, the index of the state in the workflow"
+// @Param parent_id query int false "filter by parent, 0 for the tasks which are no subtasks"
+// @Param tag query []string false "filter by tags, the tasks with any or all of them by tag_mode" collectionFormat(multi)
+// @Param tag_mode query string false "any by default, or all" Enums(any, all)
+// @Param priority query int false "filter by priority" Enums(0, 1, 2, 3, 4)
+// @Param name_prefix query string false "filter by the prefix of name"
+// @Param name_contains query string false "filter by the substring of name"
+// @Param due_after query string false "filter by the due date not before, RFC 3339"
+// @Param due_before query string false "filter by the due date before, RFC 3339"
+// @Param sort query string false "order by, id by default" Enums(id, name, status, priority, due_at, created_at, updated_at)
+// @Param order query string false "asc or desc" Enums(asc, desc)
+// @Produce json
+// @Success 200 {object} RespTaskPagination
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /projects/{id}/tasks [get]
+func (p *Project) GetTasks(c *gin.Context) {
+	var req RequestProject
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	var query RequestGetTaskQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	if !p.exists(c, req.ID) {
+		return
+	}
+	query.ProjectID = &req.ID
+	p.tasks.list(c, query)
+}
+
+// PostTask creates a task in project as POST /tasks, the project_id of the request is
+// ignored
+// @Summary create task in project
+// @tags projects
+// @Accept json
+// @Param id path string true "id"
+// @Param request body RequsetCreateTask true "request data"
+// @Produce json
+// @Success 200 {object} RespCreateTaskOK
+// @Header 200 {string} ETag "version of the task"
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 413 {object} RespErr
+// @Failure 422 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Failure 507 {object} RespErr
+// @Router /projects/{id}/tasks [post]
+func (p *Project) PostTask(c *gin.Context) {
+	var uri RequestProject
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	var req RequsetCreateTask
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	if !p.exists(c, uri.ID) {
+		return
+	}
+	// the project may still be deleted before the task is created, which is 422 then
+	req.ProjectID = uri.ID
+	p.tasks.create(c, req)
+}
+
+// exists reports whether the project exists, it responds 404 otherwise
+func (p *Project) exists(c *gin.Context, id int) bool {
+	_, err := p.db.Get(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, RespErr{Err: err.Error()})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		return false
+	}
+	return true
+}
+
+// respProject returns the response of the project
+func (p *Project) respProject(project *entity.Project) RespProject {
+	return RespProject{
+		ID:          project.ID,
+		Name:        project.Name,
+		Description: project.Description,
+		CreatedAt:   project.CreatedAt,
+		UpdatedAt:   project.UpdatedAt,
+		Version:     project.Version,
+		TaskCount:   p.ref.Count(project.ID),
+	}
+}
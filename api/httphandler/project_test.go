@@ -0,0 +1,129 @@
+package httphandler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"gotest.tools/assert"
+)
+
+func TestProjects(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	projects := storage.New(skiplists.New[*entity.Project]())
+	do := newRequester(t, New(gin.TestMode, db, WithProjects(projects)))
+	ignoreProjectTimes := cmpopts.IgnoreFields(RespProject{}, "CreatedAt", "UpdatedAt")
+
+	testcases := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		{name: "create", method: http.MethodPost, path: "/projects", body: `{"name":"p1"}`, wantCode: http.StatusOK},
+		{name: "create another", method: http.MethodPost, path: "/projects", body: `{"name":"p2","description":"doc"}`, wantCode: http.StatusOK},
+		{name: "create without name", method: http.MethodPost, path: "/projects", body: `{}`, wantCode: http.StatusBadRequest},
+		{name: "rename", method: http.MethodPut, path: "/projects/1", body: `{"name":"p1 renamed"}`, wantCode: http.StatusOK},
+		{name: "update nonexistent", method: http.MethodPut, path: "/projects/9", body: `{"name":"p9"}`, wantCode: http.StatusNotFound},
+		{name: "get nonexistent", method: http.MethodGet, path: "/projects/9", wantCode: http.StatusNotFound},
+		{name: "create task in project", method: http.MethodPost, path: "/projects/1/tasks", body: `{"name":"t1"}`, wantCode: http.StatusOK},
+		{name: "create task in nonexistent project", method: http.MethodPost, path: "/projects/9/tasks", body: `{"name":"t"}`, wantCode: http.StatusNotFound},
+		{name: "create task referencing project", method: http.MethodPost, path: "/tasks", body: `{"name":"t2","project_id":1}`, wantCode: http.StatusOK},
+		{name: "create task referencing nonexistent project", method: http.MethodPost, path: "/tasks", body: `{"name":"t","project_id":9}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "create task in no project", method: http.MethodPost, path: "/tasks", body: `{"name":"t3"}`, wantCode: http.StatusOK},
+		{name: "move task", method: http.MethodPatch, path: "/tasks/2", contentType: mediaMergePatch, body: `{"project_id":2}`, wantCode: http.StatusOK},
+		{name: "move task to nonexistent project", method: http.MethodPatch, path: "/tasks/2", contentType: mediaMergePatch, body: `{"project_id":9}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "put task to nonexistent project", method: http.MethodPut, path: "/tasks/2", body: `{"name":"t2","project_id":9}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "tasks of nonexistent project", method: http.MethodGet, path: "/projects/9/tasks", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.contentType, tt.body)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+		})
+	}
+
+	w := do(http.MethodGet, "/projects", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp RespProjectPagination
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.DeepEqual(t, resp, RespProjectPagination{Page: 1, PageSize: 10, Total: 2, Projects: []RespProject{
+		{ID: 1, Name: "p1 renamed", Version: 2, TaskCount: 1},
+		{ID: 2, Name: "p2", Description: "doc", Version: 1, TaskCount: 1},
+	}}, ignoreProjectTimes)
+
+	tasksOf := func(path string) []int {
+		w := do(http.MethodGet, path, "", "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp RespTaskPagination
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		ids := make([]int, 0, len(resp.Tasks))
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.ID)
+		}
+		return ids
+	}
+	assert.DeepEqual(t, tasksOf("/projects/1/tasks"), []int{1})
+	assert.DeepEqual(t, tasksOf("/projects/2/tasks?cursor="), []int{2})
+	assert.DeepEqual(t, tasksOf("/tasks?project_id=0"), []int{3})
+
+	// a project with tasks is only deleted with its tasks
+	w = do(http.MethodDelete, "/projects/2", "", "")
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	w = do(http.MethodDelete, "/projects/2?cascade=true", "", "")
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	w = do(http.MethodGet, "/projects/2", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.DeepEqual(t, tasksOf("/tasks"), []int{1, 3})
+
+	// a project without tasks is deleted
+	w = do(http.MethodPatch, "/tasks/1", mediaMergePatch, `{"project_id":0}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = do(http.MethodDelete, "/projects/1", "", "")
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, projects.Count(), 0)
+	task, err := db.Get(1)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, *task, entity.Task{ID: 1, Name: "t1", Version: 2}, ignoreTimes)
+}
+
+func TestProjectTasksCursor(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	projects := storage.New(skiplists.New[*entity.Project]())
+	do := newRequester(t, New(gin.TestMode, db, WithProjects(projects)))
+	w := do(http.MethodPost, "/projects", "application/json", `{"name":"p1"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	for _, path := range []string{"/projects/1/tasks", "/tasks", "/projects/1/tasks", "/projects/1/tasks"} {
+		w := do(http.MethodPost, path, "application/json", `{"name":"t"}`)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// the cursor walks the tasks of the project by one
+	var walked []int
+	for cursor, more := "", true; more; more = len(cursor) > 0 {
+		w := do(http.MethodGet, "/projects/1/tasks?page_size=1&cursor="+cursor, "", "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp RespTaskPagination
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		assert.Equal(t, resp.Total, 3)
+		for _, task := range resp.Tasks {
+			walked = append(walked, task.ID)
+		}
+		assert.Assert(t, len(walked) <= 3, walked)
+		cursor = resp.NextCursor
+	}
+	assert.DeepEqual(t, walked, []int{1, 3, 4})
+}
@@ -1,13 +1,136 @@
 package httphandler
 
+import (
+	"math"
+	"strings"
+	"time"
+
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+)
+
 type RequsetCreateTask struct {
-	Name   string `json:"name" binding:"required" example:"task-1"`
-	Status int    `json:"status" binding:"min=0,max=1"`
+	Name string `json:"name" binding:"required" example:"task-1"`
+	// Status is the index of the state in the workflow, see GET /workflow
+	Status int `json:"status" binding:"status"`
+	// Description is the description of the task in markdown
+	Description string `json:"description" binding:"max=10000" example:"**bold** and _italic_"`
+	// Priority is 0 none, 1 low, 2 medium, 3 high or 4 urgent
+	Priority int `json:"priority" binding:"min=0,max=4" enums:"0,1,2,3,4"`
+	// DueAt is the due date of the task in RFC 3339, the task has no due date without it
+	DueAt *time.Time `json:"due_at,omitempty" example:"2024-01-02T15:04:05Z"`
+	// ProjectID is the id of the project of the task, the task is in no project if it's 0
+	ProjectID int `json:"project_id" binding:"min=0"`
+	// ParentID is the id of the parent task, the task is no subtask if it's 0
+	ParentID int `json:"parent_id" binding:"min=0"`
+	// Tags are the tags of the task, the duplicate tags are removed
+	Tags []string `json:"tags,omitempty" binding:"max=20,dive,required,max=50" example:"backend,urgent"`
+}
+
+// task returns the task of the request, the server-managed fields are set by stamp
+func (r RequsetCreateTask) task(id int) *entity.Task {
+	task := &entity.Task{
+		ID:          id,
+		Name:        r.Name,
+		Status:      entity.TaskStatus(r.Status),
+		Description: r.Description,
+		Priority:    entity.TaskPriority(r.Priority),
+		ProjectID:   r.ProjectID,
+		ParentID:    r.ParentID,
+		Tags:        distinctTags(r.Tags),
+	}
+	if r.DueAt != nil {
+		task.DueAt = *r.DueAt
+	}
+	return task
 }
 
 type RequestGetTaskQuery struct {
 	Page     int `form:"page,default=1" binding:"min=1"`
 	PageSize int `form:"page_size,default=10" binding:"min=1"`
+	// Cursor switches to cursor mode, an empty cursor starts from the first task
+	Cursor string `form:"cursor"`
+	// filters, the tasks matched by all the given filters are returned
+	Status    *int `form:"status" binding:"omitempty,status"`
+	Priority  *int `form:"priority" binding:"omitempty,min=0,max=4"`
+	ProjectID *int `form:"project_id" binding:"omitempty,min=0"`
+	ParentID  *int `form:"parent_id" binding:"omitempty,min=0"`
+	// Tags select the tasks with any or all of them by TagMode
+	Tags         []string `form:"tag" binding:"max=20,dive,required"`
+	TagMode      string   `form:"tag_mode,default=any" binding:"oneof=any all"`
+	NamePrefix   string   `form:"name_prefix"`
+	NameContains string   `form:"name_contains"`
+	// DueBefore and DueAfter select the tasks due in [DueAfter, DueBefore), the tasks
+	// without due date are not selected by them
+	DueBefore *time.Time `form:"due_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	DueAfter  *time.Time `form:"due_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	// Sort is the order of tasks, the tasks are ordered by id without it
+	Sort  string `form:"sort" binding:"omitempty,oneof=id name status priority due_at created_at updated_at"`
+	Order string `form:"order,default=asc" binding:"oneof=asc desc"`
+}
+
+// query returns the storage query of the filters and the order, the filters on the
+// indexed fields are index lookups
+func (q RequestGetTaskQuery) query() storage.Query[*entity.Task] {
+	query := storage.Query[*entity.Task]{
+		SortBy: q.Sort,
+		Desc:   q.Order == "desc",
+	}
+	if q.Status != nil {
+		query.Where = append(query.Where, storage.Eq(sortByStatus, *q.Status))
+	}
+	if q.Priority != nil {
+		query.Where = append(query.Where, storage.Eq(sortByPriority, *q.Priority))
+	}
+	if q.ProjectID != nil {
+		query.Where = append(query.Where, storage.Eq(filterByProject, *q.ProjectID))
+	}
+	if q.ParentID != nil {
+		query.Where = append(query.Where, storage.Eq(filterByParent, *q.ParentID))
+	}
+	if len(q.Tags) > 0 && q.TagMode == "all" {
+		query.Where = append(query.Where, storage.HasAll(filterByTags, q.Tags...))
+	} else if len(q.Tags) > 0 {
+		query.Where = append(query.Where, storage.HasAny(filterByTags, q.Tags...))
+	}
+	if len(q.NamePrefix) > 0 {
+		query.Where = append(query.Where, storage.Prefix(sortByName, q.NamePrefix))
+	}
+	if q.DueBefore != nil || q.DueAfter != nil {
+		// the tasks without due date are keyed by math.MaxInt64, which is never selected
+		var from any
+		to := int64(math.MaxInt64)
+		if q.DueAfter != nil {
+			from = q.DueAfter.UnixNano()
+		}
+		if q.DueBefore != nil {
+			to = min(q.DueBefore.UnixNano(), to)
+		}
+		query.Where = append(query.Where, storage.Between(sortByDueAt, from, to))
+	}
+	if len(q.NameContains) > 0 {
+		query.Filter = func(t *entity.Task) bool {
+			return strings.Contains(t.Name, q.NameContains)
+		}
+	}
+	if q.Sort == sortByID && !query.Desc {
+		// the storage is ordered by id already
+		query.SortBy = ""
+	}
+	if len(q.Sort) == 0 && query.Desc {
+		query.SortBy = sortByID
+	}
+	return query
+}
+
+type RequestSearchTaskQuery struct {
+	Q        string `form:"q" binding:"required"`
+	Page     int    `form:"page,default=1" binding:"min=1"`
+	PageSize int    `form:"page_size,default=10" binding:"min=1"`
+}
+
+type RequestGetTask struct {
+	ID int `uri:"id" binding:"required,min=1"`
 }
 
 type RequestDeleteTask struct {
@@ -17,3 +140,85 @@ type RequestDeleteTask struct {
 type RequestPutTask struct {
 	ID int `uri:"id" binding:"required,min=1"`
 }
+
+type RequestPatchTask struct {
+	ID int `uri:"id" binding:"required,min=1"`
+}
+
+// the operations of a batch
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+type RequestBatchTasks struct {
+	// Atomic applies either all the operations or none of them
+	Atomic     bool                    `json:"atomic"`
+	Operations []RequestBatchOperation `json:"operations" binding:"required,min=1,max=1000"`
+}
+
+type RequestBatchOperation struct {
+	Op string `json:"op" enums:"create,update,delete" example:"create"`
+	// ID is the id of the task to update or delete
+	ID int `json:"id,omitempty"`
+	// Task is the task to create or update with
+	Task *RequsetCreateTask `json:"task,omitempty"`
+	// IfMatch is the etags of the task to update or delete, as the If-Match header
+	IfMatch string `json:"if_match,omitempty"`
+}
+
+// taskDocument is the json document of a task which a patch is applied to, the fields
+// out of RequsetCreateTask are managed by the server and can't be changed
+type taskDocument struct {
+	ID          int        `json:"id"`
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	RequsetCreateTask
+}
+
+type RequestCreateProject struct {
+	Name string `json:"name" binding:"required" example:"project-1"`
+	// Description is the description of the project in markdown
+	Description string `json:"description" binding:"max=10000"`
+}
+
+// project returns the project of the request, the timestamps are set by the handler
+func (r RequestCreateProject) project(id int) *entity.Project {
+	return &entity.Project{
+		ID:          id,
+		Name:        r.Name,
+		Description: r.Description,
+	}
+}
+
+type RequestGetProjectsQuery struct {
+	Page     int `form:"page,default=1" binding:"min=1"`
+	PageSize int `form:"page_size,default=10" binding:"min=1"`
+}
+
+type RequestProject struct {
+	ID int `uri:"id" binding:"required,min=1"`
+}
+
+type RequestDeleteProjectQuery struct {
+	// Cascade deletes the tasks of the project with it, a project with tasks can't be
+	// deleted without it
+	Cascade bool `form:"cascade"`
+}
+
+type RequestTag struct {
+	Tag string `uri:"tag" binding:"required,max=50"`
+}
+
+type RequestRenameTag struct {
+	// Name is the new name of the tag, it must not be in use
+	Name string `json:"name" binding:"required,max=50" example:"backend"`
+}
+
+type RequestMergeTag struct {
+	// Into is the tag which the tag is merged into
+	Into string `json:"into" binding:"required,max=50" example:"backend"`
+}
@@ -1,5 +1,7 @@
 package httphandler
 
+import "time"
+
 type RespErr struct {
 	Err string `json:"error"`
 }
@@ -9,9 +11,29 @@ type RespCreateTaskOK struct {
 }
 
 type RespTask struct {
-	ID     int    `json:"id"`
-	Name   string `json:"name"`
-	Status int    `json:"status"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+	Priority    int    `json:"priority"`
+	// DueAt is absent if the task has no due date
+	DueAt     *time.Time `json:"due_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	// CompletedAt is absent if the task is not completed
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Version is increased on every change of the task, it's also the ETag header
+	Version int `json:"version"`
+	// ProjectID is the id of the project of the task, it's 0 if the task is in no project
+	ProjectID int `json:"project_id"`
+	// ParentID is the id of the parent task, it's 0 if the task is no subtask
+	ParentID int `json:"parent_id"`
+	// Tags are absent if the task has no tag
+	Tags []string `json:"tags,omitempty"`
+	// Progress is the percentage of the completed tasks under the task, it's absent if
+	// the task has no subtask. It's rolled up from the subtasks, so it's not covered by
+	// the version
+	Progress *int `json:"progress,omitempty"`
 }
 
 type RespTaskPagination struct {
@@ -19,4 +41,83 @@ type RespTaskPagination struct {
 	PageSize int        `json:"page_size"`
 	Total    int        `json:"total"`
 	Tasks    []RespTask `json:"tasks"`
+	// NextCursor is the cursor of the next page in cursor mode, it's empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// RespTaskTree is a task with the tasks under it
+type RespTaskTree struct {
+	RespTask
+	Subtasks []RespTaskTree `json:"subtasks"`
+}
+
+type RespHighlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type RespSearchTask struct {
+	RespTask
+	Score float64 `json:"score"`
+	// Highlights are the byte offsets [start, end) of the matched parts of name
+	Highlights []RespHighlight `json:"highlights"`
+}
+
+type RespSearchTasks struct {
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+	Total    int              `json:"total"`
+	Tasks    []RespSearchTask `json:"tasks"`
+}
+
+type RespBatchResult struct {
+	// Status is the status code of the operation as if it was a single request
+	Status int `json:"status"`
+	// Task is the created or updated task
+	Task *RespTask `json:"task,omitempty"`
+	Err  string    `json:"error,omitempty"`
+}
+
+type RespBatchTasks struct {
+	// Results are the results of the operations in order
+	Results []RespBatchResult `json:"results"`
+}
+
+type RespWorkflow struct {
+	// States are the names of the statuses, the status of a task is the index of its state
+	States []string `json:"states"`
+	// Transitions are the states which a task can transit to by state
+	Transitions map[string][]string `json:"transitions"`
+	// Terminal are the states in which a task is completed
+	Terminal []string `json:"terminal"`
+}
+
+type RespProject struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Version is increased on every change of the project
+	Version int `json:"version"`
+	// TaskCount is the number of the tasks in the project
+	TaskCount int `json:"task_count"`
+}
+
+type RespProjectPagination struct {
+	Page     int           `json:"page"`
+	PageSize int           `json:"page_size"`
+	Total    int           `json:"total"`
+	Projects []RespProject `json:"projects"`
+}
+
+type RespTag struct {
+	Tag string `json:"tag"`
+	// Count is the number of the tasks with the tag
+	Count int `json:"count"`
+}
+
+type RespTags struct {
+	// Tags are ordered by tag
+	Tags []RespTag `json:"tags"`
 }
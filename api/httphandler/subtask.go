@@ -0,0 +1,154 @@
+package httphandler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+)
+
+// ParentDeletion is what DELETE /tasks/{id} and the deletes of POST /tasks:batch do with
+// the subtasks of the deleted task
+type ParentDeletion string
+
+const (
+	// ParentDeletionReject responds 409 for a task with subtasks
+	ParentDeletionReject ParentDeletion = "reject"
+	// ParentDeletionCascade deletes the task with all the tasks under it
+	ParentDeletionCascade ParentDeletion = "cascade"
+	// ParentDeletionOrphan keeps the subtasks as the tasks without parent
+	ParentDeletionOrphan ParentDeletion = "orphan"
+)
+
+// ParseParentDeletion returns the ParentDeletion of the name
+func ParseParentDeletion(name string) (ParentDeletion, error) {
+	switch p := ParentDeletion(name); p {
+	case ParentDeletionReject, ParentDeletionCascade, ParentDeletionOrphan:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown parent deletion %q", name)
+}
+
+// Subtree returns task by id with all the tasks under it, every task with subtasks comes
+// with the progress rolled up from the tasks under it
+// @Summary returns task by id with its subtasks
+// @tags tasks
+// @Param id path string true "id"
+// @Produce json
+// @Success 200 {object} RespTaskTree
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /tasks/{id}/subtree [get]
+func (t *Task) Subtree(c *gin.Context) {
+	var req RequestGetTask
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	tasks, err := t.tree.Subtree(req.ID)
+	if err != nil {
+		respStorageErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, respTaskTree(tasks))
+}
+
+// deleteTask deletes the task of id if match reports true for it, and the subtasks by
+// the ParentDeletion
+func (t *Task) deleteTask(id int, match func(current *entity.Task) bool) error {
+	var err error
+	switch t.parentDeletion {
+	case ParentDeletionCascade:
+		_, err = t.tree.Delete(id, match, true)
+	case ParentDeletionOrphan:
+		_, err = t.tree.Orphan(id, match, orphan(time.Now()))
+	default:
+		_, err = t.tree.Delete(id, match, false)
+	}
+	return err
+}
+
+// batch applies the operations of storage.Batch, and deletes the subtasks by the
+// ParentDeletion
+func (t *Task) batch(ops []storage.BatchOp[*entity.Task], atomic bool) []error {
+	switch t.parentDeletion {
+	case ParentDeletionCascade:
+		return t.tree.Batch(ops, atomic, true, nil)
+	case ParentDeletionOrphan:
+		return t.tree.Batch(ops, atomic, false, orphan(time.Now()))
+	}
+	return t.db.Batch(ops, atomic)
+}
+
+// orphan returns the unset of storage.Tree.Orphan, which makes a subtask top-level
+func orphan(now time.Time) func(subtask *entity.Task) *entity.Task {
+	return func(subtask *entity.Task) *entity.Task {
+		orphan := *subtask
+		orphan.ParentID = 0
+		stamp(&orphan, subtask, now)
+		return &orphan
+	}
+}
+
+// respTask returns the response of the task with the progress rolled up from its subtasks
+func (t *Task) respTask(task *entity.Task) RespTask {
+	resp := respTask(task)
+	if tasks, err := t.tree.Subtree(task.ID); err == nil {
+		total, completed := rollUp(tasks)
+		resp.Progress = progress(total[task.ID], completed[task.ID])
+	}
+	return resp
+}
+
+// respTaskTree returns the response of the tree of the tasks, the root is the first task
+// and the parents are before their subtasks
+func respTaskTree(tasks []*entity.Task) RespTaskTree {
+	total, completed := rollUp(tasks)
+	subtasks := make(map[int][]*entity.Task, len(tasks))
+	for _, task := range tasks[1:] {
+		subtasks[task.ParentID] = append(subtasks[task.ParentID], task)
+	}
+
+	var tree func(task *entity.Task) RespTaskTree
+	tree = func(task *entity.Task) RespTaskTree {
+		resp := RespTaskTree{RespTask: respTask(task), Subtasks: make([]RespTaskTree, 0, len(subtasks[task.ID]))}
+		resp.Progress = progress(total[task.ID], completed[task.ID])
+		for _, subtask := range subtasks[task.ID] {
+			resp.Subtasks = append(resp.Subtasks, tree(subtask))
+		}
+		return resp
+	}
+	return tree(tasks[0])
+}
+
+// rollUp returns the number of the tasks and the completed ones under every task of the
+// tree of the tasks as respTaskTree, at all depths
+func rollUp(tasks []*entity.Task) (total, completed map[int]int) {
+	w := entity.CurrentWorkflow()
+	total = make(map[int]int, len(tasks))
+	completed = make(map[int]int, len(tasks))
+	// the subtasks are counted before their parents
+	for i := len(tasks) - 1; i > 0; i-- {
+		task := tasks[i]
+		total[task.ParentID] += total[task.ID] + 1
+		completed[task.ParentID] += completed[task.ID]
+		if w.IsTerminal(task.Status) {
+			completed[task.ParentID]++
+		}
+	}
+	return total, completed
+}
+
+// progress returns the percentage of the completed tasks, it's nil without tasks
+func progress(total, completed int) *int {
+	if total == 0 {
+		return nil
+	}
+	p := completed * 100 / total
+	return &p
+}
@@ -0,0 +1,206 @@
+package httphandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"gotest.tools/assert"
+)
+
+// newSubtaskRouter returns the router of the tasks 1 -> 2 -> 4 and 1 -> 3 with the
+// parent deletion, 4 is completed
+func newSubtaskRouter(t *testing.T, p ParentDeletion) (*storage.Storage[*entity.Task], requester) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	do := newRequester(t, New(gin.TestMode, db, WithParentDeletion(p)))
+	for _, body := range []string{
+		`{"name":"t1"}`,
+		`{"name":"t2","parent_id":1}`,
+		`{"name":"t3","parent_id":1}`,
+		`{"name":"t4","parent_id":2,"status":1}`,
+	} {
+		w := do(http.MethodPost, "/tasks", "application/json", body)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	return db, do
+}
+
+func TestSubtasks(t *testing.T) {
+	db, do := newSubtaskRouter(t, ParentDeletionReject)
+	tasksOf := func(path string) []int {
+		w := do(http.MethodGet, path, "", "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp RespTaskPagination
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		ids := []int{}
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.ID)
+		}
+		return ids
+	}
+	assert.DeepEqual(t, tasksOf("/tasks?parent_id=1"), []int{2, 3})
+	assert.DeepEqual(t, tasksOf("/tasks?parent_id=0"), []int{1})
+
+	// the progress is rolled up from the subtasks at all depths
+	progressOf := func(id int) *int {
+		w := do(http.MethodGet, fmt.Sprintf("/tasks/%d", id), "", "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp RespTask
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		return resp.Progress
+	}
+	assert.Equal(t, *progressOf(1), 33)
+	assert.Equal(t, *progressOf(2), 100)
+	assert.Assert(t, progressOf(3) == nil)
+	w := do(http.MethodPatch, "/tasks/3", mediaMergePatch, `{"status":1}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, *progressOf(1), 66)
+	w = do(http.MethodPatch, "/tasks/3", mediaMergePatch, `{"status":0}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	testcases := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantCode    int
+	}{
+		{name: "create under nonexistent", method: http.MethodPost, path: "/tasks", contentType: "application/json", body: `{"name":"t","parent_id":9}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "parent of itself", method: http.MethodPatch, path: "/tasks/1", contentType: mediaMergePatch, body: `{"parent_id":1}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "under its subtask", method: http.MethodPatch, path: "/tasks/1", contentType: mediaMergePatch, body: `{"parent_id":4}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "put under its subtask", method: http.MethodPut, path: "/tasks/2", contentType: "application/json", body: `{"name":"t2","parent_id":4}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "delete parent", method: http.MethodDelete, path: "/tasks/2", wantCode: http.StatusConflict},
+		{name: "subtree of nonexistent", method: http.MethodGet, path: "/tasks/9/subtree", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.contentType, tt.body)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+		})
+	}
+
+	// the parent is deleted with its subtasks in a batch
+	w = do(http.MethodPost, "/tasks:batch", "application/json",
+		`{"atomic":true,"operations":[{"op":"delete","id":2},{"op":"delete","id":4}]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var batch RespBatchTasks
+	if err := json.Unmarshal(w.Body.Bytes(), &batch); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.Equal(t, batch.Results[0].Status, http.StatusConflict)
+
+	w = do(http.MethodGet, "/tasks/1/subtree", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var tree RespTaskTree
+	if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	ids := func(tree RespTaskTree) []int {
+		ids := []int{}
+		for _, subtask := range tree.Subtasks {
+			ids = append(ids, subtask.ID)
+		}
+		return ids
+	}
+	// 1 of the 3 tasks under 1 is completed
+	assert.DeepEqual(t, ids(tree), []int{2, 3})
+	assert.Equal(t, *tree.Progress, 33)
+	assert.DeepEqual(t, ids(tree.Subtasks[0]), []int{4})
+	assert.Equal(t, *tree.Subtasks[0].Progress, 100)
+	assert.Assert(t, tree.Subtasks[1].Progress == nil)
+
+	// a subtask is moved to another parent
+	w = do(http.MethodPatch, "/tasks/4", mediaMergePatch, `{"parent_id":3}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = do(http.MethodDelete, "/tasks/2", "", "")
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, db.Count(), 3)
+}
+
+func TestParentDeletion(t *testing.T) {
+	testcases := []struct {
+		deletion  ParentDeletion
+		wantCode  int
+		wantTasks []int
+	}{
+		{deletion: ParentDeletionReject, wantCode: http.StatusConflict, wantTasks: []int{1, 2, 3, 4}},
+		{deletion: ParentDeletionCascade, wantCode: http.StatusAccepted, wantTasks: []int{}},
+		{deletion: ParentDeletionOrphan, wantCode: http.StatusAccepted, wantTasks: []int{2, 3, 4}},
+	}
+	for _, tt := range testcases {
+		t.Run(string(tt.deletion), func(t *testing.T) {
+			db, do := newSubtaskRouter(t, tt.deletion)
+			w := do(http.MethodDelete, "/tasks/1", "", "")
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			ids := []int{}
+			for _, task := range db.Scan(0, 10) {
+				ids = append(ids, task.ID)
+			}
+			assert.DeepEqual(t, ids, tt.wantTasks)
+		})
+		// the deletes of batches follow the parent deletion too
+		for _, atomic := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s batch atomic %v", tt.deletion, atomic), func(t *testing.T) {
+				db, do := newSubtaskRouter(t, tt.deletion)
+				w := do(http.MethodPost, "/tasks:batch", "application/json",
+					fmt.Sprintf(`{"atomic":%v,"operations":[{"op":"delete","id":1}]}`, atomic))
+				assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				var batch RespBatchTasks
+				if err := json.Unmarshal(w.Body.Bytes(), &batch); err != nil {
+					t.Fatalf("failed to unmarshal resp: %v", err)
+				}
+				assert.Equal(t, batch.Results[0].Status, tt.wantCode)
+				ids := []int{}
+				for _, task := range db.Scan(0, 10) {
+					ids = append(ids, task.ID)
+				}
+				assert.DeepEqual(t, ids, tt.wantTasks)
+			})
+		}
+	}
+
+	// the orphans are the tasks without parent, and their subtasks are kept
+	db, do := newSubtaskRouter(t, ParentDeletionOrphan)
+	w := do(http.MethodDelete, "/tasks/1", "", "")
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	task, err := db.Get(2)
+	assert.NilError(t, err)
+	assert.Equal(t, task.ParentID, 0)
+	assert.Equal(t, task.Version, 2)
+	task, err = db.Get(4)
+	assert.NilError(t, err)
+	assert.Equal(t, task.ParentID, 2)
+
+	// the subtask changed by the atomic batch before can't become top-level
+	_, do = newSubtaskRouter(t, ParentDeletionOrphan)
+	w = do(http.MethodPost, "/tasks:batch", "application/json", `{"atomic":true,"operations":[`+
+		`{"op":"update","id":3,"task":{"name":"t3","parent_id":1}},{"op":"delete","id":1}]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var batch RespBatchTasks
+	if err := json.Unmarshal(w.Body.Bytes(), &batch); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.Equal(t, batch.Results[0].Status, http.StatusFailedDependency)
+	assert.Equal(t, batch.Results[1].Status, http.StatusPreconditionFailed)
+
+	// a project can't be deleted with the tasks whose subtasks are in another project
+	_, do = newSubtaskRouter(t, ParentDeletionCascade)
+	w = do(http.MethodPost, "/projects", "application/json", `{"name":"p1"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	for _, path := range []string{"/tasks/1", "/tasks/2"} {
+		w := do(http.MethodPatch, path, mediaMergePatch, `{"project_id":1}`)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	w = do(http.MethodDelete, "/projects/1?cascade=true", "", "")
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+}
@@ -0,0 +1,205 @@
+package httphandler
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+)
+
+var (
+	// ErrTagNotFound is responded with 404 if no task has the tag
+	ErrTagNotFound = errors.New("tag is not in use")
+	// ErrTagInUse is responded with 409 if a tag is renamed to a tag in use, which is a merge
+	ErrTagInUse = errors.New("tag is in use, merge the tags instead")
+)
+
+// distinctTags returns the tags without the duplicate ones in order, it's nil if there's
+// no tag
+func distinctTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if !slices.Contains(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// GetTags returns the tags of tasks with the number of the tasks of every tag
+// @Summary returns tags with usage counts
+// @tags tags
+// @Produce json
+// @Success 200 {object} RespTags
+// @Failure 500 {object} RespErr
+// @Router /tags [get]
+func (t *Task) GetTags(c *gin.Context) {
+	tags, err := t.db.Tags(filterByTags)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		return
+	}
+	resp := RespTags{Tags: make([]RespTag, 0, len(tags))}
+	for _, tag := range tags {
+		resp.Tags = append(resp.Tags, RespTag{Tag: tag.Tag, Count: tag.Count})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RenameTag renames the tag of all the tasks, a tag can't be renamed to a tag in use,
+// which is a merge
+// @Summary renames tag across all tasks
+// @tags tags
+// @Accept json
+// @Param tag path string true "tag"
+// @Param request body RequestRenameTag true "request data"
+// @Produce json
+// @Success 200 {object} RespTag
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 409 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Failure 507 {object} RespErr
+// @Router /tags/{tag}/rename [post]
+func (t *Task) RenameTag(c *gin.Context) {
+	var uri RequestTag
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	var req RequestRenameTag
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	t.replaceTag(c, uri.Tag, req.Name, false)
+}
+
+// MergeTag replaces the tag of all the tasks with another tag, a task with both of them
+// keeps one
+// @Summary merges tag into another across all tasks
+// @tags tags
+// @Accept json
+// @Param tag path string true "tag"
+// @Param request body RequestMergeTag true "request data"
+// @Produce json
+// @Success 200 {object} RespTag
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Failure 507 {object} RespErr
+// @Router /tags/{tag}/merge [post]
+func (t *Task) MergeTag(c *gin.Context) {
+	var uri RequestTag
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	var req RequestMergeTag
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	t.replaceTag(c, uri.Tag, req.Into, true)
+}
+
+// tagCount returns the number of the tasks with the tag
+func (t *Task) tagCount(tag string) int {
+	_, total, _ := t.db.Find(storage.Query[*entity.Task]{Where: []storage.Cond{storage.HasAny(filterByTags, tag)}}, 1, 0)
+	return total
+}
+
+// tagPageSize is the number of the tasks whose tag is replaced in a batch
+const tagPageSize = 100
+
+// replaceTag replaces the tag from with the tag to of all the tasks, and responds the tag
+// to. Unless merge, the tag to must not be in use. The tasks are replaced page by page,
+// a page in an atomic batch which only applies if none of the tasks was changed after it
+// was read, and the batch of the first page also checks that the tag to is not in use
+// unless merge. The batch is tried again if a task was changed, as Patch
+func (t *Task) replaceTag(c *gin.Context, from, to string, merge bool) {
+	if from == to {
+		c.JSON(http.StatusBadRequest, RespErr{Err: "tag is replaced with itself"})
+		return
+	}
+	q := storage.Query[*entity.Task]{Where: []storage.Cond{storage.HasAny(filterByTags, from)}}
+	// the replaced tasks don't match q anymore, so the first page is the next one
+	for replaced, retry := 0, 0; ; {
+		tasks, total, err := t.db.Find(q, 1, tagPageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+			return
+		}
+		if total == 0 && replaced == 0 {
+			c.JSON(http.StatusNotFound, RespErr{Err: ErrTagNotFound.Error()})
+			return
+		}
+		if total == 0 {
+			c.JSON(http.StatusOK, RespTag{Tag: to, Count: t.tagCount(to)})
+			return
+		}
+
+		var ops []storage.BatchOp[*entity.Task]
+		checked := !merge && replaced == 0
+		if checked {
+			ops = append(ops, storage.BatchOp[*entity.Task]{
+				Kind:  storage.OpCheck,
+				Where: []storage.Cond{storage.HasAny(filterByTags, to)},
+			})
+		}
+		ops = append(ops, replaceTagOps(tasks, from, to, time.Now())...)
+		errs := t.db.Batch(ops, true)
+		if checked && errors.Is(errs[0], storage.ErrConflict) {
+			c.JSON(http.StatusConflict, RespErr{Err: ErrTagInUse.Error()})
+			return
+		}
+		err = nil
+		for _, opErr := range errs {
+			if opErr != nil && !errors.Is(opErr, storage.ErrBatchAborted) {
+				err = opErr
+				break
+			}
+		}
+		if err == nil {
+			replaced += len(tasks)
+			retry = 0
+			continue
+		}
+		if (errors.Is(err, storage.ErrConflict) || errors.Is(err, storage.ErrNotFound)) && retry < patchRetries {
+			retry++
+			continue
+		}
+		respStorageErr(c, err)
+		return
+	}
+}
+
+// replaceTagOps returns the operations which replace the tag from with the tag to of the
+// tasks, they only match the tasks of the versions read
+func replaceTagOps(tasks []*entity.Task, from, to string, now time.Time) []storage.BatchOp[*entity.Task] {
+	ops := make([]storage.BatchOp[*entity.Task], 0, len(tasks))
+	for _, task := range tasks {
+		replaced := *task
+		replaced.Tags = make([]string, 0, len(task.Tags))
+		for _, tag := range task.Tags {
+			if tag == from {
+				tag = to
+			}
+			replaced.Tags = append(replaced.Tags, tag)
+		}
+		replaced.Tags = distinctTags(replaced.Tags)
+		stamp(&replaced, task, now)
+		ops = append(ops, storage.BatchOp[*entity.Task]{
+			Kind:  storage.OpUpdate,
+			ID:    task.ID,
+			Data:  &replaced,
+			Match: sameVersion(task),
+		})
+	}
+	return ops
+}
@@ -0,0 +1,132 @@
+package httphandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"gotest.tools/assert"
+)
+
+func TestTags(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	do := newRequester(t, New(gin.TestMode, db))
+	for _, body := range []string{
+		`{"name":"t1","tags":["backend","api"]}`,
+		`{"name":"t2","tags":["frontend"]}`,
+		`{"name":"t3","tags":["backend","urgent","backend"]}`,
+		`{"name":"t4"}`,
+	} {
+		w := do(http.MethodPost, "/tasks", "application/json", body)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	// the duplicate tags are removed
+	task, err := db.Get(3)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, task.Tags, []string{"backend", "urgent"})
+
+	tasksOf := func(path string) ([]int, string) {
+		w := do(http.MethodGet, path, "", "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp RespTaskPagination
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		ids := []int{}
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.ID)
+		}
+		return ids, resp.NextCursor
+	}
+	filters := []struct {
+		path string
+		want []int
+	}{
+		{path: "/tasks?tag=backend", want: []int{1, 3}},
+		{path: "/tasks?tag=backend&tag=frontend", want: []int{1, 2, 3}},
+		{path: "/tasks?tag=backend&tag=api&tag_mode=all", want: []int{1}},
+		{path: "/tasks?tag=backend&tag=frontend&tag_mode=all", want: []int{}},
+		{path: "/tasks?tag=backend&sort=name&order=desc", want: []int{3, 1}},
+		{path: "/tasks?tag=unknown", want: []int{}},
+	}
+	for _, tt := range filters {
+		ids, _ := tasksOf(tt.path)
+		assert.DeepEqual(t, ids, tt.want)
+	}
+	ids, next := tasksOf("/tasks?tag=backend&tag=frontend&page_size=2&cursor=")
+	assert.DeepEqual(t, ids, []int{1, 2})
+	ids, _ = tasksOf("/tasks?tag=backend&tag=frontend&page_size=2&cursor=" + next)
+	assert.DeepEqual(t, ids, []int{3})
+
+	tagsOf := func() []RespTag {
+		w := do(http.MethodGet, "/tags", "", "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp RespTags
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		return resp.Tags
+	}
+	assert.DeepEqual(t, tagsOf(), []RespTag{{"api", 1}, {"backend", 2}, {"frontend", 1}, {"urgent", 1}})
+
+	testcases := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+		want     RespTag
+	}{
+		{name: "invalid tag mode", method: http.MethodGet, path: "/tasks?tag=api&tag_mode=none", wantCode: http.StatusBadRequest},
+		{name: "too long tag", method: http.MethodPost, path: "/tasks", body: fmt.Sprintf(`{"name":"t","tags":["%s"]}`, strings.Repeat("a", 51)), wantCode: http.StatusBadRequest},
+		{name: "empty tag", method: http.MethodPost, path: "/tasks", body: `{"name":"t","tags":[""]}`, wantCode: http.StatusBadRequest},
+		{name: "rename to tag in use", method: http.MethodPost, path: "/tags/backend/rename", body: `{"name":"api"}`, wantCode: http.StatusConflict},
+		{name: "rename to itself", method: http.MethodPost, path: "/tags/backend/rename", body: `{"name":"backend"}`, wantCode: http.StatusBadRequest},
+		{name: "rename unused tag", method: http.MethodPost, path: "/tags/unknown/rename", body: `{"name":"known"}`, wantCode: http.StatusNotFound},
+		{name: "rename", method: http.MethodPost, path: "/tags/backend/rename", body: `{"name":"server"}`, wantCode: http.StatusOK, want: RespTag{"server", 2}},
+		{name: "merge", method: http.MethodPost, path: "/tags/api/merge", body: `{"into":"server"}`, wantCode: http.StatusOK, want: RespTag{"server", 2}},
+		{name: "merge unused tag", method: http.MethodPost, path: "/tags/api/merge", body: `{"into":"server"}`, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, "application/json", tt.body)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantCode == http.StatusOK {
+				var resp RespTag
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to unmarshal resp: %v", err)
+				}
+				assert.DeepEqual(t, resp, tt.want)
+			}
+		})
+	}
+	assert.DeepEqual(t, tagsOf(), []RespTag{{"frontend", 1}, {"server", 2}, {"urgent", 1}})
+	task, err = db.Get(1)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, task.Tags, []string{"server"})
+	assert.Equal(t, task.Version, 3)
+
+	// the tasks are replaced page by page
+	for i := 0; i < tagPageSize+1; i++ {
+		w := do(http.MethodPost, "/tasks", "application/json", `{"name":"bulk","tags":["bulk"]}`)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	w := do(http.MethodPost, "/tags/bulk/rename", "application/json", `{"name":"mass"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.DeepEqual(t, tagsOf(), []RespTag{{"frontend", 1}, {"mass", tagPageSize + 1}, {"server", 2}, {"urgent", 1}})
+
+	// tags are patched as the other fields
+	w = do(http.MethodPatch, "/tasks/4", mediaJSONPatch, `[{"op":"add","path":"/tags","value":["a","a","b"]}]`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var patched RespTask
+	if err := json.Unmarshal(w.Body.Bytes(), &patched); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.DeepEqual(t, patched.Tags, []string{"a", "b"})
+}
@@ -1,15 +1,25 @@
 package httphandler
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 
 	"glookbs.github.com/docs"
 	"glookbs.github.com/entity"
 	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
 )
 
 func init() {
@@ -20,34 +30,205 @@ func init() {
 	docs.SwaggerInfo.Schemes = []string{"http"}
 }
 
+// Option is an option form to configure the handler
+type Option func(*config)
+
+type config struct {
+	idempotencyWindow time.Duration
+	projects          *storage.Storage[*entity.Project]
+	parentDeletion    ParentDeletion
+}
+
+// WithIdempotencyWindow sets how long the first response of an Idempotency-Key is
+// replayed for the retries, Idempotency-Key is ignored if window is 0
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(c *config) {
+		c.idempotencyWindow = window
+	}
+}
+
+// WithProjects sets the storage of projects, the projects are kept in memory without it
+func WithProjects(db *storage.Storage[*entity.Project]) Option {
+	return func(c *config) {
+		c.projects = db
+	}
+}
+
+// WithParentDeletion sets what is done with the subtasks of a deleted task, a task with
+// subtasks is not deleted by default
+func WithParentDeletion(p ParentDeletion) Option {
+	return func(c *config) {
+		c.parentDeletion = p
+	}
+}
+
 // New returns http handler which is implemented by go-gin
-func New(mode string, storage *storage.Storage) http.Handler {
+func New(mode string, db *storage.Storage[*entity.Task], opts ...Option) http.Handler {
+	c := config{idempotencyWindow: defaultIdempotencyWindow, parentDeletion: ParentDeletionReject}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.projects == nil {
+		c.projects = storage.New[*entity.Project](skiplists.New[*entity.Project]())
+	}
+	addIndexes(db)
 	task := &Task{
-		db: storage,
+		db:             db,
+		idempotency:    newIdempotencyStore(c.idempotencyWindow),
+		tree:           storage.AddTree(db, filterByParent, func(t *entity.Task) int { return t.ParentID }),
+		parentDeletion: c.parentDeletion,
+	}
+	project := &Project{
+		db:    c.projects,
+		tasks: task,
+		ref:   storage.AddReference(db, filterByProject, c.projects, func(t *entity.Task) int { return t.ProjectID }),
 	}
 	r := gin.Default()
 	r.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	r.GET("/workflow", task.Workflow)
+
+	tags := r.Group("/tags")
+	{
+		tags.GET("", task.GetTags)
+		tags.POST("/:tag/rename", task.RenameTag)
+		tags.POST("/:tag/merge", task.MergeTag)
+	}
 
 	tasks := r.Group("/tasks")
 	{
 		tasks.GET("", task.Get)
-		tasks.POST("", task.Post)
+		tasks.GET("/search", task.Search)
+		tasks.GET("/:id", task.GetByID)
+		tasks.GET("/:id/subtree", task.Subtree)
+		tasks.POST("", task.idempotency.handle, task.Post)
 		tasks.PUT("/:id", task.Put)
+		tasks.PATCH("/:id", task.Patch)
 		tasks.DELETE("/:id", task.Delete)
 	}
+	projects := r.Group("/projects")
+	{
+		projects.GET("", project.Get)
+		projects.GET("/:id", project.GetByID)
+		projects.POST("", project.Post)
+		projects.PUT("/:id", project.Put)
+		projects.DELETE("/:id", project.Delete)
+		projects.GET("/:id/tasks", project.GetTasks)
+		projects.POST("/:id/tasks", project.PostTask)
+	}
+	// gin can't route the literal colon of POST /tasks:batch, so it's dispatched by NoRoute
+	r.NoRoute(func(c *gin.Context) {
+		if c.Request.Method == http.MethodPost && c.Request.URL.Path == "/tasks:batch" {
+			task.Batch(c)
+		}
+	})
 
 	return r
 }
 
 type Task struct {
-	db *storage.Storage
+	db *storage.Storage[*entity.Task]
+	// idempotency keeps the responses of POST by Idempotency-Key
+	idempotency *idempotencyStore
+	// tree is the reference of subtasks to their parents
+	tree           *storage.Tree[*entity.Task]
+	parentDeletion ParentDeletion
 }
 
-// Get returns tasks
+// the names of the indexes of tasks, they're the values of the sort query, and the
+// filters of the indexed fields are looked up by the indexes
+const (
+	sortByID        = "id"
+	sortByName      = "name"
+	sortByStatus    = "status"
+	sortByPriority  = "priority"
+	sortByDueAt     = "due_at"
+	sortByCreatedAt = "created_at"
+	sortByUpdatedAt = "updated_at"
+	// searchByName is the name of the full-text index of task names
+	searchByName = "name"
+	// filterByProject is the name of the reference of tasks to their projects, it's
+	// also the index of the project filter
+	filterByProject = "project_id"
+	// filterByTags is the name of the tag index of tasks
+	filterByTags = "tags"
+	// filterByParent is the name of the tree of tasks, it's also the index of the
+	// parent filter
+	filterByParent = "parent_id"
+)
+
+// addIndexes adds the indexes of tasks for sorting and filtering
+func addIndexes(db *storage.Storage[*entity.Task]) {
+	storage.AddIndex(db, sortByID, func(t *entity.Task) int { return t.ID })
+	storage.AddIndex(db, sortByName, func(t *entity.Task) string { return t.Name })
+	storage.AddIndex(db, sortByStatus, func(t *entity.Task) int { return int(t.Status) })
+	storage.AddIndex(db, sortByPriority, func(t *entity.Task) int { return int(t.Priority) })
+	storage.AddIndex(db, sortByDueAt, dueKey)
+	storage.AddIndex(db, sortByCreatedAt, func(t *entity.Task) int64 { return t.CreatedAt.UnixNano() })
+	storage.AddIndex(db, sortByUpdatedAt, func(t *entity.Task) int64 { return t.UpdatedAt.UnixNano() })
+	storage.AddTextIndex(db, searchByName, func(t *entity.Task) string { return t.Name })
+	storage.AddTagIndex(db, filterByTags, func(t *entity.Task) []string { return t.Tags })
+}
+
+// dueKey is the key of the due date index, the tasks without due date are the last ones
+func dueKey(t *entity.Task) int64 {
+	if t.DueAt.IsZero() {
+		return math.MaxInt64
+	}
+	return t.DueAt.UnixNano()
+}
+
+// stamp sets the server-managed timestamps of the task changed from old at now, old is
+// nil if the task is created. The task is completed in the terminal states of the workflow
+func stamp(task, old *entity.Task, now time.Time) {
+	task.CreatedAt, task.UpdatedAt = now, now
+	if old != nil {
+		task.CreatedAt = old.CreatedAt
+	}
+	w := entity.CurrentWorkflow()
+	switch {
+	case !w.IsTerminal(task.Status):
+		task.CompletedAt = time.Time{}
+	case old != nil && w.IsTerminal(old.Status):
+		task.CompletedAt = old.CompletedAt
+	default:
+		task.CompletedAt = now
+	}
+}
+
+// stampMatch returns the match which stamps task by the current task after match reports
+// true, a nil match matches any task. The match is called under the storage lock, so the
+// task is stamped by the task it replaces even if it was changed after it was read
+func stampMatch(match func(current *entity.Task) bool, task *entity.Task, now time.Time) func(current *entity.Task) bool {
+	return func(current *entity.Task) bool {
+		if match != nil && !match(current) {
+			return false
+		}
+		stamp(task, current, now)
+		return true
+	}
+}
+
+// Get returns tasks by page, or by cursor if the cursor is given. A cursor page
+// starts after the last task of the previous page, so it doesn't shift when tasks
+// are inserted or deleted concurrently. The total is the number of the filtered tasks
 // @Summary returns tasks
 // @tags tasks
 // @Param page query uint false "1"
 // @Param page_size query uint false "10"
+// @Param cursor query string false "next_cursor of the previous page, empty for the first page"
+// @Param status query int false "filter by status, the index of the state in the workflow"
+// @Param project_id query int false "filter by project, 0 for the tasks in no project"
+// @Param parent_id query int false "filter by parent, 0 for the tasks which are no subtasks"
+// @Param tag query []string false "filter by tags, the tasks with any or all of them by tag_mode" collectionFormat(multi)
+// @Param tag_mode query string false "any by default, or all" Enums(any, all)
+// @Param priority query int false "filter by priority" Enums(0, 1, 2, 3, 4)
+// @Param name_prefix query string false "filter by the prefix of name"
+// @Param name_contains query string false "filter by the substring of name"
+// @Param due_after query string false "filter by the due date not before, RFC 3339"
+// @Param due_before query string false "filter by the due date before, RFC 3339"
+// @Param sort query string false "order by, id by default" Enums(id, name, status, priority, due_at, created_at, updated_at)
+// @Param order query string false "asc or desc" Enums(asc, desc)
 // @Produce json
 // @Success 200 {array} RespTaskPagination
 // @Failure 400 {object} RespErr
@@ -59,33 +240,144 @@ func (t *Task) Get(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
 		return
 	}
-	data := t.db.Range(query.Page, query.PageSize)
-	result := RespTaskPagination{
-		Total:    t.db.Count(),
+	t.list(c, query)
+}
+
+// list responds the tasks of the query as Get
+func (t *Task) list(c *gin.Context, query RequestGetTaskQuery) {
+	var (
+		data   []*entity.Task
+		result RespTaskPagination
+		err    error
+		q      = query.query()
+	)
+	if _, ok := c.GetQuery("cursor"); ok {
+		// fetch one more task to know if there is a next page
+		if len(query.Cursor) == 0 {
+			data, result.Total, err = t.db.Find(q, 1, query.PageSize+1)
+		} else {
+			cur, cerr := decodeCursor(query.Cursor, q)
+			if cerr != nil {
+				c.JSON(http.StatusBadRequest, RespErr{Err: cerr.Error()})
+				return
+			}
+			data, result.Total, err = t.db.FindAfter(q, cur.task(), query.PageSize+1)
+		}
+		if len(data) > query.PageSize {
+			data = data[:query.PageSize]
+			result.NextCursor = newCursor(data[len(data)-1], q).encode()
+		}
+	} else {
+		data, result.Total, err = t.db.Find(q, query.Page, query.PageSize)
+		result.Page = query.Page
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		return
+	}
+	result.PageSize = query.PageSize
+	result.Tasks = make([]RespTask, 0, len(data))
+	for i := range data {
+		result.Tasks = append(result.Tasks, t.respTask(data[i]))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Search returns tasks whose names match all the words of q, ordered by relevance
+// @Summary search tasks by name
+// @tags tasks
+// @Param q query string true "words, a word matches the words of name starting with it"
+// @Param page query uint false "1"
+// @Param page_size query uint false "10"
+// @Produce json
+// @Success 200 {object} RespSearchTasks
+// @Failure 400 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /tasks/search [get]
+func (t *Task) Search(c *gin.Context) {
+	var query RequestSearchTaskQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	results, total, err := t.db.Search(searchByName, query.Q, query.Page, query.PageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		return
+	}
+	resp := RespSearchTasks{
 		Page:     query.Page,
 		PageSize: query.PageSize,
-		Tasks:    make([]RespTask, 0, len(data)),
+		Total:    total,
+		Tasks:    make([]RespSearchTask, 0, len(results)),
 	}
-	for i := range data {
-		rt := RespTask{
-			ID:     data[i].(*entity.Task).ID,
-			Name:   data[i].(*entity.Task).Name,
-			Status: int(data[i].(*entity.Task).Status),
+	for _, r := range results {
+		rt := RespSearchTask{
+			RespTask:   t.respTask(r.Data),
+			Score:      r.Score,
+			Highlights: make([]RespHighlight, 0, len(r.Highlights)),
+		}
+		for _, h := range r.Highlights {
+			rt.Highlights = append(rt.Highlights, RespHighlight{Start: h.Start, End: h.End})
 		}
-		result.Tasks = append(result.Tasks, rt)
+		resp.Tasks = append(resp.Tasks, rt)
 	}
-	c.JSON(http.StatusOK, result)
+	c.JSON(http.StatusOK, resp)
 }
 
-// Post creates a task
+// GetByID returns task by id, it responds 304 without the task if If-None-Match matches
+// the ETag of the task
+// @Summary returns task by id
+// @tags tasks
+// @Param id path string true "id"
+// @Param If-None-Match header string false "etags of the cached task"
+// @Produce json
+// @Success 200 {object} RespTask
+// @Success 304
+// @Header 200,304 {string} ETag "version of the task"
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Router /tasks/{id} [get]
+func (t *Task) GetByID(c *gin.Context) {
+	var req RequestGetTask
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	task, err := t.db.Get(req.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, RespErr{Err: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		return
+	}
+	c.Header("ETag", etag(task))
+	if notModified(c, task) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, t.respTask(task))
+}
+
+// Post creates a task. The retries with the same Idempotency-Key and body are responded
+// with the first response without creating another task
 // @Summary create task
 // @tags tasks
 // @Accept  json
+// @Param Idempotency-Key header string false "unique key of the request and its retries"
 // @Param request body RequsetCreateTask true "request data"
 // @Produce json
 // @Success 200 {object} RespCreateTaskOK
+// @Header 200 {string} ETag "version of the task"
+// @Header 200 {string} Idempotent-Replayed "true if the response is replayed"
 // @Failure 400 {object} RespErr
+// @Failure 413 {object} RespErr
+// @Failure 422 {object} RespErr
 // @Failure 500 {object} RespErr
+// @Failure 507 {object} RespErr
 // @Router /tasks [post]
 func (t *Task) Post(c *gin.Context) {
 	var req RequsetCreateTask
@@ -93,26 +385,40 @@ func (t *Task) Post(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
 		return
 	}
-	task := entity.Task{
-		Name:   req.Name,
-		Status: entity.TaskStatus(req.Status),
-	}
-	id, err := t.db.Insert(&task)
+	t.create(c, req)
+}
+
+// create creates the task of the request as Post
+func (t *Task) create(c *gin.Context, req RequsetCreateTask) {
+	task := req.task(0)
+	stamp(task, nil, time.Now())
+	id, err := t.db.Insert(task)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		respStorageErr(c, err)
 		return
 	}
+	c.Header("ETag", etag(task))
 	c.JSON(http.StatusOK, RespCreateTaskOK{ID: id})
 }
 
-// Put create or update task by id
+// Put create or update task by id, If-Match and If-None-Match are honored, e.g.
+// If-None-Match: * only creates the task. The change of status must be allowed by the
+// workflow, it's responded with 409 otherwise
 // @Summary create or update task by id
 // @tags tasks
 // @Param id path string true "id"
+// @Param If-Match header string false "etags of the task to update, or *"
+// @Param If-None-Match header string false "etags of the task not to update, or * to only create"
 // @Param request body RequsetCreateTask true "request data"
 // @Success 200 {object} RespTask
+// @Header 200 {string} ETag "version of the task"
 // @Failure 400 {object} RespErr
+// @Failure 409 {object} RespErr
+// @Failure 412 {object} RespErr
+// @Failure 413 {object} RespErr
+// @Failure 422 {object} RespErr
 // @Failure 500 {object} RespErr
+// @Failure 507 {object} RespErr
 // @Router /tasks/{id} [put]
 func (t *Task) Put(c *gin.Context) {
 	var req RequestPutTask
@@ -126,38 +432,343 @@ func (t *Task) Put(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
 		return
 	}
-	task := entity.Task{
-		ID:     req.ID,
-		Name:   reqCreate.Name,
-		Status: entity.TaskStatus(reqCreate.Status),
+	task := reqCreate.task(req.ID)
+	_, err := t.db.Get(req.ID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		return
 	}
-	if err := t.db.Update(req.ID, &task); err == nil {
-		c.JSON(http.StatusOK, RespTask{
-			ID:     task.ID,
-			Name:   task.Name,
-			Status: int(task.Status),
-		})
+	if err == nil {
+		// the preconditions and the transition are evaluated, and the task is stamped,
+		// against the current task atomically
+		var match func(current *entity.Task) bool
+		if conditional(c) {
+			match = func(current *entity.Task) bool { return checkPreconditions(c, current) }
+		}
+		var transitionErr error
+		err = t.db.CompareAndSwap(req.ID, stampMatch(transitionMatch(match, task.Status, &transitionErr), task, time.Now()), task)
+		if err == nil {
+			c.Header("ETag", etag(task))
+			c.JSON(http.StatusOK, t.respTask(task))
+			return
+		}
+		if errors.Is(err, storage.ErrConflict) && transitionErr != nil {
+			c.JSON(http.StatusConflict, RespErr{Err: transitionErr.Error()})
+			return
+		}
+		if errors.Is(err, storage.ErrConflict) {
+			c.JSON(http.StatusPreconditionFailed, RespErr{Err: ErrPreconditionFailed.Error()})
+			return
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			respStorageErr(c, err)
+			return
+		}
+	}
+
+	// the task doesn't exist
+	if !checkPreconditions(c, nil) {
+		c.JSON(http.StatusPreconditionFailed, RespErr{Err: ErrPreconditionFailed.Error()})
 		return
 	}
+	stamp(task, nil, time.Now())
+	if _, err := t.db.Insert(task); err != nil {
+		respStorageErr(c, err)
+		return
+	}
+	c.Header("ETag", etag(task))
+	c.JSON(http.StatusOK, t.respTask(task))
+}
 
-	_, err := t.db.Insert(&task)
+// Patch updates task by id with json merge patch (RFC 7396) or json patch (RFC 6902),
+// the patch is applied to the document of RespTask, and the result is validated as
+// RequsetCreateTask. If-Match and If-None-Match are honored, and the change of status
+// must be allowed by the workflow
+// @Summary update task by id partially
+// @tags tasks
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Param id path string true "id"
+// @Param If-Match header string false "etags of the task to update, or *"
+// @Param If-None-Match header string false "etags of the task not to update"
+// @Param request body object true "merge patch or json patch"
+// @Produce json
+// @Success 200 {object} RespTask
+// @Header 200 {string} ETag "version of the task"
+// @Failure 400 {object} RespErr
+// @Failure 404 {object} RespErr
+// @Failure 409 {object} RespErr
+// @Failure 412 {object} RespErr
+// @Failure 413 {object} RespErr
+// @Failure 415 {object} RespErr
+// @Failure 422 {object} RespErr
+// @Failure 500 {object} RespErr
+// @Failure 507 {object} RespErr
+// @Router /tasks/{id} [patch]
+func (t *Task) Patch(c *gin.Context) {
+	var req RequestPatchTask
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	mediaType := c.ContentType()
+	if mediaType != mediaMergePatch && mediaType != mediaJSONPatch {
+		c.JSON(http.StatusUnsupportedMediaType, RespErr{Err: "unsupported patch media type " + mediaType})
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, RespTask{
-		ID:     task.ID,
-		Name:   task.Name,
-		Status: int(task.Status),
-	})
+	// the patch is applied to the latest task again if the task was changed after it
+	// was read, so a concurrent update is never lost, and the preconditions are
+	// evaluated against the latest task
+	for retry := 0; ; retry++ {
+		task, err := t.db.Get(req.ID)
+		if errors.Is(err, storage.ErrNotFound) {
+			if c.GetHeader("If-Match") != "" {
+				c.JSON(http.StatusPreconditionFailed, RespErr{Err: ErrPreconditionFailed.Error()})
+				return
+			}
+			c.JSON(http.StatusNotFound, RespErr{Err: err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
+			return
+		}
+		if !checkPreconditions(c, task) {
+			c.JSON(http.StatusPreconditionFailed, RespErr{Err: ErrPreconditionFailed.Error()})
+			return
+		}
+		patched, code, err := applyPatch(task, mediaType, body)
+		if err != nil {
+			c.JSON(code, RespErr{Err: err.Error()})
+			return
+		}
+		// the status of the same version is checked by the CompareAndSwap below
+		if err := illegalTransition(task, patched.Status); err != nil {
+			c.JSON(http.StatusConflict, RespErr{Err: err.Error()})
+			return
+		}
+
+		err = t.db.CompareAndSwap(task.ID, sameVersion(task), patched)
+		if errors.Is(err, storage.ErrConflict) && retry < patchRetries {
+			continue
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			// the task was deleted after it was read
+			c.JSON(http.StatusNotFound, RespErr{Err: err.Error()})
+			return
+		}
+		if errors.Is(err, storage.ErrConflict) {
+			c.JSON(http.StatusConflict, RespErr{Err: err.Error()})
+			return
+		}
+		if err != nil {
+			respStorageErr(c, err)
+			return
+		}
+		c.Header("ETag", etag(patched))
+		c.JSON(http.StatusOK, t.respTask(patched))
+		return
+	}
+}
+
+// patchRetries is the number of times to reapply a patch to a task changed concurrently
+const patchRetries = 3
+
+// sameVersion returns the match of storage.CompareAndSwap for the unchanged task
+func sameVersion(task *entity.Task) func(current *entity.Task) bool {
+	return func(current *entity.Task) bool {
+		return current.Version == task.Version
+	}
 }
 
-// Delete deletes task by id
+// applyPatch returns the task patched by the body of the media type, the status code is
+// returned with the error
+func applyPatch(task *entity.Task, mediaType string, body []byte) (*entity.Task, int, error) {
+	var doc any
+	raw, err := json.Marshal(respTask(task))
+	if err == nil {
+		err = json.Unmarshal(raw, &doc)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if mediaType == mediaMergePatch {
+		var patch any
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		doc = mergePatch(doc, patch)
+	} else {
+		var ops []patchOperation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if doc, err = jsonPatch(doc, ops); err != nil {
+			if errors.Is(err, ErrPatchTestFailed) {
+				return nil, http.StatusConflict, err
+			}
+			return nil, http.StatusBadRequest, err
+		}
+	}
+
+	// validate the patched document as a task
+	var patched taskDocument
+	raw, err = json.Marshal(doc)
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&patched)
+	}
+	if err == nil {
+		err = binding.Validator.ValidateStruct(&patched)
+	}
+	if err == nil && patched.ID != task.ID {
+		err = errors.New("id can't be changed")
+	}
+	if err == nil && patched.Version != task.Version {
+		err = errors.New("version can't be changed")
+	}
+	var completedAt time.Time
+	if patched.CompletedAt != nil {
+		completedAt = *patched.CompletedAt
+	}
+	if err == nil && (!patched.CreatedAt.Equal(task.CreatedAt) || !patched.UpdatedAt.Equal(task.UpdatedAt) ||
+		!completedAt.Equal(task.CompletedAt)) {
+		err = errors.New("created_at, updated_at and completed_at can't be changed")
+	}
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	result := patched.task(task.ID)
+	stamp(result, task, time.Now())
+	return result, http.StatusOK, nil
+}
+
+// Batch creates, updates and deletes tasks by the operations in order under a single
+// storage lock, and responds the result of every operation. An update never creates
+// the task, an update of status not allowed by the workflow is 409, and the subtasks
+// of a delete are decided by the ParentDeletion as Delete. If atomic, either all the
+// operations are applied or none of them, and the operations not applied because of
+// another failed one are 424 failed dependency
+// @Summary create, update and delete tasks in batch
+// @tags tasks
+// @Accept json
+// @Param request body RequestBatchTasks true "operations"
+// @Produce json
+// @Success 200 {object} RespBatchTasks
+// @Failure 400 {object} RespErr
+// @Router /tasks:batch [post]
+func (t *Task) Batch(c *gin.Context) {
+	var req RequestBatchTasks
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
+		return
+	}
+	resp := RespBatchTasks{Results: make([]RespBatchResult, len(req.Operations))}
+	ops := make([]storage.BatchOp[*entity.Task], 0, len(req.Operations))
+	// positions are the indexes of ops in the operations
+	positions := make([]int, 0, len(req.Operations))
+	// transitionErrs are the errors of the transitions of the updates in ops
+	transitionErrs := make([]error, len(req.Operations))
+	invalid := false
+	for i, reqOp := range req.Operations {
+		op, err := t.batchOp(reqOp)
+		if err != nil {
+			resp.Results[i] = RespBatchResult{Status: http.StatusBadRequest, Err: err.Error()}
+			invalid = true
+			continue
+		}
+		if op.Kind == storage.OpUpdate {
+			op.Match = stampMatch(transitionMatch(op.Match, op.Data.Status, &transitionErrs[len(ops)]), op.Data, time.Now())
+		}
+		ops = append(ops, op)
+		positions = append(positions, i)
+	}
+
+	if invalid && req.Atomic {
+		for _, i := range positions {
+			resp.Results[i] = RespBatchResult{Status: http.StatusFailedDependency, Err: storage.ErrBatchAborted.Error()}
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	for k, err := range t.batch(ops, req.Atomic) {
+		result := &resp.Results[positions[k]]
+		switch {
+		case errors.Is(err, storage.ErrConflict) && transitionErrs[k] != nil:
+			*result = RespBatchResult{Status: http.StatusConflict, Err: transitionErrs[k].Error()}
+		case err != nil:
+			*result = RespBatchResult{Status: storageErrCode(err), Err: err.Error()}
+		case ops[k].Kind == storage.OpDelete:
+			result.Status = http.StatusAccepted
+		default:
+			task := t.respTask(ops[k].Data)
+			*result = RespBatchResult{Status: http.StatusOK, Task: &task}
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// batchOp returns the storage operation of the batch operation, it returns the error if
+// the operation is invalid
+func (t *Task) batchOp(req RequestBatchOperation) (storage.BatchOp[*entity.Task], error) {
+	var op storage.BatchOp[*entity.Task]
+	switch req.Op {
+	case batchCreate:
+		op.Kind = storage.OpInsert
+	case batchUpdate:
+		op.Kind = storage.OpUpdate
+	case batchDelete:
+		op.Kind = storage.OpDelete
+	default:
+		return op, fmt.Errorf("unknown op %q", req.Op)
+	}
+	if op.Kind != storage.OpInsert {
+		if req.ID < 1 {
+			return op, errors.New("id is required")
+		}
+		op.ID = req.ID
+	}
+	if op.Kind != storage.OpDelete {
+		if req.Task == nil {
+			return op, errors.New("task is required")
+		}
+		if err := binding.Validator.ValidateStruct(req.Task); err != nil {
+			return op, err
+		}
+		op.Data = req.Task.task(op.ID)
+		// an update is stamped by its Match, see Batch
+		if op.Kind == storage.OpInsert {
+			stamp(op.Data, nil, time.Now())
+		}
+	}
+	if len(req.IfMatch) > 0 {
+		if op.Kind == storage.OpInsert {
+			return op, errors.New("if_match is only for update and delete")
+		}
+		op.Match = func(current *entity.Task) bool {
+			return matchETag(req.IfMatch, etag(current), false)
+		}
+	}
+	return op, nil
+}
+
+// Delete deletes task by id, If-Match and If-None-Match are honored. The subtasks are
+// deleted or kept by the config, or it's responded with 409 if the task has subtasks
 // @Summary deletes task by id
 // @tags tasks
 // @Param id path string true "id"
+// @Param If-Match header string false "etags of the task to delete, or *"
+// @Param If-None-Match header string false "etags of the task not to delete"
 // @Success 202
 // @Failure 400 {object} RespErr
+// @Failure 409 {object} RespErr
+// @Failure 412 {object} RespErr
 // @Failure 500 {object} RespErr
 // @Router /tasks/{id} [delete]
 func (t *Task) Delete(c *gin.Context) {
@@ -166,9 +777,49 @@ func (t *Task) Delete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, RespErr{Err: err.Error()})
 		return
 	}
-	if err := t.db.Delete(req.ID); err != nil {
+	var match func(current *entity.Task) bool
+	if conditional(c) {
+		match = func(current *entity.Task) bool { return checkPreconditions(c, current) }
+	}
+	err := t.deleteTask(req.ID, match)
+	if errors.Is(err, storage.ErrConflict) || (errors.Is(err, storage.ErrNotFound) && !checkPreconditions(c, nil)) {
+		c.JSON(http.StatusPreconditionFailed, RespErr{Err: ErrPreconditionFailed.Error()})
+		return
+	}
+	if errors.Is(err, storage.ErrReferenced) {
+		c.JSON(http.StatusConflict, RespErr{Err: err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, RespErr{Err: err.Error()})
 		return
 	}
 	c.Writer.WriteHeader(http.StatusAccepted)
 }
+
+// respTask returns the response of the task
+func respTask(task *entity.Task) RespTask {
+	return RespTask{
+		ID:          task.ID,
+		Name:        task.Name,
+		Status:      int(task.Status),
+		Description: task.Description,
+		Priority:    int(task.Priority),
+		DueAt:       optionalTime(task.DueAt),
+		CreatedAt:   task.CreatedAt,
+		UpdatedAt:   task.UpdatedAt,
+		CompletedAt: optionalTime(task.CompletedAt),
+		Version:     task.Version,
+		ProjectID:   task.ProjectID,
+		ParentID:    task.ParentID,
+		Tags:        task.Tags,
+	}
+}
+
+// optionalTime returns nil for the zero time, so it's omitted from the response
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -3,20 +3,54 @@ package httphandler
 import (
 	"bytes"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	gocmp "github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"glookbs.github.com/entity"
 	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/btree"
 	"glookbs.github.com/storage/drivers/skiplists"
 	"gotest.tools/assert"
 )
 
+// ignoreTimes ignores the timestamps set by the server when comparing tasks
+var ignoreTimes = gocmp.Options{
+	cmpopts.IgnoreFields(RespTask{}, "CreatedAt", "UpdatedAt", "CompletedAt"),
+	cmpopts.IgnoreFields(entity.Task{}, "CreatedAt", "UpdatedAt", "CompletedAt"),
+}
+
+// requester serves the request by a router and returns the response, the content type
+// is not set if empty
+type requester func(method, path, contentType, body string) *httptest.ResponseRecorder
+
+// newRequester returns the requester of the router
+func newRequester(t *testing.T, router http.Handler) requester {
+	return func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+}
+
 func TestCreateTask(t *testing.T) {
-	router := New(gin.TestMode, storage.New(skiplists.New()))
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
 	testcase := []struct {
 		requests []RequsetCreateTask
 		want     []RespCreateTaskOK
@@ -64,7 +98,7 @@ func TestCreateTask(t *testing.T) {
 }
 
 func TestGetTasks(t *testing.T) {
-	router := New(gin.TestMode, storage.New(skiplists.New()))
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
 	// add tasks
 	requests := []RequsetCreateTask{
 		{
@@ -90,9 +124,10 @@ func TestGetTasks(t *testing.T) {
 
 	for i, req := range requests {
 		expected.Tasks = append(expected.Tasks, RespTask{
-			ID:     i + 1,
-			Name:   req.Name,
-			Status: req.Status,
+			ID:      i + 1,
+			Name:    req.Name,
+			Status:  req.Status,
+			Version: 1,
 		})
 	}
 
@@ -123,11 +158,11 @@ func TestGetTasks(t *testing.T) {
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("failed to unmarshal resp: %v", err)
 	}
-	assert.DeepEqual(t, resp, expected)
+	assert.DeepEqual(t, resp, expected, ignoreTimes)
 }
 
 func TestDeleteTask(t *testing.T) {
-	router := New(gin.TestMode, storage.New(skiplists.New()))
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
 	// add tasks
 	requests := []RequsetCreateTask{
 		{
@@ -150,14 +185,16 @@ func TestDeleteTask(t *testing.T) {
 		PageSize: 10,
 		Tasks: []RespTask{
 			{
-				ID:     1,
-				Name:   "t1",
-				Status: 0,
+				ID:      1,
+				Name:    "t1",
+				Status:  0,
+				Version: 1,
 			},
 			{
-				ID:     3,
-				Name:   "t3",
-				Status: 1,
+				ID:      3,
+				Name:    "t3",
+				Status:  1,
+				Version: 1,
 			},
 		},
 	}
@@ -198,11 +235,11 @@ func TestDeleteTask(t *testing.T) {
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("failed to unmarshal resp: %v", err)
 	}
-	assert.DeepEqual(t, resp, expected)
+	assert.DeepEqual(t, resp, expected, ignoreTimes)
 }
 
 func TestCreateOrUpdateTask(t *testing.T) {
-	router := New(gin.TestMode, storage.New(skiplists.New()))
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
 	// add tasks
 	requests := []RequsetCreateTask{
 		{
@@ -225,19 +262,22 @@ func TestCreateOrUpdateTask(t *testing.T) {
 		PageSize: 10,
 		Tasks: []RespTask{
 			{
-				ID:     1,
-				Name:   "t1",
-				Status: 0,
+				ID:      1,
+				Name:    "t1",
+				Status:  0,
+				Version: 1,
 			},
 			{
-				ID:     2,
-				Name:   "t2",
-				Status: 1,
+				ID:      2,
+				Name:    "t2",
+				Status:  1,
+				Version: 1,
 			},
 			{
-				ID:     3,
-				Name:   "t3",
-				Status: 1,
+				ID:      3,
+				Name:    "t3",
+				Status:  1,
+				Version: 1,
 			},
 		},
 	}
@@ -271,7 +311,7 @@ func TestCreateOrUpdateTask(t *testing.T) {
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("failed to unmarshal resp: %v", err)
 	}
-	assert.DeepEqual(t, resp, expected)
+	assert.DeepEqual(t, resp, expected, ignoreTimes)
 
 	// modify data with 1
 	newTask := RequsetCreateTask{Name: "rename-t1", Status: 1}
@@ -292,15 +332,16 @@ func TestCreateOrUpdateTask(t *testing.T) {
 		t.Fatalf("failed to unmarshal resp: %v", err)
 	}
 	expectedUpdateTask := RespTask{
-		ID:     1,
-		Name:   "rename-t1",
-		Status: 1,
+		ID:      1,
+		Name:    "rename-t1",
+		Status:  1,
+		Version: 2,
 	}
-	assert.DeepEqual(t, respTaskUpdated, expectedUpdateTask)
+	assert.DeepEqual(t, respTaskUpdated, expectedUpdateTask, ignoreTimes)
 }
 
 func TestMultipleClientsCreateTaskSimultaneously(t *testing.T) {
-	router := New(gin.TestMode, storage.New(skiplists.New()))
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
 	clients := 10
 	requests := make([]RequsetCreateTask, 0, clients)
 
@@ -349,3 +390,970 @@ func TestMultipleClientsCreateTaskSimultaneously(t *testing.T) {
 		exist[task.ID] = true
 	}
 }
+
+func TestGetTaskByID(t *testing.T) {
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
+	// add tasks
+	requests := []RequsetCreateTask{
+		{
+			Name:   "t1",
+			Status: 0,
+		},
+		{
+			Name:   "t2",
+			Status: 1,
+		},
+	}
+
+	for i := range requests {
+		data, err := json.Marshal(requests[i])
+		if err != nil {
+			t.Fatalf("json marshal error: %v", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// get existing task
+	req, err := http.NewRequest(http.MethodGet, "/tasks/2", nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp RespTask
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.DeepEqual(t, resp, RespTask{ID: 2, Name: "t2", Status: 1, Version: 1}, ignoreTimes)
+
+	// get non-exist task
+	req, err = http.NewRequest(http.MethodGet, "/tasks/3", nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var respErr RespErr
+	if err := json.Unmarshal(w.Body.Bytes(), &respErr); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.Equal(t, respErr.Err, storage.ErrNotFound.Error())
+}
+
+func TestCreateTaskQuotaExceeded(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task](), storage.WithQuota(storage.Quota{MaxItems: 1}))
+	router := New(gin.TestMode, db)
+	before := int64(0)
+	if v, ok := quotaExceeded.Get(storage.QuotaItems).(*expvar.Int); ok {
+		before = v.Value()
+	}
+
+	wantCodes := []int{http.StatusOK, http.StatusInsufficientStorage}
+	for _, want := range wantCodes {
+		data, err := json.Marshal(RequsetCreateTask{Name: "t1"})
+		if err != nil {
+			t.Fatalf("json marshal error: %v", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code)
+	}
+
+	// a new task by put is limited too
+	data, err := json.Marshal(RequsetCreateTask{Name: "t2"})
+	if err != nil {
+		t.Fatalf("json marshal error: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, "/tasks/2", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+
+	var respErr RespErr
+	if err := json.Unmarshal(w.Body.Bytes(), &respErr); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.Equal(t, respErr.Err, "storage quota exceeded: 1 of 1 items in use")
+	assert.Equal(t, quotaExceeded.Get(storage.QuotaItems).(*expvar.Int).Value(), before+2)
+}
+
+func TestGetTasksByCursor(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	router := New(gin.TestMode, db)
+	for i := 1; i <= 5; i++ {
+		if _, err := db.Insert(&entity.Task{Name: fmt.Sprintf("t%d", i)}); err != nil {
+			t.Fatalf("insert task error: %v", err)
+		}
+	}
+
+	getPage := func(cursor string) RespTaskPagination {
+		req, err := http.NewRequest(http.MethodGet, "/tasks?page_size=2&cursor="+cursor, nil)
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp RespTaskPagination
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		return resp
+	}
+
+	var ids []int
+	resp := getPage("")
+	for _, task := range resp.Tasks {
+		ids = append(ids, task.ID)
+	}
+	// the pages after the cursor don't shift by the concurrent deletes and inserts
+	for _, id := range []int{1, 3} {
+		if err := db.Delete(id); err != nil {
+			t.Fatalf("delete task error: %v", err)
+		}
+	}
+	if _, err := db.Insert(&entity.Task{Name: "t6"}); err != nil {
+		t.Fatalf("insert task error: %v", err)
+	}
+	for len(resp.NextCursor) > 0 {
+		resp = getPage(resp.NextCursor)
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.ID)
+		}
+	}
+	assert.DeepEqual(t, ids, []int{1, 2, 4, 5, 6})
+	assert.Equal(t, resp.Total, 4)
+
+	// invalid cursor
+	req, err := http.NewRequest(http.MethodGet, "/tasks?cursor=invalid", nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetTasksWithFilters(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	router := New(gin.TestMode, db)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	tasks := []entity.Task{
+		{Name: "write doc", Status: 0, Priority: entity.PriorityHigh, DueAt: day(3)},
+		{Name: "write test", Status: 1, Priority: entity.PriorityLow},
+		{Name: "review doc", Status: 0, Priority: entity.PriorityHigh, DueAt: day(1)},
+		{Name: "deploy", Status: 0, DueAt: day(2)},
+	}
+	for i := range tasks {
+		if _, err := db.Insert(&tasks[i]); err != nil {
+			t.Fatalf("insert task error: %v", err)
+		}
+	}
+
+	testcases := []struct {
+		query     string
+		wantCode  int
+		wantIDs   []int
+		wantTotal int
+	}{
+		{query: "status=0", wantCode: http.StatusOK, wantIDs: []int{1, 3, 4}, wantTotal: 3},
+		{query: "status=0&page_size=2&page=2", wantCode: http.StatusOK, wantIDs: []int{4}, wantTotal: 3},
+		{query: "name_prefix=write", wantCode: http.StatusOK, wantIDs: []int{1, 2}, wantTotal: 2},
+		{query: "name_contains=doc&status=0", wantCode: http.StatusOK, wantIDs: []int{1, 3}, wantTotal: 2},
+		{query: "name_contains=doc&cursor=&page_size=1", wantCode: http.StatusOK, wantIDs: []int{1}, wantTotal: 2},
+		{query: "name_prefix=none", wantCode: http.StatusOK, wantIDs: []int{}, wantTotal: 0},
+		{query: "priority=3", wantCode: http.StatusOK, wantIDs: []int{1, 3}, wantTotal: 2},
+		{query: "priority=3&status=0&name_prefix=write", wantCode: http.StatusOK, wantIDs: []int{1}, wantTotal: 1},
+		{query: "due_before=2024-01-03T00:00:00Z", wantCode: http.StatusOK, wantIDs: []int{3, 4}, wantTotal: 2},
+		{query: "due_after=2024-01-02T00:00:00Z", wantCode: http.StatusOK, wantIDs: []int{1, 4}, wantTotal: 2},
+		{query: "due_after=2024-01-02T00:00:00Z&due_before=2024-01-03T00:00:00Z", wantCode: http.StatusOK, wantIDs: []int{4}, wantTotal: 1},
+		{query: "due_after=2024-01-01T00:00:00Z&priority=3&sort=id", wantCode: http.StatusOK, wantIDs: []int{1, 3}, wantTotal: 2},
+		{query: "status=2", wantCode: http.StatusBadRequest},
+		{query: "priority=5", wantCode: http.StatusBadRequest},
+		{query: "due_before=tomorrow", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range testcases {
+		req, err := http.NewRequest(http.MethodGet, "/tasks?"+tt.query, nil)
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tt.wantCode, w.Code, tt.query)
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+
+		var resp RespTaskPagination
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		ids := make([]int, 0, len(resp.Tasks))
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.ID)
+		}
+		assert.DeepEqual(t, ids, tt.wantIDs)
+		assert.Equal(t, resp.Total, tt.wantTotal, tt.query)
+	}
+}
+
+func TestGetTasksSorted(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	router := New(gin.TestMode, db)
+	for _, name := range []string{"c", "a", "d", "b"} {
+		body := RequsetCreateTask{Name: name, Status: len(name) % 2, Priority: int(name[0] - 'a')}
+		if name != "d" {
+			// due in the reverse order of name, and d has no due date
+			due := time.Date(2024, 1, int('e'-name[0]), 0, 0, 0, 0, time.UTC)
+			body.DueAt = &due
+		}
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("json marshal error: %v", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	getIDs := func(query string) ([]int, RespTaskPagination) {
+		req, err := http.NewRequest(http.MethodGet, "/tasks?"+query, nil)
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, query)
+
+		var resp RespTaskPagination
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		ids := make([]int, 0, len(resp.Tasks))
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.ID)
+		}
+		return ids, resp
+	}
+
+	testcases := []struct {
+		query string
+		want  []int
+	}{
+		{query: "sort=name", want: []int{2, 4, 1, 3}},
+		{query: "sort=name&order=desc", want: []int{3, 1, 4, 2}},
+		{query: "sort=name&page=2&page_size=3", want: []int{3}},
+		{query: "order=desc", want: []int{4, 3, 2, 1}},
+		{query: "sort=created_at&order=desc", want: []int{4, 3, 2, 1}},
+		{query: "sort=updated_at", want: []int{1, 2, 3, 4}},
+		{query: "sort=priority&order=desc", want: []int{3, 1, 4, 2}},
+		{query: "sort=due_at", want: []int{1, 4, 2, 3}},
+		{query: "sort=due_at&order=desc", want: []int{3, 2, 4, 1}},
+		{query: "sort=id", want: []int{1, 2, 3, 4}},
+	}
+	for _, tt := range testcases {
+		ids, _ := getIDs(tt.query)
+		assert.DeepEqual(t, ids, tt.want)
+	}
+
+	// the cursor walks through the tasks without due date
+	var walked []int
+	for cursor, more := "", true; more; more = len(cursor) > 0 {
+		ids, resp := getIDs("sort=due_at&page_size=1&cursor=" + cursor)
+		walked, cursor = append(walked, ids...), resp.NextCursor
+	}
+	assert.DeepEqual(t, walked, []int{1, 4, 2, 3})
+
+	// the cursor walks through the index of an equal filter in the order of id
+	for _, order := range []string{"asc", "desc"} {
+		walked = nil
+		for cursor, more := "", true; more; more = len(cursor) > 0 {
+			ids, resp := getIDs("status=1&order=" + order + "&page_size=1&cursor=" + cursor)
+			walked, cursor = append(walked, ids...), resp.NextCursor
+			assert.Assert(t, len(walked) <= 4, walked)
+		}
+		want := []int{1, 2, 3, 4}
+		if order == "desc" {
+			want = []int{4, 3, 2, 1}
+		}
+		assert.DeepEqual(t, walked, want)
+	}
+
+	// the cursor keeps the position in the order of name while tasks are renamed
+	ids, resp := getIDs("sort=name&page_size=2&cursor=")
+	assert.DeepEqual(t, ids, []int{2, 4})
+	if err := db.Update(2, &entity.Task{ID: 2, Name: "e"}); err != nil {
+		t.Fatalf("update task error: %v", err)
+	}
+	ids, resp = getIDs("sort=name&page_size=2&cursor=" + resp.NextCursor)
+	assert.DeepEqual(t, ids, []int{1, 3})
+	ids, _ = getIDs("sort=name&page_size=2&cursor=" + resp.NextCursor)
+	assert.DeepEqual(t, ids, []int{2})
+
+	// the cursor of another order is rejected
+	req, err := http.NewRequest(http.MethodGet, "/tasks?sort=status&cursor="+resp.NextCursor, nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSearchTasks(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	router := New(gin.TestMode, db)
+	for _, name := range []string{"write doc", "review", "Write tests for docs"} {
+		if _, err := db.Insert(&entity.Task{Name: name}); err != nil {
+			t.Fatalf("insert task error: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/tasks/search?q=doc+WRI", nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp RespSearchTasks
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.Equal(t, resp.Total, 2)
+	assert.Equal(t, len(resp.Tasks), 2)
+	assert.DeepEqual(t, resp.Tasks[0].RespTask, RespTask{ID: 1, Name: "write doc", Status: 0, Version: 1}, ignoreTimes)
+	assert.DeepEqual(t, resp.Tasks[0].Highlights, []RespHighlight{{Start: 0, End: 3}, {Start: 6, End: 9}})
+	assert.DeepEqual(t, resp.Tasks[1].Highlights, []RespHighlight{{Start: 0, End: 3}, {Start: 16, End: 19}})
+
+	// a task created later is searchable
+	data, err := json.Marshal(RequsetCreateTask{Name: "docs"})
+	if err != nil {
+		t.Fatalf("json marshal error: %v", err)
+	}
+	req, err = http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	req, err = http.NewRequest(http.MethodGet, "/tasks/search?q=docs", nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.Equal(t, resp.Total, 2)
+	assert.Equal(t, resp.Tasks[0].ID, 4)
+
+	// q is required
+	req, err = http.NewRequest(http.MethodGet, "/tasks/search", nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPatchTask(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	router := New(gin.TestMode, db)
+	if _, err := db.Insert(&entity.Task{Name: "t1"}); err != nil {
+		t.Fatalf("insert task error: %v", err)
+	}
+
+	testcases := []struct {
+		name        string
+		id          int
+		contentType string
+		body        string
+		wantCode    int
+		want        RespTask
+	}{
+		{
+			name:        "merge patch status only",
+			id:          1,
+			contentType: mediaMergePatch,
+			body:        `{"status":1}`,
+			wantCode:    http.StatusOK,
+			want:        RespTask{ID: 1, Name: "t1", Status: 1, Version: 2},
+		},
+		{
+			name:        "json patch",
+			id:          1,
+			contentType: mediaJSONPatch + "; charset=utf-8",
+			body:        `[{"op":"test","path":"/status","value":1},{"op":"replace","path":"/name","value":"t2"}]`,
+			wantCode:    http.StatusOK,
+			want:        RespTask{ID: 1, Name: "t2", Status: 1, Version: 3},
+		},
+		{
+			name:        "json patch test failed",
+			id:          1,
+			contentType: mediaJSONPatch,
+			body:        `[{"op":"test","path":"/name","value":"t1"},{"op":"replace","path":"/status","value":0}]`,
+			wantCode:    http.StatusConflict,
+		},
+		{
+			name:        "invalid status",
+			id:          1,
+			contentType: mediaMergePatch,
+			body:        `{"status":2}`,
+			wantCode:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "name is removed",
+			id:          1,
+			contentType: mediaJSONPatch,
+			body:        `[{"op":"remove","path":"/name"}]`,
+			wantCode:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "unknown field",
+			id:          1,
+			contentType: mediaMergePatch,
+			body:        `{"owner":"me"}`,
+			wantCode:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "id is changed",
+			id:          1,
+			contentType: mediaMergePatch,
+			body:        `{"id":2}`,
+			wantCode:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "created_at is changed",
+			id:          1,
+			contentType: mediaMergePatch,
+			body:        `{"created_at":"2024-01-02T00:00:00Z"}`,
+			wantCode:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "malformed patch",
+			id:          1,
+			contentType: mediaJSONPatch,
+			body:        `{"op":"remove"}`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "unsupported media type",
+			id:          1,
+			contentType: "application/json",
+			body:        `{"status":0}`,
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "non-exist task",
+			id:          2,
+			contentType: mediaMergePatch,
+			body:        `{"status":1}`,
+			wantCode:    http.StatusNotFound,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("/tasks/%d", tt.id), bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("create http request error %v", err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp RespTask
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal resp: %v", err)
+			}
+			assert.DeepEqual(t, resp, tt.want, ignoreTimes)
+		})
+	}
+
+	// the failed patches change nothing, and the non-exist task is not created
+	task, err := db.Get(1)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, *task, entity.Task{ID: 1, Name: "t2", Status: 1, Version: 3}, ignoreTimes)
+	assert.Equal(t, db.Count(), 1)
+}
+
+func TestTaskTimestamps(t *testing.T) {
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
+	do := func(method, path, contentType, body string) RespTask {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp RespTask
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal resp: %v", err)
+		}
+		return resp
+	}
+
+	do(http.MethodPost, "/tasks", "application/json",
+		`{"name":"t1","description":"# doc","priority":3,"due_at":"2024-01-02T15:04:05Z"}`)
+	created := do(http.MethodGet, "/tasks/1", "", "")
+	due := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	assert.DeepEqual(t, created, RespTask{ID: 1, Name: "t1", Description: "# doc", Priority: 3, DueAt: &due, Version: 1}, ignoreTimes)
+	assert.Assert(t, !created.CreatedAt.IsZero())
+	assert.Assert(t, created.UpdatedAt.Equal(created.CreatedAt))
+	assert.Assert(t, created.CompletedAt == nil)
+
+	// completed_at is set when the task becomes completed
+	completed := do(http.MethodPatch, "/tasks/1", mediaMergePatch, `{"status":1}`)
+	assert.Assert(t, completed.CreatedAt.Equal(created.CreatedAt))
+	assert.Assert(t, completed.UpdatedAt.After(created.UpdatedAt))
+	assert.Assert(t, completed.CompletedAt != nil && completed.CompletedAt.Equal(completed.UpdatedAt))
+
+	// completed_at is kept while the task is completed
+	updated := do(http.MethodPut, "/tasks/1", "application/json", `{"name":"t1","status":1}`)
+	assert.Assert(t, updated.CreatedAt.Equal(created.CreatedAt))
+	assert.Assert(t, updated.UpdatedAt.After(completed.UpdatedAt))
+	assert.Assert(t, updated.CompletedAt != nil && updated.CompletedAt.Equal(*completed.CompletedAt))
+	assert.Assert(t, updated.DueAt == nil)
+
+	// completed_at is cleared when the task is reopened
+	reopened := do(http.MethodPatch, "/tasks/1", mediaMergePatch, `{"status":0}`)
+	assert.Assert(t, reopened.CompletedAt == nil)
+}
+
+func TestCreateTaskValidation(t *testing.T) {
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
+	testcases := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{name: "all fields", body: `{"name":"t1","status":1,"description":"doc","priority":4,"due_at":"2024-01-02T15:04:05+08:00"}`, wantCode: http.StatusOK},
+		{name: "invalid priority", body: `{"name":"t1","priority":5}`, wantCode: http.StatusBadRequest},
+		{name: "negative priority", body: `{"name":"t1","priority":-1}`, wantCode: http.StatusBadRequest},
+		{name: "invalid due date", body: `{"name":"t1","due_at":"tomorrow"}`, wantCode: http.StatusBadRequest},
+		{name: "too long description", body: fmt.Sprintf(`{"name":"t1","description":"%s"}`, strings.Repeat("a", 10001)), wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("create http request error %v", err)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestCreateTaskLargeDescription(t *testing.T) {
+	engine, err := btree.Open[*entity.Task](filepath.Join(t.TempDir(), "tasks.db"))
+	assert.NilError(t, err)
+	db := storage.New[*entity.Task](engine)
+	defer db.Close()
+	router := New(gin.TestMode, db)
+
+	// the description of the longest length is larger than a page of btree
+	description := strings.Repeat("描述", 5000)
+	body, err := json.Marshal(RequsetCreateTask{Name: "t1", Description: description})
+	assert.NilError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	req, err = http.NewRequest(http.MethodGet, "/tasks/1", nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp RespTask
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.Equal(t, resp.Description, description)
+
+	assert.Equal(t, storageErrCode(btree.ErrValueTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	router := New(gin.TestMode, db)
+	if _, err := db.Insert(&entity.Task{Name: "t1"}); err != nil {
+		t.Fatalf("insert error %v", err)
+	}
+
+	testcases := []struct {
+		name        string
+		method      string
+		id          int
+		header      string
+		value       string
+		body        string
+		wantCode    int
+		wantETag    string
+		wantVersion int
+	}{
+		{
+			name:        "get",
+			method:      http.MethodGet,
+			id:          1,
+			wantCode:    http.StatusOK,
+			wantETag:    `"1"`,
+			wantVersion: 1,
+		},
+		{
+			name:        "get not modified",
+			method:      http.MethodGet,
+			id:          1,
+			header:      "If-None-Match",
+			value:       `W/"1"`,
+			wantCode:    http.StatusNotModified,
+			wantETag:    `"1"`,
+			wantVersion: 1,
+		},
+		{
+			name:        "put with stale etag",
+			method:      http.MethodPut,
+			id:          1,
+			header:      "If-Match",
+			value:       `"0"`,
+			body:        `{"name":"t2","status":0}`,
+			wantCode:    http.StatusPreconditionFailed,
+			wantVersion: 1,
+		},
+		{
+			name:        "put with current etag",
+			method:      http.MethodPut,
+			id:          1,
+			header:      "If-Match",
+			value:       `"1"`,
+			body:        `{"name":"t2","status":0}`,
+			wantCode:    http.StatusOK,
+			wantETag:    `"2"`,
+			wantVersion: 2,
+		},
+		{
+			name:        "put only to create",
+			method:      http.MethodPut,
+			id:          1,
+			header:      "If-None-Match",
+			value:       "*",
+			body:        `{"name":"t3","status":0}`,
+			wantCode:    http.StatusPreconditionFailed,
+			wantVersion: 2,
+		},
+		{
+			name:        "get modified",
+			method:      http.MethodGet,
+			id:          1,
+			header:      "If-None-Match",
+			value:       `"1"`,
+			wantCode:    http.StatusOK,
+			wantETag:    `"2"`,
+			wantVersion: 2,
+		},
+		{
+			name:        "patch with stale etag",
+			method:      http.MethodPatch,
+			id:          1,
+			header:      "If-Match",
+			value:       `"1"`,
+			body:        `{"status":1}`,
+			wantCode:    http.StatusPreconditionFailed,
+			wantVersion: 2,
+		},
+		{
+			name:        "patch with current etag",
+			method:      http.MethodPatch,
+			id:          1,
+			header:      "If-Match",
+			value:       `"1", "2"`,
+			body:        `{"status":1}`,
+			wantCode:    http.StatusOK,
+			wantETag:    `"3"`,
+			wantVersion: 3,
+		},
+		{
+			name:        "delete with stale etag",
+			method:      http.MethodDelete,
+			id:          1,
+			header:      "If-Match",
+			value:       `"2"`,
+			wantCode:    http.StatusPreconditionFailed,
+			wantVersion: 3,
+		},
+		{
+			name:     "delete with current etag",
+			method:   http.MethodDelete,
+			id:       1,
+			header:   "If-Match",
+			value:    `"3"`,
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "put non-exist task with etag",
+			method:   http.MethodPut,
+			id:       1,
+			header:   "If-Match",
+			value:    "*",
+			body:     `{"name":"t4","status":0}`,
+			wantCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:     "delete non-exist task with etag",
+			method:   http.MethodDelete,
+			id:       1,
+			header:   "If-Match",
+			value:    `"3"`,
+			wantCode: http.StatusPreconditionFailed,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, fmt.Sprintf("/tasks/%d", tt.id), bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("create http request error %v", err)
+			}
+			if tt.method == http.MethodPatch {
+				req.Header.Set("Content-Type", mediaMergePatch)
+			}
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			assert.Equal(t, tt.wantETag, w.Header().Get("ETag"))
+			if tt.wantCode == http.StatusNotModified {
+				assert.Equal(t, 0, w.Body.Len())
+			}
+			if tt.wantCode == http.StatusOK {
+				var resp RespTask
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to unmarshal resp: %v", err)
+				}
+				assert.Equal(t, tt.wantVersion, resp.Version)
+			}
+
+			task, err := db.Get(tt.id)
+			if tt.wantVersion == 0 {
+				assert.Equal(t, err, storage.ErrNotFound)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantVersion, task.Version)
+		})
+	}
+}
+
+func TestCreateTaskIdempotently(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task]())
+	router := New(gin.TestMode, db)
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, "/tasks", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("create http request error %v", err)
+		}
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("k1", `{"name":"t1","status":0}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	retry := post("k1", `{"name":"t1","status":0}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 1, db.Count())
+
+	reused := post("k1", `{"name":"t2","status":0}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.Equal(t, 1, db.Count())
+
+	another := post("k2", `{"name":"t1","status":0}`)
+	assert.Equal(t, http.StatusOK, another.Code)
+	assert.Equal(t, 2, db.Count())
+}
+
+func TestBatchTasks(t *testing.T) {
+	db := storage.New(skiplists.New[*entity.Task](), storage.WithQuota(storage.Quota{MaxItems: 3}))
+	router := New(gin.TestMode, db)
+	for _, name := range []string{"t1", "t2"} {
+		if _, err := db.Insert(&entity.Task{Name: name}); err != nil {
+			t.Fatalf("insert error %v", err)
+		}
+	}
+
+	testcases := []struct {
+		name      string
+		body      string
+		wantCode  int
+		want      []int
+		wantTasks []RespTask
+	}{
+		{
+			name:     "malformed",
+			body:     `{"operations":[]}`,
+			wantCode: http.StatusBadRequest,
+			wantTasks: []RespTask{
+				{ID: 1, Name: "t1", Version: 1},
+				{ID: 2, Name: "t2", Version: 1},
+			},
+		},
+		{
+			name: "atomic with invalid operation",
+			body: `{"atomic":true,"operations":[
+				{"op":"create","task":{"name":"t3","status":0}},
+				{"op":"update","id":1,"task":{"name":"","status":0}}]}`,
+			wantCode: http.StatusOK,
+			want:     []int{http.StatusFailedDependency, http.StatusBadRequest},
+			wantTasks: []RespTask{
+				{ID: 1, Name: "t1", Version: 1},
+				{ID: 2, Name: "t2", Version: 1},
+			},
+		},
+		{
+			name: "atomic with failed operation",
+			body: `{"atomic":true,"operations":[
+				{"op":"update","id":1,"task":{"name":"t1","status":1},"if_match":"\"1\""},
+				{"op":"delete","id":2,"if_match":"\"2\""}]}`,
+			wantCode: http.StatusOK,
+			want:     []int{http.StatusFailedDependency, http.StatusPreconditionFailed},
+			wantTasks: []RespTask{
+				{ID: 1, Name: "t1", Version: 1},
+				{ID: 2, Name: "t2", Version: 1},
+			},
+		},
+		{
+			name: "atomic",
+			body: `{"atomic":true,"operations":[
+				{"op":"update","id":1,"task":{"name":"t1","status":1},"if_match":"\"1\""},
+				{"op":"delete","id":2},
+				{"op":"create","task":{"name":"t3","status":0}},
+				{"op":"create","task":{"name":"t4","status":0}}]}`,
+			wantCode: http.StatusOK,
+			want:     []int{http.StatusOK, http.StatusAccepted, http.StatusOK, http.StatusOK},
+			wantTasks: []RespTask{
+				{ID: 1, Name: "t1", Status: 1, Version: 2},
+				{ID: 3, Name: "t3", Version: 1},
+				{ID: 4, Name: "t4", Version: 1},
+			},
+		},
+		{
+			name: "partial",
+			body: `{"operations":[
+				{"op":"create","task":{"name":"t5","status":0}},
+				{"op":"delete","id":3},
+				{"op":"update","id":2,"task":{"name":"t2","status":0}},
+				{"op":"rename","id":1},
+				{"op":"create","task":{"name":"t6","status":0}}]}`,
+			wantCode: http.StatusOK,
+			want: []int{
+				http.StatusInsufficientStorage,
+				http.StatusAccepted,
+				http.StatusNotFound,
+				http.StatusBadRequest,
+				http.StatusOK,
+			},
+			wantTasks: []RespTask{
+				{ID: 1, Name: "t1", Status: 1, Version: 2},
+				{ID: 4, Name: "t4", Version: 1},
+				{ID: 5, Name: "t6", Version: 1},
+			},
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/tasks:batch", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("create http request error %v", err)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantCode == http.StatusOK {
+				var resp RespBatchTasks
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to unmarshal resp: %v", err)
+				}
+				codes := make([]int, 0, len(resp.Results))
+				for _, r := range resp.Results {
+					codes = append(codes, r.Status)
+				}
+				assert.DeepEqual(t, codes, tt.want)
+			}
+
+			tasks := make([]RespTask, 0, db.Count())
+			for _, task := range db.Range(1, db.Count()) {
+				tasks = append(tasks, respTask(task))
+			}
+			assert.DeepEqual(t, tasks, tt.wantTasks, ignoreTimes)
+		})
+	}
+
+	// an update is stamped by the task updated by the previous operation
+	completed, err := db.Get(1)
+	assert.NilError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "/tasks:batch", bytes.NewBufferString(`{"operations":[
+		{"op":"update","id":1,"task":{"name":"t1","status":0}},
+		{"op":"update","id":1,"task":{"name":"t1","status":1}}]}`))
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	task, err := db.Get(1)
+	assert.NilError(t, err)
+	assert.Equal(t, task.Version, completed.Version+2)
+	assert.Assert(t, task.CreatedAt.Equal(completed.CreatedAt))
+	assert.Assert(t, task.CompletedAt.After(completed.CompletedAt), "completed at %v, before %v", task.CompletedAt, completed.CompletedAt)
+
+	// the other routes are still not found
+	req, err = http.NewRequest(http.MethodPost, "/tasks:unknown", bytes.NewBufferString("{}"))
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
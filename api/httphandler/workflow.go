@@ -0,0 +1,64 @@
+package httphandler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"glookbs.github.com/entity"
+)
+
+// ErrIllegalTransition is responded with 409 if the workflow doesn't allow the change of status
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+func init() {
+	// status validates a status by the current workflow
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		_ = v.RegisterValidation("status", func(fl validator.FieldLevel) bool {
+			return entity.CurrentWorkflow().Valid(entity.TaskStatus(fl.Field().Int()))
+		})
+	}
+}
+
+// illegalTransition returns the error of the transition of the task to the status, it's
+// nil if the transition is allowed by the current workflow
+func illegalTransition(task *entity.Task, to entity.TaskStatus) error {
+	w := entity.CurrentWorkflow()
+	if w.CanTransit(task.Status, to) {
+		return nil
+	}
+	return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, w.Name(task.Status), w.Name(to))
+}
+
+// transitionMatch returns the match of storage.CompareAndSwap which also reports false if
+// the current task can't transit to the status, the error of the transition is kept in
+// err then, so it's told from a failed match. A nil match matches any task
+func transitionMatch(match func(current *entity.Task) bool, to entity.TaskStatus, err *error) func(current *entity.Task) bool {
+	return func(current *entity.Task) bool {
+		*err = nil
+		if match != nil && !match(current) {
+			return false
+		}
+		*err = illegalTransition(current, to)
+		return *err == nil
+	}
+}
+
+// Workflow returns the workflow of the statuses of tasks
+// @Summary returns the workflow of task statuses
+// @tags tasks
+// @Produce json
+// @Success 200 {object} RespWorkflow
+// @Router /workflow [get]
+func (t *Task) Workflow(c *gin.Context) {
+	w := entity.CurrentWorkflow()
+	c.JSON(http.StatusOK, RespWorkflow{
+		States:      w.States,
+		Transitions: w.Transitions,
+		Terminal:    w.Terminal,
+	})
+}
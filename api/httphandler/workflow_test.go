@@ -0,0 +1,184 @@
+package httphandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"glookbs.github.com/entity"
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"gotest.tools/assert"
+)
+
+const testWorkflow = `{
+	"states": ["todo", "in-progress", "blocked", "review", "done"],
+	"transitions": {
+		"todo": ["in-progress"],
+		"in-progress": ["blocked", "review"],
+		"blocked": ["in-progress"],
+		"review": ["in-progress", "done"]
+	},
+	"terminal": ["done"]
+}`
+
+// setTestWorkflow sets the workflow of testWorkflow until the end of the test
+func setTestWorkflow(t *testing.T) *entity.Workflow {
+	w, err := entity.ParseWorkflow([]byte(testWorkflow))
+	if err != nil {
+		t.Fatalf("parse workflow error %v", err)
+	}
+	entity.SetWorkflow(w)
+	t.Cleanup(func() { entity.SetWorkflow(entity.DefaultWorkflow) })
+	return w
+}
+
+func TestWorkflow(t *testing.T) {
+	w := setTestWorkflow(t)
+	router := New(gin.TestMode, storage.New(skiplists.New[*entity.Task]()))
+
+	req, err := http.NewRequest(http.MethodGet, "/workflow", nil)
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp RespWorkflow
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.DeepEqual(t, resp, RespWorkflow{States: w.States, Transitions: w.Transitions, Terminal: w.Terminal})
+}
+
+func TestStatusTransitions(t *testing.T) {
+	setTestWorkflow(t)
+	db := storage.New(skiplists.New[*entity.Task]())
+	router := New(gin.TestMode, db)
+	if _, err := db.Insert(&entity.Task{Name: "t1"}); err != nil {
+		t.Fatalf("insert error %v", err)
+	}
+
+	testcases := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantCode    int
+		// wantStatus is the status of the task after the request
+		wantStatus entity.TaskStatus
+	}{
+		{
+			name:     "create with unknown status",
+			method:   http.MethodPost,
+			path:     "/tasks",
+			body:     `{"name":"t2","status":5}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:        "patch to unknown status",
+			method:      http.MethodPatch,
+			path:        "/tasks/1",
+			contentType: mediaMergePatch,
+			body:        `{"status":5}`,
+			wantCode:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "patch with illegal transition",
+			method:      http.MethodPatch,
+			path:        "/tasks/1",
+			contentType: mediaMergePatch,
+			body:        `{"status":4}`,
+			wantCode:    http.StatusConflict,
+		},
+		{
+			name:        "patch with legal transition",
+			method:      http.MethodPatch,
+			path:        "/tasks/1",
+			contentType: mediaMergePatch,
+			body:        `{"status":1}`,
+			wantCode:    http.StatusOK,
+			wantStatus:  1,
+		},
+		{
+			name:       "put with illegal transition",
+			method:     http.MethodPut,
+			path:       "/tasks/1",
+			body:       `{"name":"t1","status":0}`,
+			wantCode:   http.StatusConflict,
+			wantStatus: 1,
+		},
+		{
+			name:       "put with legal transition",
+			method:     http.MethodPut,
+			path:       "/tasks/1",
+			body:       `{"name":"t1","status":3}`,
+			wantCode:   http.StatusOK,
+			wantStatus: 3,
+		},
+		{
+			name:       "put keeping the status",
+			method:     http.MethodPut,
+			path:       "/tasks/1",
+			body:       `{"name":"t1 renamed","status":3}`,
+			wantCode:   http.StatusOK,
+			wantStatus: 3,
+		},
+		{
+			name:       "batch with illegal transition",
+			method:     http.MethodPost,
+			path:       "/tasks:batch",
+			body:       `{"operations":[{"op":"update","id":1,"task":{"name":"t1","status":2}}]}`,
+			wantCode:   http.StatusOK,
+			wantStatus: 3,
+		},
+		{
+			name:       "batch with legal transition",
+			method:     http.MethodPost,
+			path:       "/tasks:batch",
+			body:       `{"operations":[{"op":"update","id":1,"task":{"name":"t1","status":4}}]}`,
+			wantCode:   http.StatusOK,
+			wantStatus: 4,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("create http request error %v", err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantCode, rec.Code, rec.Body.String())
+
+			task, ok := db.Get(1)
+			assert.Assert(t, ok)
+			assert.Equal(t, tt.wantStatus, task.Status)
+		})
+	}
+
+	// the batch reports the illegal transition as a conflict
+	req, err := http.NewRequest(http.MethodPost, "/tasks:batch", bytes.NewBufferString(
+		`{"operations":[{"op":"update","id":1,"task":{"name":"t1","status":0}}]}`))
+	if err != nil {
+		t.Fatalf("create http request error %v", err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var resp RespBatchTasks
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal resp: %v", err)
+	}
+	assert.Equal(t, http.StatusConflict, resp.Results[0].Status)
+
+	// the task is completed in the terminal state
+	task, _ := db.Get(1)
+	assert.Assert(t, !task.CompletedAt.IsZero())
+}
@@ -1,14 +1,37 @@
 package cmd
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"glookbs.github.com/api/httphandler"
+	"glookbs.github.com/entity"
 	"glookbs.github.com/httpserver"
 	"glookbs.github.com/storage"
-	_ "glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/drivers/btree"
+	"glookbs.github.com/storage/drivers/cskiplists"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/drivers/wal"
 
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	registerDrivers[*entity.Task]()
+	registerDrivers[*entity.Project]()
+}
+
+// registerDrivers registers the storage drivers for the data type T
+func registerDrivers[T storage.Entity]() {
+	storage.Register[T]("skiplist", skiplists.Driver[T]{})
+	storage.Register[T]("cskiplist", cskiplists.Driver[T]{})
+	storage.Register[T]("btree", btree.Driver[T]{})
+}
+
 type tlsfile struct {
 	key, cert string
 }
@@ -23,10 +46,24 @@ func (t tlsfile) Cert() string {
 
 func runserver() *cobra.Command {
 	var (
-		addr        string
-		apiMode     string
-		pathTLSKey  string
-		pathTLSCert string
+		addr         string
+		apiMode      string
+		pathTLSKey   string
+		pathTLSCert  string
+		dataDir      string
+		fsync        string
+		snapshot     time.Duration
+		driver       string
+		driverConfig string
+		quota        storage.Quota
+		idempotency  time.Duration
+		workflow     string
+
+		// the storage driver of projects
+		projectDriver string
+		projectConfig string
+
+		parentDeletion string
 	)
 
 	cmd := &cobra.Command{
@@ -34,9 +71,76 @@ func runserver() *cobra.Command {
 		Short: "Run http server",
 		Args:  cobra.MaximumNArgs(4),
 		Run: func(c *cobra.Command, args []string) {
+			if len(workflow) > 0 {
+				data, err := os.ReadFile(workflow)
+				if err != nil {
+					panic(err)
+				}
+				w, err := entity.ParseWorkflow(data)
+				if err != nil {
+					panic(err)
+				}
+				entity.SetWorkflow(w)
+			}
+			deletion, err := httphandler.ParseParentDeletion(parentDeletion)
+			if err != nil {
+				panic(err)
+			}
+			// btree keeps its file on its own, the log would replay the changes into it again
+			if len(dataDir) > 0 && (driver == "btree" || projectDriver == "btree") {
+				panic("btree storage is durable by itself and can't be used with --data-dir")
+			}
+			engine, err := storage.OpenEnginer[*entity.Task](driver, driverConfig)
+			if err != nil {
+				panic(err)
+			}
+			projectEngine, err := storage.OpenEnginer[*entity.Project](projectDriver, projectConfig)
+			if err != nil {
+				panic(err)
+			}
+			if len(dataDir) > 0 {
+				policy, err := wal.ParseSyncPolicy(fsync)
+				if err != nil {
+					panic(err)
+				}
+				engine, err = wal.Open(dataDir, engine, wal.WithSyncPolicy(policy), wal.WithSnapshotInterval(snapshot))
+				if err != nil {
+					panic(err)
+				}
+				// the log of projects is in a subdirectory, which is skipped by the log of tasks
+				projectEngine, err = wal.Open(filepath.Join(dataDir, "projects"), projectEngine,
+					wal.WithSyncPolicy(policy), wal.WithSnapshotInterval(snapshot))
+				if err != nil {
+					panic(err)
+				}
+			}
+			db := storage.New(engine, storage.WithQuota(quota))
+			projects := storage.New(projectEngine)
+			defer func() {
+				// the next startup only loads the snapshot without replaying the log
+				if w, ok := engine.(*wal.WAL[*entity.Task]); ok {
+					if err := w.Snapshot(); err != nil {
+						log.Println("snapshot error:", err)
+					}
+				}
+				if w, ok := projectEngine.(*wal.WAL[*entity.Project]); ok {
+					if err := w.Snapshot(); err != nil {
+						log.Println("snapshot projects error:", err)
+					}
+				}
+				if err := db.Close(); err != nil {
+					log.Println("close storage error:", err)
+				}
+				if err := projects.Close(); err != nil {
+					log.Println("close projects storage error:", err)
+				}
+			}()
+
 			srv := httpserver.New(
 				httpserver.WithAddr(addr),
-				httpserver.WithHandler(httphandler.New(apiMode, &storage.DataStorage)),
+				httpserver.WithHandler(httphandler.New(apiMode, db,
+					httphandler.WithIdempotencyWindow(idempotency), httphandler.WithProjects(projects),
+					httphandler.WithParentDeletion(deletion))),
 			)
 
 			if len(pathTLSCert) > 0 && len(pathTLSKey) > 0 {
@@ -59,6 +163,18 @@ func runserver() *cobra.Command {
 	cmd.Flags().StringVarP(&apiMode, "mode", "m", "debug", "mode of api")
 	cmd.Flags().StringVarP(&pathTLSKey, "tls-key", "k", "", "path of tls key")
 	cmd.Flags().StringVarP(&pathTLSCert, "tls-cert", "c", "", "path of tls cert")
+	cmd.Flags().StringVarP(&driver, "storage", "s", "skiplist", fmt.Sprintf("storage driver: %s", strings.Join(storage.Drivers(), ", ")))
+	cmd.Flags().StringVar(&driverConfig, "storage-config", "", "config of the storage driver, e.g. key1=value1&key2=value2")
+	cmd.Flags().StringVar(&projectDriver, "project-storage", "skiplist", fmt.Sprintf("storage driver of projects: %s", strings.Join(storage.Drivers(), ", ")))
+	cmd.Flags().StringVar(&projectConfig, "project-storage-config", "", "config of the storage driver of projects, e.g. path=./projects.db for btree")
+	cmd.Flags().IntVar(&quota.MaxItems, "max-tasks", 0, "max number of tasks, unlimited if 0")
+	cmd.Flags().Int64Var(&quota.MaxBytes, "max-bytes", 0, "approximate budget of bytes of tasks, unlimited if 0")
+	cmd.Flags().StringVarP(&dataDir, "data-dir", "d", "", "directory of the write-ahead log which wraps the storage driver, disabled if empty, btree is durable without it")
+	cmd.Flags().StringVar(&fsync, "fsync", wal.SyncInterval.String(), "fsync policy of the write-ahead log: always, interval or never")
+	cmd.Flags().DurationVar(&idempotency, "idempotency-window", 24*time.Hour, "how long the response of an Idempotency-Key is replayed, disabled if 0")
+	cmd.Flags().StringVar(&parentDeletion, "parent-deletion", string(httphandler.ParentDeletionReject), "what is done with the subtasks of a deleted task: reject, cascade or orphan")
+	cmd.Flags().StringVar(&workflow, "workflow", "", "path of the json of the workflow of task statuses, 0 incompleted and 1 completed if empty")
+	cmd.Flags().DurationVar(&snapshot, "snapshot-interval", 5*time.Minute, "period of taking snapshot to truncate the write-ahead log, disabled if 0")
 
 	return cmd
 }
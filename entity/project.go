@@ -0,0 +1,39 @@
+package entity
+
+import (
+	"time"
+)
+
+// Project is a list of tasks, a task is in a project by Task.ProjectID
+type Project struct {
+	ID   int
+	Name string
+	// Description is the description of the project in markdown
+	Description string
+	// CreatedAt is set by the server when the project is created
+	CreatedAt time.Time
+	// UpdatedAt is set by the server when the project is created or changed
+	UpdatedAt time.Time
+	// Version is set by the storage, it's increased on every change of the project
+	Version int
+}
+
+// GetID returns the id of the project
+func (p *Project) GetID() int {
+	return p.ID
+}
+
+// SetID sets the id of the project, it's called by the storage on insert
+func (p *Project) SetID(id int) {
+	p.ID = id
+}
+
+// GetVersion returns the version of the project
+func (p *Project) GetVersion() int {
+	return p.Version
+}
+
+// SetVersion sets the version of the project, it's called by the storage on every change
+func (p *Project) SetVersion(version int) {
+	p.Version = version
+}
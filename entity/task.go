@@ -1,21 +1,102 @@
 package entity
 
+import (
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// TaskStatus is the index of the state of the task in the Workflow
 type TaskStatus int
 
+// the states of DefaultWorkflow
 const (
 	TaskIncompleted TaskStatus = iota
 	TaskCompleted
 )
 
+// String returns the name of the status in the current workflow
 func (ts TaskStatus) String() string {
-	return [...]string{
-		"incompleted",
-		"completed",
-	}[ts]
+	return CurrentWorkflow().Name(ts)
+}
+
+type TaskPriority int
+
+const (
+	PriorityNone TaskPriority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = [...]string{
+	"none",
+	"low",
+	"medium",
+	"high",
+	"urgent",
+}
+
+// String returns the name of the priority, or TaskPriority(n) for an unknown one
+func (tp TaskPriority) String() string {
+	if tp < 0 || int(tp) >= len(priorityNames) {
+		return "TaskPriority(" + strconv.Itoa(int(tp)) + ")"
+	}
+	return priorityNames[tp]
 }
 
 type Task struct {
 	ID     int
 	Name   string
 	Status TaskStatus
+	// Description is the description of the task in markdown
+	Description string
+	Priority    TaskPriority
+	// DueAt is the due date of the task, it's zero if the task has no due date
+	DueAt time.Time
+	// CreatedAt is set by the server when the task is created
+	CreatedAt time.Time
+	// UpdatedAt is set by the server when the task is created or changed
+	UpdatedAt time.Time
+	// CompletedAt is set by the server when the task becomes completed, it's zero if the
+	// task is not completed
+	CompletedAt time.Time
+	// Version is set by the storage, it's increased on every change of the task
+	Version int
+	// ProjectID is the id of the project of the task, it's 0 if the task is in no project
+	ProjectID int
+	// ParentID is the id of the parent task of the subtask, it's 0 if the task is no subtask
+	ParentID int
+	// Tags are the distinct tags of the task
+	Tags []string
+}
+
+// GetID returns the id of the task
+func (t *Task) GetID() int {
+	return t.ID
+}
+
+// SetID sets the id of the task, it's called by the storage on insert
+func (t *Task) SetID(id int) {
+	t.ID = id
+}
+
+// GetVersion returns the version of the task
+func (t *Task) GetVersion() int {
+	return t.Version
+}
+
+// SetVersion sets the version of the task, it's called by the storage on every change
+func (t *Task) SetVersion(version int) {
+	t.Version = version
+}
+
+// Size returns the approximate size of the task in bytes for the storage quota
+func (t *Task) Size() int {
+	size := int(unsafe.Sizeof(*t)) + len(t.Name) + len(t.Description)
+	for _, tag := range t.Tags {
+		size += int(unsafe.Sizeof(tag)) + len(tag)
+	}
+	return size
 }
@@ -0,0 +1,20 @@
+package entity
+
+import "testing"
+
+func TestTaskPriorityString(t *testing.T) {
+	testcases := []struct {
+		priority TaskPriority
+		want     string
+	}{
+		{priority: PriorityNone, want: "none"},
+		{priority: PriorityUrgent, want: "urgent"},
+		{priority: PriorityUrgent + 1, want: "TaskPriority(5)"},
+		{priority: -1, want: "TaskPriority(-1)"},
+	}
+	for _, tt := range testcases {
+		if got := tt.priority.String(); got != tt.want {
+			t.Fatalf("name of priority %d should be %v, but got %v", int(tt.priority), tt.want, got)
+		}
+	}
+}
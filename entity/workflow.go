@@ -0,0 +1,154 @@
+package entity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// Workflow defines the statuses of tasks and the transitions between them, the status of
+// a task is the index of its state in States
+type Workflow struct {
+	// States are the names of the statuses
+	States []string `json:"states"`
+	// Transitions are the states which a task can transit to by state, a task can always
+	// keep its state
+	Transitions map[string][]string `json:"transitions"`
+	// Terminal are the states in which a task is completed
+	Terminal []string `json:"terminal"`
+
+	// transitions and terminal are the statuses of Transitions and Terminal
+	transitions map[TaskStatus]map[TaskStatus]bool
+	terminal    map[TaskStatus]bool
+}
+
+// DefaultWorkflow is the workflow of TaskIncompleted and TaskCompleted, a task can be
+// completed and reopened
+var DefaultWorkflow = mustWorkflow(Workflow{
+	States: []string{"incompleted", "completed"},
+	Transitions: map[string][]string{
+		"incompleted": {"completed"},
+		"completed":   {"incompleted"},
+	},
+	Terminal: []string{"completed"},
+})
+
+var workflow atomic.Pointer[Workflow]
+
+func init() {
+	workflow.Store(DefaultWorkflow)
+}
+
+// CurrentWorkflow returns the workflow of tasks, it's DefaultWorkflow unless SetWorkflow
+func CurrentWorkflow() *Workflow {
+	return workflow.Load()
+}
+
+// SetWorkflow sets the workflow of tasks, it's meant to be called on startup
+func SetWorkflow(w *Workflow) {
+	workflow.Store(w)
+}
+
+// ParseWorkflow parses the workflow from json, e.g.
+//
+//	{
+//	  "states": ["todo", "in-progress", "done"],
+//	  "transitions": {"todo": ["in-progress"], "in-progress": ["todo", "done"]},
+//	  "terminal": ["done"]
+//	}
+func ParseWorkflow(data []byte) (*Workflow, error) {
+	var w Workflow
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+	if err := w.compile(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func mustWorkflow(w Workflow) *Workflow {
+	if err := w.compile(); err != nil {
+		panic(err)
+	}
+	return &w
+}
+
+// compile checks the workflow and resolves the names of states to statuses
+func (w *Workflow) compile() error {
+	if len(w.States) == 0 {
+		return errors.New("workflow has no state")
+	}
+	statuses := make(map[string]TaskStatus, len(w.States))
+	for i, name := range w.States {
+		if len(name) == 0 {
+			return errors.New("workflow has a state without name")
+		}
+		if _, ok := statuses[name]; ok {
+			return fmt.Errorf("workflow has the duplicate state %q", name)
+		}
+		statuses[name] = TaskStatus(i)
+	}
+	lookup := func(name string) (TaskStatus, error) {
+		s, ok := statuses[name]
+		if !ok {
+			return 0, fmt.Errorf("workflow has the unknown state %q", name)
+		}
+		return s, nil
+	}
+
+	w.transitions = make(map[TaskStatus]map[TaskStatus]bool, len(w.Transitions))
+	for from, tos := range w.Transitions {
+		s, err := lookup(from)
+		if err != nil {
+			return err
+		}
+		w.transitions[s] = make(map[TaskStatus]bool, len(tos))
+		for _, to := range tos {
+			t, err := lookup(to)
+			if err != nil {
+				return err
+			}
+			w.transitions[s][t] = true
+		}
+	}
+	w.terminal = make(map[TaskStatus]bool, len(w.Terminal))
+	for _, name := range w.Terminal {
+		s, err := lookup(name)
+		if err != nil {
+			return err
+		}
+		w.terminal[s] = true
+	}
+	return nil
+}
+
+// Valid reports whether the status is a state of the workflow
+func (w *Workflow) Valid(s TaskStatus) bool {
+	return s >= 0 && int(s) < len(w.States)
+}
+
+// CanTransit reports whether a task can transit from the status to another, a task of
+// the status out of the workflow, e.g. after the workflow was changed, can transit to
+// any state
+func (w *Workflow) CanTransit(from, to TaskStatus) bool {
+	if !w.Valid(to) {
+		return false
+	}
+	return from == to || !w.Valid(from) || w.transitions[from][to]
+}
+
+// IsTerminal reports whether a task of the status is completed
+func (w *Workflow) IsTerminal(s TaskStatus) bool {
+	return w.terminal[s]
+}
+
+// Name returns the name of the status, it's TaskStatus(s) if s is out of the workflow
+func (w *Workflow) Name(s TaskStatus) string {
+	if !w.Valid(s) {
+		return "TaskStatus(" + strconv.Itoa(int(s)) + ")"
+	}
+	return w.States[s]
+}
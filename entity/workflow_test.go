@@ -0,0 +1,87 @@
+package entity
+
+import (
+	"testing"
+)
+
+func TestParseWorkflow(t *testing.T) {
+	testcases := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			data: `{"states":["todo","doing","done"],"transitions":{"todo":["doing"],"doing":["todo","done"]},"terminal":["done"]}`,
+		},
+		{
+			name:    "no state",
+			data:    `{"states":[]}`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate state",
+			data:    `{"states":["todo","todo"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown state of transition",
+			data:    `{"states":["todo","done"],"transitions":{"todo":["doing"]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown terminal state",
+			data:    `{"states":["todo"],"terminal":["done"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed json",
+			data:    `{"states":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseWorkflow([]byte(tt.data)); (err != nil) != tt.wantErr {
+				t.Fatalf("error should be %v, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWorkflow(t *testing.T) {
+	w, err := ParseWorkflow([]byte(`{"states":["todo","doing","done"],"transitions":{"todo":["doing"],"doing":["todo","done"]},"terminal":["done"]}`))
+	if err != nil {
+		t.Fatal("parse workflow error", err)
+	}
+	testcases := []struct {
+		name     string
+		from, to TaskStatus
+		want     bool
+	}{
+		{name: "allowed", from: 0, to: 1, want: true},
+		{name: "not allowed", from: 0, to: 2, want: false},
+		{name: "keep the state", from: 2, to: 2, want: true},
+		{name: "from terminal state", from: 2, to: 1, want: false},
+		{name: "to unknown state", from: 1, to: 3, want: false},
+		{name: "from unknown state", from: 7, to: 2, want: true},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			if ans := w.CanTransit(tt.from, tt.to); ans != tt.want {
+				t.Fatalf("transition from %v to %v should be %v, but got %v", tt.from, tt.to, tt.want, ans)
+			}
+		})
+	}
+	if !w.IsTerminal(2) || w.IsTerminal(1) {
+		t.Fatalf("only done should be terminal")
+	}
+
+	SetWorkflow(w)
+	defer SetWorkflow(DefaultWorkflow)
+	for status, want := range map[TaskStatus]string{1: "doing", 3: "TaskStatus(3)", -1: "TaskStatus(-1)"} {
+		if ans := status.String(); ans != want {
+			t.Fatalf("name should be %v, but got %v", want, ans)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrBatchAborted is returned for the operations of an atomic batch which were not
+// applied because another operation failed
+var ErrBatchAborted = errors.New("batch was aborted")
+
+var errUnknownOp = errors.New("unknown batch operation")
+
+// OpKind is the kind of a BatchOp
+type OpKind int
+
+const (
+	OpInsert OpKind = iota
+	OpUpdate
+	OpDelete
+	// OpCheck changes nothing, it fails with ErrConflict if any data is selected by Where,
+	// so the other operations only apply while no data is selected
+	OpCheck
+)
+
+// BatchOp is an operation of Batch
+type BatchOp[T Entity] struct {
+	Kind OpKind
+	// ID is the id of the data to update or delete
+	ID int
+	// Data is the data to insert or update with
+	Data T
+	// Match is the match of CompareAndSwap or CompareAndDelete, a nil Match matches any data
+	Match func(current T) bool
+	// Where are the conditions of OpCheck
+	Where []Cond
+}
+
+// Batch applies the operations in order under a single lock, so no other change is
+// interleaved, and returns the error of every operation. The operations fail with the
+// same errors as Insert, CompareAndSwap and CompareAndDelete.
+//
+// If atomic, either all the operations are applied or none of them: the batch is checked
+// against the data before anything is applied, so an OpCheck is checked against the data
+// before the batch wherever it is, and the failed operations get their errors
+// and the others get ErrBatchAborted. The batch is applied in a transaction if the
+// Enginer is a Transactioner, so even a failure of the engine itself is rolled back,
+// otherwise such a failure only aborts the rest operations
+func (s *Storage[T]) Batch(ops []BatchOp[T], atomic bool) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batch(ops, atomic)
+}
+
+// batch is Batch, the caller must hold the lock
+func (s *Storage[T]) batch(ops []BatchOp[T], atomic bool) []error {
+	errs := make([]error, len(ops))
+	if !atomic {
+		for i, op := range ops {
+			errs[i] = s.apply(op)
+		}
+		return errs
+	}
+	if errs, ok := s.checkBatch(ops); !ok {
+		return errs
+	}
+
+	tx, err := s.begin()
+	if err != nil && !errors.Is(err, ErrTxNotSupported) {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for i, op := range ops {
+		if op.Kind == OpCheck {
+			// it's checked by checkBatch against the data before the batch
+			continue
+		}
+		if tx != nil {
+			errs[i] = tx.apply(op)
+		} else {
+			errs[i] = s.apply(op)
+		}
+		if errs[i] == nil {
+			continue
+		}
+		// the applied operations are rolled back with the transaction
+		for j := range errs {
+			if j != i && (j > i || tx != nil) {
+				errs[j] = ErrBatchAborted
+			}
+		}
+		if tx != nil {
+			tx.rollback()
+		}
+		return errs
+	}
+	if tx != nil {
+		if err := tx.commit(); err != nil {
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+func (s *Storage[T]) apply(op BatchOp[T]) error {
+	switch op.Kind {
+	case OpInsert:
+		_, err := s.insert(op.Data)
+		return err
+	case OpUpdate:
+		return s.compareAndSwap(op.ID, op.Match, op.Data)
+	case OpDelete:
+		return s.compareAndDelete(op.ID, op.Match)
+	case OpCheck:
+		return s.checkNone(op.Where)
+	}
+	return errUnknownOp
+}
+
+func (tx *Tx[T]) apply(op BatchOp[T]) error {
+	switch op.Kind {
+	case OpInsert:
+		_, err := tx.Insert(op.Data)
+		return err
+	case OpUpdate:
+		return tx.CompareAndSwap(op.ID, op.Match, op.Data)
+	case OpDelete:
+		return tx.CompareAndDelete(op.ID, op.Match)
+	}
+	return errUnknownOp
+}
+
+// checkNone returns ErrConflict if any data is selected by the conditions, the caller
+// must hold the lock
+func (s *Storage[T]) checkNone(where []Cond) error {
+	p, err := s.plan(Query[T]{Where: where})
+	if err != nil {
+		return err
+	}
+	if s.count(p) > 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// checkBatch returns the errors of the operations as if they were applied in order, it
+// reports false if any of them would fail, and the others get ErrBatchAborted then. The
+// caller must hold the lock
+func (s *Storage[T]) checkBatch(ops []BatchOp[T]) ([]error, bool) {
+	// the data changed by the checked operations, a deleted data is nil
+	changed := make(map[int]*T)
+	get := func(id int) (T, bool) {
+		if data, ok := changed[id]; ok {
+			if data == nil {
+				var zero T
+				return zero, false
+			}
+			return *data, true
+		}
+		return s.engine.Get(id)
+	}
+	items, bytes := s.engine.Count(), s.bytes
+	// inserted are the data inserted by the checked operations
+	var inserted []T
+
+	errs := make([]error, len(ops))
+	failed := false
+	for i, op := range ops {
+		var size, old int64
+		if op.Kind != OpInsert && op.Kind != OpUpdate && op.Kind != OpDelete && op.Kind != OpCheck {
+			errs[i], failed = errUnknownOp, true
+			continue
+		}
+		if op.Kind == OpCheck {
+			// the indexes are not changed by the checked operations yet
+			errs[i] = s.checkNone(op.Where)
+			failed = failed || errs[i] != nil
+			continue
+		}
+		if op.Kind != OpDelete {
+			if errs[i] = s.checkRefs(op.Data, get); errs[i] != nil {
+				failed = true
+				continue
+			}
+		}
+		if op.Kind == OpInsert {
+			if s.quota.MaxBytes > 0 {
+				size = sizeOf(op.Data)
+			}
+			if errs[i] = s.quota.check(items, bytes, size, 0, false); errs[i] == nil {
+				items++
+				bytes += size
+				inserted = append(inserted, op.Data)
+			}
+			failed = failed || errs[i] != nil
+			continue
+		}
+
+		prev, ok := get(op.ID)
+		switch {
+		case !ok:
+			errs[i] = ErrNotFound
+		case op.Match != nil && !op.Match(prev):
+			errs[i] = ErrConflict
+		case op.Kind == OpUpdate:
+			if s.quota.MaxBytes > 0 {
+				size, old = sizeOf(op.Data), sizeOf(prev)
+			}
+			if errs[i] = s.quota.check(items, bytes, size, old, true); errs[i] == nil {
+				bytes += size - old
+				// the later operations match the version which will be set
+				setVersion(op.Data, versionOf(prev)+1)
+				data := op.Data
+				changed[op.ID] = &data
+			}
+		default:
+			if s.inbound != nil {
+				// the data inserted and updated by the checked operations are not indexed
+				pending := slices.Clip(inserted)
+				for _, data := range changed {
+					if data != nil {
+						pending = append(pending, *data)
+					}
+				}
+				if errs[i] = s.checkReferenced(op.ID, get, pending); errs[i] != nil {
+					break
+				}
+			}
+			if s.quota.MaxBytes > 0 {
+				old = sizeOf(prev)
+			}
+			items--
+			bytes -= old
+			changed[op.ID] = nil
+		}
+		failed = failed || errs[i] != nil
+	}
+	if failed {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = ErrBatchAborted
+			}
+		}
+	}
+	return errs, !failed
+}
@@ -0,0 +1,147 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestBatch(t *testing.T) {
+	isVersion := func(version int) func(*storagetest.Data) bool {
+		return func(current *storagetest.Data) bool { return current.Version == version }
+	}
+	testcases := []struct {
+		name   string
+		quota  storage.Quota
+		atomic bool
+		ops    []storage.BatchOp[*storagetest.Data]
+		want   []error
+		// wantVersions are the versions of the data by id after the batch
+		wantVersions map[int]int
+	}{
+		{
+			name: "partial",
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpInsert, Data: &storagetest.Data{}},
+				{Kind: storage.OpUpdate, ID: 1, Data: &storagetest.Data{ID: 1}, Match: isVersion(2)},
+				{Kind: storage.OpDelete, ID: 9},
+				{Kind: storage.OpUpdate, ID: 1, Data: &storagetest.Data{ID: 1}, Match: isVersion(1)},
+			},
+			want:         []error{nil, storage.ErrConflict, storage.ErrNotFound, nil},
+			wantVersions: map[int]int{1: 2, 2: 1, 3: 1, 4: 1},
+		},
+		{
+			name:   "atomic",
+			atomic: true,
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpInsert, Data: &storagetest.Data{}},
+				{Kind: storage.OpUpdate, ID: 1, Data: &storagetest.Data{ID: 1}, Match: isVersion(1)},
+				// matches the version set by the previous operation
+				{Kind: storage.OpUpdate, ID: 1, Data: &storagetest.Data{ID: 1}, Match: isVersion(2)},
+				{Kind: storage.OpDelete, ID: 2},
+			},
+			want:         []error{nil, nil, nil, nil},
+			wantVersions: map[int]int{1: 3, 3: 1, 4: 1},
+		},
+		{
+			name:   "atomic aborted",
+			atomic: true,
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpDelete, ID: 1},
+				{Kind: storage.OpInsert, Data: &storagetest.Data{}},
+				{Kind: storage.OpUpdate, ID: 1, Data: &storagetest.Data{ID: 1}},
+			},
+			want:         []error{storage.ErrBatchAborted, storage.ErrBatchAborted, storage.ErrNotFound},
+			wantVersions: map[int]int{1: 1, 2: 1, 3: 1},
+		},
+		{
+			name:   "atomic quota",
+			quota:  storage.Quota{MaxItems: 4},
+			atomic: true,
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpInsert, Data: &storagetest.Data{}},
+				{Kind: storage.OpInsert, Data: &storagetest.Data{}},
+			},
+			want:         []error{storage.ErrBatchAborted, storage.ErrQuotaExceeded},
+			wantVersions: map[int]int{1: 1, 2: 1, 3: 1},
+		},
+		{
+			name:   "atomic quota released by delete",
+			quota:  storage.Quota{MaxItems: 4},
+			atomic: true,
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpInsert, Data: &storagetest.Data{}},
+				{Kind: storage.OpDelete, ID: 1},
+				{Kind: storage.OpInsert, Data: &storagetest.Data{}},
+			},
+			want:         []error{nil, nil, nil},
+			wantVersions: map[int]int{2: 1, 3: 1, 4: 1, 5: 1},
+		},
+		{
+			name:   "atomic check",
+			atomic: true,
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpUpdate, ID: 1, Data: &storagetest.Data{ID: 1}},
+				// checked before the update
+				{Kind: storage.OpCheck, Where: []storage.Cond{storage.Eq("version", 2)}},
+			},
+			want:         []error{nil, nil},
+			wantVersions: map[int]int{1: 2, 2: 1, 3: 1},
+		},
+		{
+			name:   "atomic check failed",
+			atomic: true,
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpCheck, Where: []storage.Cond{storage.Eq("version", 1)}},
+				{Kind: storage.OpDelete, ID: 1},
+			},
+			want:         []error{storage.ErrConflict, storage.ErrBatchAborted},
+			wantVersions: map[int]int{1: 1, 2: 1, 3: 1},
+		},
+		{
+			name: "check",
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpUpdate, ID: 1, Data: &storagetest.Data{ID: 1}},
+				{Kind: storage.OpCheck, Where: []storage.Cond{storage.Eq("version", 2)}},
+				{Kind: storage.OpCheck, Where: []storage.Cond{storage.Eq("version", 3)}},
+			},
+			want:         []error{nil, storage.ErrConflict, nil},
+			wantVersions: map[int]int{1: 2, 2: 1, 3: 1},
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := storage.New(skiplists.New[*storagetest.Data](), storage.WithQuota(tt.quota))
+			storage.AddIndex(s, "version", func(d *storagetest.Data) int { return d.Version })
+			for i := 0; i < 3; i++ {
+				if _, err := s.Insert(&storagetest.Data{}); err != nil {
+					t.Fatal("insert error", err)
+				}
+			}
+			errs := s.Batch(tt.ops, tt.atomic)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("errors should be %v, but got %v", tt.want, errs)
+			}
+			for i := range errs {
+				if !errors.Is(errs[i], tt.want[i]) {
+					t.Fatalf("error %d should be %v, but got %v", i, tt.want[i], errs[i])
+				}
+			}
+			if s.Count() != len(tt.wantVersions) {
+				t.Fatalf("count should be %v, but got %v", len(tt.wantVersions), s.Count())
+			}
+			for id, version := range tt.wantVersions {
+				data, err := s.Get(id)
+				if err != nil {
+					t.Fatalf("get %d error %v", id, err)
+				}
+				if data.Version != version {
+					t.Fatalf("version of %d should be %v, but got %v", id, version, data.Version)
+				}
+			}
+		})
+	}
+}
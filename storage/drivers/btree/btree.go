@@ -0,0 +1,623 @@
+// Package btree is a file-backed storage driver for the data more than fits in memory.
+// The data is kept in a page-based B+tree on local disk and only the recently used
+// pages are cached by a buffer pool. The dirty pages are written back with the meta
+// after every change, so the file survives the exit of the process without Close, and
+// they're fsynced on Sync or Close. The file is the durable store itself, it must not be
+// wrapped by a write-ahead log which replays the changes into it again
+package btree
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"glookbs.github.com/storage"
+)
+
+const (
+	// DefaultPageSize is the size of a page of a new file
+	DefaultPageSize = 4096
+	// DefaultPoolPages is the number of pages cached in memory
+	DefaultPoolPages = 256
+
+	minPageSize = 256
+)
+
+var (
+	ErrDataNotFound  = errors.New("data was not found")
+	ErrValueTooLarge = errors.Wrap(storage.ErrTooLarge, "btree")
+	ErrCorrupted     = errors.New("btree file is corrupted")
+	ErrClosed        = errors.New("btree is closed")
+)
+
+var errKeyExists = errors.New("key exists")
+
+// Driver opens BTree as storage.Enginer with the config
+// "path=<file>[&pool_pages=<number of cached pages>][&page_size=<bytes of a page>]", it's
+// registered for the data types by the caller with storage.Register
+type Driver[T storage.Entity] struct{}
+
+func (Driver[T]) Open(config string) (storage.Enginer[T], error) {
+	values, err := url.ParseQuery(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "btree: parse config")
+	}
+	var opts []Option
+	for key := range values {
+		value := values.Get(key)
+		switch key {
+		case "path":
+		case "pool_pages", "page_size":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "btree: parse %s", key)
+			}
+			if key == "pool_pages" {
+				opts = append(opts, WithPoolPages(n))
+			} else {
+				opts = append(opts, WithPageSize(n))
+			}
+		default:
+			return nil, errors.Errorf("btree: unknown config %q", key)
+		}
+	}
+	path := values.Get("path")
+	if len(path) == 0 {
+		return nil, errors.New("btree: path is required")
+	}
+	return Open[T](path, opts...)
+}
+
+type config struct {
+	pageSize  int
+	poolPages int
+}
+
+// Option is an option form to make configuration with BTree
+type Option func(*config)
+
+// WithPageSize sets the page size of a new file, it's ignored for an existing file
+func WithPageSize(n int) Option {
+	return func(c *config) {
+		c.pageSize = max(n, minPageSize)
+	}
+}
+
+// WithPoolPages sets the number of pages cached in memory
+func WithPoolPages(n int) Option {
+	return func(c *config) {
+		c.poolPages = max(n, 1)
+	}
+}
+
+// BTree is a B+tree in a file keyed by id, it implements storage.Enginer.
+// The data is encoded as json, so T should be a pointer to a struct, e.g. *entity.Task
+type BTree[T storage.Entity] struct {
+	mu    sync.Mutex
+	pager *pager
+	err   error // the first I/O error, the tree refuses operations after it
+}
+
+// split is the new right sibling of a split node
+type split struct {
+	key   int // the smallest key of the sibling
+	id    pageID
+	count int
+}
+
+// Open opens the B+tree file at path, the file is created if it does not exist
+func Open[T storage.Entity](path string, opts ...Option) (*BTree[T], error) {
+	c := config{pageSize: DefaultPageSize, poolPages: DefaultPoolPages}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	p, err := openPager(path, c.pageSize, c.poolPages)
+	if err != nil {
+		return nil, err
+	}
+	if p.meta.root == 0 {
+		root, err := p.allocate(kindLeaf)
+		if err != nil {
+			p.file.Close()
+			return nil, err
+		}
+		p.meta.root = root.id
+		if err := p.flush(); err != nil {
+			p.file.Close()
+			return nil, err
+		}
+	}
+	return &BTree[T]{pager: p}, nil
+}
+
+// maxInlineSize makes sure a leaf holds at least 4 values, the larger values are kept
+// in overflow pages
+func (t *BTree[T]) maxInlineSize() int {
+	return min((t.pager.meta.pageSize-nodeHeaderSize)/4-leafEntryOverhead, math.MaxUint16)
+}
+
+// done writes the changes back and evicts the pages after an operation, and keeps the
+// first error
+func (t *BTree[T]) done(err error) error {
+	if err == nil {
+		err = t.pager.writeBack()
+	}
+	if err == nil {
+		err = t.pager.evict()
+	}
+	if err != nil && t.err == nil && !errors.Is(err, ErrDataNotFound) && !errors.Is(err, ErrValueTooLarge) {
+		t.err = err
+	}
+	return err
+}
+
+// encode returns the value of data kept in a leaf, which is an overflow reference for
+// the large data
+func (t *BTree[T]) encode(data T) ([]byte, error) {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode data")
+	}
+	if len(value) > t.maxInlineSize() {
+		return t.writeOverflow(value)
+	}
+	return value, nil
+}
+
+func (t *BTree[T]) decode(value []byte) (T, error) {
+	var data T
+	if isOverflow(value) {
+		var err error
+		if value, err = t.readOverflow(value); err != nil {
+			return data, err
+		}
+	}
+	if err := json.Unmarshal(value, &data); err != nil {
+		return data, errors.Wrap(err, "decode data")
+	}
+	return data, nil
+}
+
+// Insert inserts data and returns id if success, otherwise id=-1 with error
+func (t *BTree[T]) Insert(data T) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return -1, t.err
+	}
+	id := t.pager.meta.maxID + 1
+	data.SetID(id)
+	err := t.put(id, data, false)
+	if err == nil {
+		t.pager.meta.maxID = id
+	}
+	if err := t.done(err); err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// Update replaces the data with id if it does exist
+func (t *BTree[T]) Update(id int, data T) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	return t.done(t.put(id, data, true))
+}
+
+// CompareAndSwap replaces the data of id only if match reports true for the current
+// data, it returns false if the data does not exist or does not match
+func (t *BTree[T]) CompareAndSwap(id int, match func(current T) bool, data T) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	current, err := t.get(id)
+	if errors.Is(err, ErrDataNotFound) {
+		return false, t.done(nil)
+	}
+	if err != nil {
+		return false, t.done(err)
+	}
+	if !match(current) {
+		return false, t.done(nil)
+	}
+	if err := t.done(t.put(id, data, true)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// put puts data under key, the overflow pages of the replaced data are freed
+func (t *BTree[T]) put(key int, data T, replace bool) error {
+	var old []byte
+	if replace {
+		var err error
+		if old, err = t.lookup(key); err != nil {
+			return err
+		}
+	}
+	value, err := t.encode(data)
+	if err != nil {
+		return err
+	}
+	if err := t.putRoot(key, value, replace); err != nil {
+		return err
+	}
+	return t.freeValue(old)
+}
+
+// putRoot puts the value from the root and grows the tree if the root was split
+func (t *BTree[T]) putRoot(key int, value []byte, replace bool) error {
+	s, err := t.putNode(t.pager.meta.root, key, value, replace)
+	if err != nil {
+		return err
+	}
+	if !replace {
+		t.pager.meta.count++
+	}
+	if s == nil {
+		return nil
+	}
+	root, err := t.pager.allocate(kindInternal)
+	if err != nil {
+		return err
+	}
+	root.keys = []int{s.key}
+	root.children = []pageID{t.pager.meta.root, s.id}
+	root.counts = []int{t.pager.meta.count - s.count, s.count}
+	t.pager.meta.root = root.id
+	return nil
+}
+
+// putNode inserts or replaces the value under the page, it returns the new sibling if the page was split
+func (t *BTree[T]) putNode(id pageID, key int, value []byte, replace bool) (*split, error) {
+	n, err := t.pager.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.kind == kindLeaf {
+		i := sort.SearchInts(n.keys, key)
+		exists := i < len(n.keys) && n.keys[i] == key
+		if replace && !exists {
+			return nil, ErrDataNotFound
+		}
+		if !replace && exists {
+			return nil, errors.Wrapf(errKeyExists, "key %d", key)
+		}
+		if exists {
+			n.values[i] = value
+		} else {
+			n.keys = slices.Insert(n.keys, i, key)
+			n.values = slices.Insert(n.values, i, value)
+		}
+		n.dirty = true
+		if n.size() <= t.pager.meta.pageSize {
+			return nil, nil
+		}
+		return t.splitLeaf(n, i == len(n.keys)-1)
+	}
+
+	i := n.childIndex(key)
+	s, err := t.putNode(n.children[i], key, value, replace)
+	if err != nil {
+		return nil, err
+	}
+	if !replace {
+		n.counts[i]++
+		n.dirty = true
+	}
+	if s == nil {
+		return nil, nil
+	}
+	n.counts[i] -= s.count
+	n.keys = slices.Insert(n.keys, i, s.key)
+	n.children = slices.Insert(n.children, i+1, s.id)
+	n.counts = slices.Insert(n.counts, i+1, s.count)
+	n.dirty = true
+	if n.size() <= t.pager.meta.pageSize {
+		return nil, nil
+	}
+	return t.splitInternal(n, i+1 == len(n.children)-1)
+}
+
+// splitLeaf moves the upper half of the values into a new leaf. With appending, which
+// is the common case of the increasing ids, only the last value is moved, so leaves
+// are kept full
+func (t *BTree[T]) splitLeaf(n *node, appending bool) (*split, error) {
+	mid := len(n.keys) - 1
+	if !appending {
+		half, size := n.size()/2, nodeHeaderSize
+		for mid = 0; mid < len(n.keys)-1; mid++ {
+			size += leafEntryOverhead + len(n.values[mid])
+			if size >= half {
+				mid++
+				break
+			}
+		}
+	}
+	right, err := t.pager.allocate(kindLeaf)
+	if err != nil {
+		return nil, err
+	}
+	right.keys = append([]int(nil), n.keys[mid:]...)
+	right.values = append([][]byte(nil), n.values[mid:]...)
+	n.keys = n.keys[:mid:mid]
+	n.values = n.values[:mid:mid]
+	return &split{key: right.keys[0], id: right.id, count: len(right.keys)}, nil
+}
+
+// splitInternal moves the upper half of the children into a new internal node, see splitLeaf
+func (t *BTree[T]) splitInternal(n *node, appending bool) (*split, error) {
+	mid := len(n.children) / 2
+	if appending {
+		mid = len(n.children) - 1
+	}
+	right, err := t.pager.allocate(kindInternal)
+	if err != nil {
+		return nil, err
+	}
+	sep := n.keys[mid-1]
+	right.keys = append([]int(nil), n.keys[mid:]...)
+	right.children = append([]pageID(nil), n.children[mid:]...)
+	right.counts = append([]int(nil), n.counts[mid:]...)
+	n.keys = n.keys[: mid-1 : mid-1]
+	n.children = n.children[:mid:mid]
+	n.counts = n.counts[:mid:mid]
+	return &split{key: sep, id: right.id, count: right.total()}, nil
+}
+
+// Get returns the data with id, returns false if it does not exist
+func (t *BTree[T]) Get(id int) (T, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, err := t.get(id)
+	t.done(err)
+	return data, err == nil
+}
+
+func (t *BTree[T]) get(key int) (T, error) {
+	value, err := t.lookup(key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return t.decode(value)
+}
+
+// lookup returns the value of key in its leaf
+func (t *BTree[T]) lookup(key int) ([]byte, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	n, err := t.pager.get(t.pager.meta.root)
+	for err == nil && n.kind == kindInternal {
+		n, err = t.pager.get(n.children[n.childIndex(key)])
+	}
+	if err != nil {
+		return nil, err
+	}
+	i := sort.SearchInts(n.keys, key)
+	if i == len(n.keys) || n.keys[i] != key {
+		return nil, ErrDataNotFound
+	}
+	return n.values[i], nil
+}
+
+func (t *BTree[T]) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pager.meta.count
+}
+
+// Range returns data with page i and page size j, returns empty slice if no data.
+// The offset is found by the counts of the internal nodes, so only the requested
+// page is read
+func (t *BTree[T]) Range(i, j int) []T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	start := (i - 1) * j
+	if t.err != nil || start < 0 || start >= t.pager.meta.count {
+		return []T{}
+	}
+	result := make([]T, 0, min(j, t.pager.meta.count-start))
+	t.done(t.collect(t.pager.meta.root, start, j, &result))
+	return result
+}
+
+// collect appends the values under the page after skipping skip values until result is full
+func (t *BTree[T]) collect(id pageID, skip, limit int, result *[]T) error {
+	n, err := t.pager.get(id)
+	if err != nil {
+		return err
+	}
+	if n.kind == kindLeaf {
+		for i := skip; i < len(n.values) && len(*result) < limit; i++ {
+			data, err := t.decode(n.values[i])
+			if err != nil {
+				return err
+			}
+			*result = append(*result, data)
+		}
+		return nil
+	}
+	children, counts := n.children, n.counts
+	for c := range children {
+		if skip >= counts[c] {
+			skip -= counts[c]
+			continue
+		}
+		if err := t.collect(children[c], skip, limit, result); err != nil {
+			return err
+		}
+		skip = 0
+		if len(*result) == limit {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Scan returns at most n data whose id is greater than after, ordered by id
+func (t *BTree[T]) Scan(after, n int) []T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil || n <= 0 {
+		return []T{}
+	}
+	result := make([]T, 0, min(n, t.pager.meta.count))
+	t.done(t.scan(t.pager.meta.root, after, n, &result))
+	return result
+}
+
+// scan appends the values under the page whose keys are greater than after until result is full
+func (t *BTree[T]) scan(id pageID, after, limit int, result *[]T) error {
+	n, err := t.pager.get(id)
+	if err != nil {
+		return err
+	}
+	if n.kind == kindLeaf {
+		for i := sort.SearchInts(n.keys, after+1); i < len(n.values) && len(*result) < limit; i++ {
+			data, err := t.decode(n.values[i])
+			if err != nil {
+				return err
+			}
+			*result = append(*result, data)
+		}
+		return nil
+	}
+	children := n.children
+	for c := n.childIndex(after); c < len(children) && len(*result) < limit; c++ {
+		if err := t.scan(children[c], after, limit, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the data with id, returns false if it does not exist.
+// A page which becomes empty is unlinked from the tree and put into the freelist
+func (t *BTree[T]) Delete(id int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return false
+	}
+	found, err := t.deleteRoot(id)
+	t.done(err)
+	return found && err == nil
+}
+
+func (t *BTree[T]) deleteRoot(key int) (bool, error) {
+	value, err := t.lookup(key)
+	if errors.Is(err, ErrDataNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	found, _, err := t.remove(t.pager.meta.root, key)
+	if err != nil || !found {
+		return found, err
+	}
+	t.pager.meta.count--
+	if err := t.freeValue(value); err != nil {
+		return true, err
+	}
+	// shrink the tree while the root has a single child
+	for {
+		root, err := t.pager.get(t.pager.meta.root)
+		if err != nil {
+			return true, err
+		}
+		if root.kind == kindLeaf || len(root.children) > 1 {
+			return true, nil
+		}
+		if len(root.children) == 0 {
+			root.reset(kindLeaf)
+			return true, nil
+		}
+		t.pager.meta.root = root.children[0]
+		t.pager.free(root)
+	}
+}
+
+// remove removes the key under the page, it returns whether the key was found and
+// whether the page became empty
+func (t *BTree[T]) remove(id pageID, key int) (bool, bool, error) {
+	n, err := t.pager.get(id)
+	if err != nil {
+		return false, false, err
+	}
+	if n.kind == kindLeaf {
+		i := sort.SearchInts(n.keys, key)
+		if i == len(n.keys) || n.keys[i] != key {
+			return false, false, nil
+		}
+		n.keys = slices.Delete(n.keys, i, i+1)
+		n.values = slices.Delete(n.values, i, i+1)
+		n.dirty = true
+		return true, len(n.keys) == 0, nil
+	}
+
+	i := n.childIndex(key)
+	child := n.children[i]
+	found, empty, err := t.remove(child, key)
+	if err != nil || !found {
+		return found, false, err
+	}
+	n.counts[i]--
+	n.dirty = true
+	if !empty {
+		return true, false, nil
+	}
+	c, err := t.pager.get(child)
+	if err != nil {
+		return true, false, err
+	}
+	t.pager.free(c)
+	if i > 0 {
+		n.keys = slices.Delete(n.keys, i-1, i)
+	} else if len(n.keys) > 0 {
+		n.keys = slices.Delete(n.keys, 0, 1)
+	}
+	n.children = slices.Delete(n.children, i, i+1)
+	n.counts = slices.Delete(n.counts, i, i+1)
+	return true, len(n.children) == 0, nil
+}
+
+// Err returns the error which stopped the tree from accepting operations
+func (t *BTree[T]) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Sync writes the dirty pages to the file and fsyncs it
+func (t *BTree[T]) Sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	return t.done(t.pager.flush())
+}
+
+// Close flushes and closes the file
+func (t *BTree[T]) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if errors.Is(t.err, ErrClosed) {
+		return ErrClosed
+	}
+	err := t.pager.close()
+	t.err = ErrClosed
+	return err
+}
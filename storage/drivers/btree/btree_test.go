@@ -0,0 +1,251 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"glookbs.github.com/storage/drivers/wal"
+	"glookbs.github.com/storage/storagetest"
+)
+
+// testTree returns a tree with small pages and pool, so splits and evictions happen early
+func testTree(t *testing.T, path string) *BTree[*storagetest.Data] {
+	if len(path) == 0 {
+		path = filepath.Join(t.TempDir(), "test.db")
+	}
+	tree, err := Open[*storagetest.Data](path, WithPageSize(minPageSize), WithPoolPages(4))
+	if err != nil {
+		t.Fatal("open error", err)
+	}
+	return tree
+}
+
+func TestEngine(t *testing.T) {
+	storagetest.TestEngine(t, func(t *testing.T) storagetest.Engine {
+		tree := testTree(t, "")
+		t.Cleanup(func() { tree.Close() })
+		return tree
+	})
+}
+
+func insertN(t *testing.T, tree *BTree[*storagetest.Data], n int) {
+	for i := 1; i <= n; i++ {
+		if _, err := tree.Insert(&storagetest.Data{Name: fmt.Sprintf("v%d", i)}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	tree := testTree(t, path)
+	insertN(t, tree, 50)
+	numPages := tree.pager.meta.numPages
+
+	// the values larger than a page are kept in the overflow pages
+	large := strings.Repeat("large", minPageSize)
+	if err := tree.Update(20, &storagetest.Data{ID: 20, Name: large}); err != nil {
+		t.Fatal("update error", err)
+	}
+	id, err := tree.Insert(&storagetest.Data{Name: large + "2"})
+	if err != nil {
+		t.Fatal("insert error", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal("close error", err)
+	}
+	tree = testTree(t, path)
+	defer tree.Close()
+	for key, want := range map[int]string{20: large, id: large + "2"} {
+		if data, ok := tree.Get(key); !ok || data.Name != want {
+			t.Fatalf("data of %d should be found with %d bytes, but got %v", key, len(want), ok)
+		}
+	}
+	if data := tree.Range(1, 51); len(data) != 51 || data[19].Name != large {
+		t.Fatalf("range should return %d data with the large value, but got %d", 51, len(data))
+	}
+
+	// the overflow pages are freed by the update and the delete, and reused
+	if err := tree.Update(20, &storagetest.Data{ID: 20, Name: "v20"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if !tree.Delete(id) {
+		t.Fatal("data should be deleted")
+	}
+	grown := tree.pager.meta.numPages
+	if grown <= numPages {
+		t.Fatalf("number of pages should be greater than %d, but got %d", numPages, grown)
+	}
+	if _, err := tree.Insert(&storagetest.Data{Name: large}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	if tree.pager.meta.numPages != grown {
+		t.Fatalf("number of pages should be %d, but got %d", grown, tree.pager.meta.numPages)
+	}
+}
+
+// TestRandomOperations compares the tree with a map after random inserts, updates and
+// deletes, including reopening the file
+func TestRandomOperations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	tree := testTree(t, path)
+	model := make(map[int]string)
+	rnd := rand.New(rand.NewSource(1))
+
+	check := func() {
+		keys := make([]int, 0, len(model))
+		for id := 1; id <= tree.pager.meta.maxID; id++ {
+			if _, ok := model[id]; ok {
+				keys = append(keys, id)
+			}
+		}
+		if tree.Count() != len(keys) {
+			t.Fatalf("count should be %d, but got %d", len(keys), tree.Count())
+		}
+		for page := 1; (page-1)*7 < len(keys); page++ {
+			want := keys[(page-1)*7 : min(page*7, len(keys))]
+			data := tree.Range(page, 7)
+			if !reflect.DeepEqual(storagetest.IDs(data), want) {
+				t.Fatalf("page %d should be %v, but got %v", page, want, storagetest.IDs(data))
+			}
+			for _, d := range data {
+				if d.Name != model[d.ID] {
+					t.Fatalf("value of %d should be %v, but got %v", d.ID, model[d.ID], d.Name)
+				}
+			}
+		}
+	}
+
+	for i := 0; i < 3000; i++ {
+		switch op := rnd.Intn(10); {
+		case op < 6:
+			value := fmt.Sprintf("v%d", i)
+			id, err := tree.Insert(&storagetest.Data{Name: value})
+			if err != nil {
+				t.Fatal("insert error", err)
+			}
+			model[id] = value
+		case op < 8 && len(model) > 0:
+			id := rnd.Intn(tree.pager.meta.maxID) + 1
+			value := fmt.Sprintf("u%d", i)
+			err := tree.Update(id, &storagetest.Data{ID: id, Name: value})
+			if _, ok := model[id]; ok != (err == nil) {
+				t.Fatalf("update %d error %v", id, err)
+			}
+			if err == nil {
+				model[id] = value
+			}
+		case len(model) > 0:
+			id := rnd.Intn(tree.pager.meta.maxID) + 1
+			_, ok := model[id]
+			if tree.Delete(id) != ok {
+				t.Fatalf("delete %d should be %v", id, ok)
+			}
+			delete(model, id)
+		}
+	}
+	check()
+
+	if err := tree.Close(); err != nil {
+		t.Fatal("close error", err)
+	}
+	tree = testTree(t, path)
+	defer tree.Close()
+	check()
+
+	// the pages of deleted data are reused
+	for id := range model {
+		tree.Delete(id)
+	}
+	numPages := tree.pager.meta.numPages
+	insertN(t, tree, 100)
+	if tree.pager.meta.numPages != numPages {
+		t.Fatalf("number of pages should be %d, but got %d", numPages, tree.pager.meta.numPages)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	if _, err := (Driver[*storagetest.Data]{}).Open("pool_pages=8"); err == nil {
+		t.Fatal("open without path should be failed")
+	}
+	if _, err := (Driver[*storagetest.Data]{}).Open("path=" + path + "&unknown=1"); err == nil {
+		t.Fatal("open with unknown config should be failed")
+	}
+	engine, err := (Driver[*storagetest.Data]{}).Open("path=" + path + "&pool_pages=8&page_size=512")
+	if err != nil {
+		t.Fatal("open error", err)
+	}
+	tree := engine.(*BTree[*storagetest.Data])
+	defer tree.Close()
+	if tree.pager.capacity != 8 || tree.pager.meta.pageSize != 512 {
+		t.Fatalf("config is not applied, got %d pages and page size %d", tree.pager.capacity, tree.pager.meta.pageSize)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	tree := testTree(t, path)
+	insertN(t, tree, 50)
+	if err := tree.Update(7, &storagetest.Data{ID: 7, Name: "u7"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	tree.Delete(8)
+
+	check := func(tree *BTree[*storagetest.Data], count int) {
+		if tree.Count() != count {
+			t.Fatalf("count should be %d, but got %d", count, tree.Count())
+		}
+		if data, ok := tree.Get(7); !ok || data.Name != "u7" {
+			t.Fatalf("data of 7 should be %v, but got %v", "u7", data)
+		}
+		if _, ok := tree.Get(8); ok {
+			t.Fatal("data of 8 should be deleted")
+		}
+	}
+	// the changes are in the file before Close, as after the exit of the process
+	reopened := testTree(t, path)
+	check(reopened, 49)
+	if err := reopened.Close(); err != nil {
+		t.Fatal("close error", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal("close error", err)
+	}
+
+	tree = testTree(t, path)
+	defer tree.Close()
+	check(tree, 49)
+	if id, err := tree.Insert(&storagetest.Data{Name: "v51"}); err != nil || id != 51 {
+		t.Fatalf("id should be %d, but got %d with error %v", 51, id, err)
+	}
+}
+
+func TestRestartUnderWAL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	w, err := wal.Open[*storagetest.Data](filepath.Join(dir, "wal"), testTree(t, path))
+	if err != nil {
+		t.Fatal("open wal error", err)
+	}
+	if _, err := w.Insert(&storagetest.Data{Name: "v1"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("close error", err)
+	}
+
+	// the log is replayed into the file which has the changes already, so the tree
+	// can't be wrapped by the log, see runserver
+	tree := testTree(t, path)
+	defer tree.Close()
+	if _, err := wal.Open[*storagetest.Data](filepath.Join(dir, "wal"), tree); !errors.Is(err, wal.ErrCorrupted) {
+		t.Fatalf("error should be %v, but got %v", wal.ErrCorrupted, err)
+	}
+}
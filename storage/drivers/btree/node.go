@@ -0,0 +1,189 @@
+package btree
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+type pageID uint32
+
+type nodeKind uint8
+
+const (
+	kindLeaf nodeKind = iota + 1
+	kindInternal
+	kindFree
+	kindOverflow
+)
+
+const (
+	// nodeHeaderSize is the size of kind + number of keys
+	nodeHeaderSize = 3
+	// leafEntryOverhead is the size of key + length of value
+	leafEntryOverhead = 8 + 2
+	// internalEntrySize is the size of key + child + count
+	internalEntrySize = 8 + 4 + 4
+	// overflowHeaderSize is the size of the header + next page + length of data
+	overflowHeaderSize = nodeHeaderSize + 4 + 4
+)
+
+// node is the decoded page of the tree.
+// A leaf keeps the values ordered by keys. An internal node keeps len(keys)+1 children,
+// children[i] contains the keys in [keys[i-1], keys[i]), and counts[i] is the number
+// of values under children[i], which makes the offset lookup of Range logarithmic.
+// A free page keeps the next free page in the freelist, and an overflow page keeps a part
+// of a value too large for a leaf with the next page of the value
+type node struct {
+	id       pageID
+	kind     nodeKind
+	keys     []int
+	values   [][]byte
+	children []pageID
+	counts   []int
+	next     pageID
+	data     []byte
+	dirty    bool
+}
+
+// size returns the encoded size of the node
+func (n *node) size() int {
+	switch n.kind {
+	case kindLeaf:
+		size := nodeHeaderSize
+		for _, v := range n.values {
+			size += leafEntryOverhead + len(v)
+		}
+		return size
+	case kindInternal:
+		return nodeHeaderSize + 8 + len(n.keys)*internalEntrySize
+	case kindOverflow:
+		return overflowHeaderSize + len(n.data)
+	}
+	return nodeHeaderSize + 4
+}
+
+// total returns the number of values under the node
+func (n *node) total() int {
+	if n.kind == kindLeaf {
+		return len(n.keys)
+	}
+	total := 0
+	for _, c := range n.counts {
+		total += c
+	}
+	return total
+}
+
+// childIndex returns the index of the child which may contain key
+func (n *node) childIndex(key int) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return n.keys[i] > key
+	})
+}
+
+// reset clears the node for reusing its page as kind
+func (n *node) reset(kind nodeKind) {
+	n.kind = kind
+	n.keys = nil
+	n.values = nil
+	n.children = nil
+	n.counts = nil
+	n.next = 0
+	n.data = nil
+	n.dirty = true
+}
+
+// encode writes the node into the page buf
+func (n *node) encode(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	buf[0] = byte(n.kind)
+	switch n.kind {
+	case kindLeaf:
+		binary.LittleEndian.PutUint16(buf[1:3], uint16(len(n.keys)))
+		off := nodeHeaderSize
+		for i, key := range n.keys {
+			binary.LittleEndian.PutUint64(buf[off:], uint64(key))
+			binary.LittleEndian.PutUint16(buf[off+8:], uint16(len(n.values[i])))
+			copy(buf[off+leafEntryOverhead:], n.values[i])
+			off += leafEntryOverhead + len(n.values[i])
+		}
+	case kindInternal:
+		binary.LittleEndian.PutUint16(buf[1:3], uint16(len(n.keys)))
+		binary.LittleEndian.PutUint32(buf[3:], uint32(n.children[0]))
+		binary.LittleEndian.PutUint32(buf[7:], uint32(n.counts[0]))
+		off := nodeHeaderSize + 8
+		for i, key := range n.keys {
+			binary.LittleEndian.PutUint64(buf[off:], uint64(key))
+			binary.LittleEndian.PutUint32(buf[off+8:], uint32(n.children[i+1]))
+			binary.LittleEndian.PutUint32(buf[off+12:], uint32(n.counts[i+1]))
+			off += internalEntrySize
+		}
+	case kindFree:
+		binary.LittleEndian.PutUint32(buf[3:], uint32(n.next))
+	case kindOverflow:
+		binary.LittleEndian.PutUint32(buf[3:], uint32(n.next))
+		binary.LittleEndian.PutUint32(buf[7:], uint32(len(n.data)))
+		copy(buf[overflowHeaderSize:], n.data)
+	}
+}
+
+// decodeNode reads the node from the page buf
+func decodeNode(id pageID, buf []byte) (*node, error) {
+	n := &node{id: id, kind: nodeKind(buf[0])}
+	num := int(binary.LittleEndian.Uint16(buf[1:3]))
+	switch n.kind {
+	case kindLeaf:
+		n.keys = make([]int, 0, num)
+		n.values = make([][]byte, 0, num)
+		off := nodeHeaderSize
+		for i := 0; i < num; i++ {
+			if off+leafEntryOverhead > len(buf) {
+				return nil, errors.Wrapf(ErrCorrupted, "leaf page %d", id)
+			}
+			key := int(binary.LittleEndian.Uint64(buf[off:]))
+			size := int(binary.LittleEndian.Uint16(buf[off+8:]))
+			off += leafEntryOverhead
+			if off+size > len(buf) {
+				return nil, errors.Wrapf(ErrCorrupted, "leaf page %d", id)
+			}
+			value := make([]byte, size)
+			copy(value, buf[off:off+size])
+			off += size
+			n.keys = append(n.keys, key)
+			n.values = append(n.values, value)
+		}
+	case kindInternal:
+		if nodeHeaderSize+8+num*internalEntrySize > len(buf) {
+			return nil, errors.Wrapf(ErrCorrupted, "internal page %d", id)
+		}
+		n.keys = make([]int, 0, num)
+		n.children = make([]pageID, 0, num+1)
+		n.counts = make([]int, 0, num+1)
+		n.children = append(n.children, pageID(binary.LittleEndian.Uint32(buf[3:])))
+		n.counts = append(n.counts, int(binary.LittleEndian.Uint32(buf[7:])))
+		off := nodeHeaderSize + 8
+		for i := 0; i < num; i++ {
+			n.keys = append(n.keys, int(binary.LittleEndian.Uint64(buf[off:])))
+			n.children = append(n.children, pageID(binary.LittleEndian.Uint32(buf[off+8:])))
+			n.counts = append(n.counts, int(binary.LittleEndian.Uint32(buf[off+12:])))
+			off += internalEntrySize
+		}
+	case kindFree:
+		n.next = pageID(binary.LittleEndian.Uint32(buf[3:]))
+	case kindOverflow:
+		n.next = pageID(binary.LittleEndian.Uint32(buf[3:]))
+		size := int(binary.LittleEndian.Uint32(buf[7:]))
+		if overflowHeaderSize+size > len(buf) {
+			return nil, errors.Wrapf(ErrCorrupted, "overflow page %d", id)
+		}
+		n.data = make([]byte, size)
+		copy(n.data, buf[overflowHeaderSize:])
+	default:
+		return nil, errors.Wrapf(ErrCorrupted, "unknown kind %d of page %d", n.kind, id)
+	}
+	return n, nil
+}
@@ -0,0 +1,87 @@
+package btree
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// overflowRefSize is the size of marker + first page + length of the value of an
+// overflow reference
+const overflowRefSize = 1 + 4 + 4
+
+// overflowMarker starts an overflow reference in a leaf, an encoded value never starts
+// with it since json does not
+const overflowMarker = 0
+
+// isOverflow reports whether the value of a leaf is an overflow reference
+func isOverflow(value []byte) bool {
+	return len(value) == overflowRefSize && value[0] == overflowMarker
+}
+
+// writeOverflow writes value into a chain of overflow pages, and returns the reference
+// which is kept in the leaf instead
+func (t *BTree[T]) writeOverflow(value []byte) ([]byte, error) {
+	if int64(len(value)) > math.MaxUint32 {
+		return nil, ErrValueTooLarge
+	}
+	chunk := t.pager.meta.pageSize - overflowHeaderSize
+	// the pages are written from the tail, so every page knows the next
+	var next pageID
+	for start := (len(value) - 1) / chunk * chunk; start >= 0; start -= chunk {
+		n, err := t.pager.allocate(kindOverflow)
+		if err != nil {
+			return nil, err
+		}
+		n.data = value[start:min(start+chunk, len(value))]
+		n.next = next
+		next = n.id
+	}
+	ref := make([]byte, overflowRefSize)
+	ref[0] = overflowMarker
+	binary.LittleEndian.PutUint32(ref[1:], uint32(next))
+	binary.LittleEndian.PutUint32(ref[5:], uint32(len(value)))
+	return ref, nil
+}
+
+// readOverflow returns the value of the overflow reference
+func (t *BTree[T]) readOverflow(ref []byte) ([]byte, error) {
+	size := int(binary.LittleEndian.Uint32(ref[5:]))
+	value := make([]byte, 0, size)
+	for id := pageID(binary.LittleEndian.Uint32(ref[1:])); id != 0; {
+		n, err := t.pager.get(id)
+		if err != nil {
+			return nil, err
+		}
+		if n.kind != kindOverflow || len(value)+len(n.data) > size {
+			return nil, errors.Wrapf(ErrCorrupted, "overflow page %d", id)
+		}
+		value = append(value, n.data...)
+		id = n.next
+	}
+	if len(value) != size {
+		return nil, errors.Wrapf(ErrCorrupted, "overflow value of %d bytes, want %d", len(value), size)
+	}
+	return value, nil
+}
+
+// freeValue puts the overflow pages of the value of a leaf into the freelist, it does
+// nothing for a value kept in the leaf
+func (t *BTree[T]) freeValue(value []byte) error {
+	if !isOverflow(value) {
+		return nil
+	}
+	for id := pageID(binary.LittleEndian.Uint32(value[1:])); id != 0; {
+		n, err := t.pager.get(id)
+		if err != nil {
+			return err
+		}
+		if n.kind != kindOverflow {
+			return errors.Wrapf(ErrCorrupted, "overflow page %d", id)
+		}
+		id = n.next
+		t.pager.free(n)
+	}
+	return nil
+}
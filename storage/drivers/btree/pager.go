@@ -0,0 +1,216 @@
+package btree
+
+import (
+	"container/list"
+	"encoding/binary"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var magic = [4]byte{'G', 'L', 'B', 'T'}
+
+// metaSize is the size of magic + page size + root + number of pages + freelist head + max id + count
+const metaSize = 4 + 4 + 4 + 4 + 4 + 8 + 8
+
+// meta is kept in the page 0 of the file
+type meta struct {
+	pageSize int
+	root     pageID
+	numPages uint32
+	freeHead pageID
+	maxID    int
+	count    int
+}
+
+// pager is the buffer pool of the file, it caches the decoded pages in LRU order and
+// writes the dirty pages back with the meta after every change of the tree
+type pager struct {
+	file     *os.File
+	meta     meta
+	written  meta // the meta in the file
+	capacity int
+	cache    map[pageID]*list.Element
+	lru      *list.List // of *node, the front is the most recently used
+}
+
+// openPager opens the file at path, a new file is initialized with pageSize
+func openPager(path string, pageSize, capacity int) (*pager, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open btree file")
+	}
+	p := &pager{
+		file:     file,
+		capacity: capacity,
+		cache:    make(map[pageID]*list.Element),
+		lru:      list.New(),
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "stat btree file")
+	}
+	if info.Size() == 0 {
+		p.meta = meta{pageSize: pageSize, numPages: 1}
+		return p, nil
+	}
+	if err := p.readMeta(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *pager) readMeta() error {
+	buf := make([]byte, metaSize)
+	if _, err := p.file.ReadAt(buf, 0); err != nil {
+		return errors.Wrap(err, "read btree meta")
+	}
+	if [4]byte(buf[0:4]) != magic {
+		return errors.Wrap(ErrCorrupted, "bad magic")
+	}
+	p.meta = meta{
+		pageSize: int(binary.LittleEndian.Uint32(buf[4:])),
+		root:     pageID(binary.LittleEndian.Uint32(buf[8:])),
+		numPages: binary.LittleEndian.Uint32(buf[12:]),
+		freeHead: pageID(binary.LittleEndian.Uint32(buf[16:])),
+		maxID:    int(binary.LittleEndian.Uint64(buf[20:])),
+		count:    int(binary.LittleEndian.Uint64(buf[28:])),
+	}
+	if p.meta.pageSize < minPageSize || p.meta.root == 0 || p.meta.root >= pageID(p.meta.numPages) {
+		return errors.Wrap(ErrCorrupted, "bad meta")
+	}
+	p.written = p.meta
+	return nil
+}
+
+func (p *pager) writeMeta() error {
+	buf := make([]byte, p.meta.pageSize)
+	copy(buf[0:4], magic[:])
+	binary.LittleEndian.PutUint32(buf[4:], uint32(p.meta.pageSize))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(p.meta.root))
+	binary.LittleEndian.PutUint32(buf[12:], p.meta.numPages)
+	binary.LittleEndian.PutUint32(buf[16:], uint32(p.meta.freeHead))
+	binary.LittleEndian.PutUint64(buf[20:], uint64(p.meta.maxID))
+	binary.LittleEndian.PutUint64(buf[28:], uint64(p.meta.count))
+	if _, err := p.file.WriteAt(buf, 0); err != nil {
+		return errors.Wrap(err, "write btree meta")
+	}
+	p.written = p.meta
+	return nil
+}
+
+// get returns the node of the page, the node is valid until the next evict
+func (p *pager) get(id pageID) (*node, error) {
+	if elem, ok := p.cache[id]; ok {
+		p.lru.MoveToFront(elem)
+		return elem.Value.(*node), nil
+	}
+	if id == 0 || uint32(id) >= p.meta.numPages {
+		return nil, errors.Wrapf(ErrCorrupted, "page %d out of range", id)
+	}
+	buf := make([]byte, p.meta.pageSize)
+	if _, err := p.file.ReadAt(buf, int64(id)*int64(p.meta.pageSize)); err != nil {
+		return nil, errors.Wrapf(err, "read page %d", id)
+	}
+	n, err := decodeNode(id, buf)
+	if err != nil {
+		return nil, err
+	}
+	p.cache[id] = p.lru.PushFront(n)
+	return n, nil
+}
+
+// allocate returns an empty node of kind, the page is reused from the freelist if any
+func (p *pager) allocate(kind nodeKind) (*node, error) {
+	if p.meta.freeHead != 0 {
+		n, err := p.get(p.meta.freeHead)
+		if err != nil {
+			return nil, err
+		}
+		if n.kind != kindFree {
+			return nil, errors.Wrapf(ErrCorrupted, "page %d in freelist is in use", n.id)
+		}
+		p.meta.freeHead = n.next
+		n.reset(kind)
+		return n, nil
+	}
+	n := &node{id: pageID(p.meta.numPages)}
+	n.reset(kind)
+	p.meta.numPages++
+	p.cache[n.id] = p.lru.PushFront(n)
+	return n, nil
+}
+
+// free puts the page of the node into the freelist
+func (p *pager) free(n *node) {
+	n.reset(kindFree)
+	n.next = p.meta.freeHead
+	p.meta.freeHead = n.id
+}
+
+func (p *pager) write(n *node) error {
+	buf := make([]byte, p.meta.pageSize)
+	n.encode(buf)
+	if _, err := p.file.WriteAt(buf, int64(n.id)*int64(p.meta.pageSize)); err != nil {
+		return errors.Wrapf(err, "write page %d", n.id)
+	}
+	n.dirty = false
+	return nil
+}
+
+// evict shrinks the cache to its capacity, it's called after every operation of the
+// tree, so the nodes in use by an operation are never evicted
+func (p *pager) evict() error {
+	for p.lru.Len() > p.capacity {
+		elem := p.lru.Back()
+		n := elem.Value.(*node)
+		if n.dirty {
+			if err := p.write(n); err != nil {
+				return err
+			}
+		}
+		p.lru.Remove(elem)
+		delete(p.cache, n.id)
+	}
+	return nil
+}
+
+// writeBack writes the dirty pages, then the meta if it's changed, so the file is
+// consistent after the process exits without close. It's not fsynced, see flush
+func (p *pager) writeBack() error {
+	for elem := p.lru.Front(); elem != nil; elem = elem.Next() {
+		if n := elem.Value.(*node); n.dirty {
+			if err := p.write(n); err != nil {
+				return err
+			}
+		}
+	}
+	if p.meta == p.written {
+		return nil
+	}
+	return p.writeMeta()
+}
+
+// flush writes the dirty pages and the meta, then fsyncs the file
+func (p *pager) flush() error {
+	if err := p.writeBack(); err != nil {
+		return err
+	}
+	if err := p.file.Sync(); err != nil {
+		return errors.Wrap(err, "sync btree file")
+	}
+	return nil
+}
+
+func (p *pager) close() error {
+	if err := p.flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	if err := p.file.Close(); err != nil {
+		return errors.Wrap(err, "close btree file")
+	}
+	return nil
+}
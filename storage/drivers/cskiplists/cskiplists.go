@@ -0,0 +1,364 @@
+package cskiplists
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"glookbs.github.com/storage"
+)
+
+// Driver opens SkipList as storage.Enginer, it takes no config. It's registered for the
+// data types by the caller with storage.Register
+type Driver[T storage.Entity] struct{}
+
+func (Driver[T]) Open(config string) (storage.Enginer[T], error) {
+	if len(config) > 0 {
+		return nil, errors.Errorf("cskiplist: unexpected config %q", config)
+	}
+	return New[T](), nil
+}
+
+// maxLevel is the max level of the nodes
+const maxLevel = 16
+
+var ErrSkipListDataNotFound = errors.New("data was not found")
+
+// node is a node of the list, it's marked before it's unlinked from the list, and it's
+// in the list once it's linked at all its levels
+type node[T storage.Entity] struct {
+	key  int
+	data atomic.Pointer[T]
+	next []atomic.Pointer[node[T]]
+	// mu guards the links to the next nodes and the changes of data
+	mu     sync.Mutex
+	marked atomic.Bool
+	linked atomic.Bool
+}
+
+// SkipList is a concurrent skip list with the lazy synchronization, in the style of
+// Java's ConcurrentSkipListMap: the reads never lock, and a change only locks the node
+// and its predecessors, so the changes of different nodes run in parallel. It's safe for
+// concurrent use, so the storage.Storage does not serialize the changes around it
+type SkipList[T storage.Entity] struct {
+	head   *node[T]
+	length atomic.Int64
+	maxID  atomic.Int64
+}
+
+func newNode[T storage.Entity](key, level int) *node[T] {
+	return &node[T]{key: key, next: make([]atomic.Pointer[node[T]], level)}
+}
+
+func New[T storage.Entity]() *SkipList[T] {
+	return &SkipList[T]{head: newNode[T](math.MinInt, maxLevel)}
+}
+
+// ThreadSafe reports the list is safe for concurrent use
+func (sl *SkipList[T]) ThreadSafe() bool {
+	return true
+}
+
+func (sl *SkipList[T]) Count() int {
+	return int(sl.length.Load())
+}
+
+// randomLevel returns the level with probability of coin flips
+func randomLevel() int {
+	level := 1
+	for rand.Float32() < 0.5 && level < maxLevel {
+		level++
+	}
+	return level
+}
+
+// live reports whether the node is in the list
+func (n *node[T]) live() bool {
+	return n.linked.Load() && !n.marked.Load()
+}
+
+// find fills the predecessors and the successors of key at every level, and returns the
+// highest level where the node of key was found, -1 if it was not found
+func (sl *SkipList[T]) find(key int, preds, succs []*node[T]) int {
+	found := -1
+	pred := sl.head
+	for lv := maxLevel - 1; lv >= 0; lv-- {
+		curr := pred.next[lv].Load()
+		for curr != nil && curr.key < key {
+			pred, curr = curr, curr.next[lv].Load()
+		}
+		if found == -1 && curr != nil && curr.key == key {
+			found = lv
+		}
+		preds[lv], succs[lv] = pred, curr
+	}
+	return found
+}
+
+// lookup returns the node of key without locking, it returns nil if it's not in the list
+func (sl *SkipList[T]) lookup(key int) *node[T] {
+	pred := sl.head
+	for lv := maxLevel - 1; lv >= 0; lv-- {
+		curr := pred.next[lv].Load()
+		for curr != nil && curr.key < key {
+			pred, curr = curr, curr.next[lv].Load()
+		}
+		if curr != nil && curr.key == key {
+			if curr.live() {
+				return curr
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// unlock unlocks the distinct predecessors of the levels up to top
+func unlock[T storage.Entity](preds []*node[T], top int) {
+	var prev *node[T]
+	for lv := 0; lv <= top; lv++ {
+		if preds[lv] != prev {
+			preds[lv].mu.Unlock()
+			prev = preds[lv]
+		}
+	}
+}
+
+// Insert inserts data and returns its id, the ids are assigned in order
+func (sl *SkipList[T]) Insert(data T) (int, error) {
+	id := int(sl.maxID.Add(1))
+	data.SetID(id)
+	sl.add(id, data)
+	return id, nil
+}
+
+// add links the node of key at a random level, it returns false if key is in the list.
+// The predecessors are locked from the bottom, and they are validated to be still
+// linked to the successors, otherwise it retries
+func (sl *SkipList[T]) add(key int, data T) bool {
+	level := randomLevel()
+	preds := make([]*node[T], maxLevel)
+	succs := make([]*node[T], maxLevel)
+	for {
+		if found := sl.find(key, preds, succs); found != -1 {
+			if n := succs[found]; !n.marked.Load() {
+				// the node is being linked by another insert
+				for !n.linked.Load() {
+					runtime.Gosched()
+				}
+				return false
+			}
+			// the node is being unlinked
+			continue
+		}
+
+		top := -1
+		valid := true
+		var prev *node[T]
+		for lv := 0; valid && lv < level; lv++ {
+			pred, succ := preds[lv], succs[lv]
+			if pred != prev {
+				pred.mu.Lock()
+				prev = pred
+			}
+			top = lv
+			valid = !pred.marked.Load() && (succ == nil || !succ.marked.Load()) && pred.next[lv].Load() == succ
+		}
+		if !valid {
+			unlock(preds, top)
+			continue
+		}
+
+		n := newNode[T](key, level)
+		n.data.Store(&data)
+		for lv := 0; lv < level; lv++ {
+			n.next[lv].Store(succs[lv])
+		}
+		for lv := 0; lv < level; lv++ {
+			preds[lv].next[lv].Store(n)
+		}
+		n.linked.Store(true)
+		unlock(preds, top)
+		sl.length.Add(1)
+		return true
+	}
+}
+
+// Get returns the data with key, returns false if it does not exist
+func (sl *SkipList[T]) Get(key int) (T, bool) {
+	if n := sl.lookup(key); n != nil {
+		return *n.data.Load(), true
+	}
+	var zero T
+	return zero, false
+}
+
+func (sl *SkipList[T]) Delete(key int) bool {
+	return sl.remove(key, nil)
+}
+
+// CompareAndDelete deletes the data of key only if match reports true for it, the match
+// and the delete are atomic with the other changes of key
+func (sl *SkipList[T]) CompareAndDelete(key int, match func(current T) bool) bool {
+	return sl.remove(key, match)
+}
+
+// remove marks the node of key under its lock, then unlinks it from the top with the
+// predecessors locked, a nil match matches any data
+func (sl *SkipList[T]) remove(key int, match func(current T) bool) bool {
+	preds := make([]*node[T], maxLevel)
+	succs := make([]*node[T], maxLevel)
+	var victim *node[T]
+	for {
+		found := sl.find(key, preds, succs)
+		if victim == nil {
+			// only the node found at its top level is fully linked
+			if found == -1 || !succs[found].live() || len(succs[found].next)-1 != found {
+				return false
+			}
+			victim = succs[found]
+			victim.mu.Lock()
+			if victim.marked.Load() || (match != nil && !match(*victim.data.Load())) {
+				victim.mu.Unlock()
+				return false
+			}
+			victim.marked.Store(true)
+		}
+
+		level := len(victim.next)
+		top := -1
+		valid := true
+		var prev *node[T]
+		for lv := 0; valid && lv < level; lv++ {
+			pred := preds[lv]
+			if pred != prev {
+				pred.mu.Lock()
+				prev = pred
+			}
+			top = lv
+			valid = !pred.marked.Load() && pred.next[lv].Load() == victim
+		}
+		if !valid {
+			unlock(preds, top)
+			continue
+		}
+
+		for lv := level - 1; lv >= 0; lv-- {
+			preds[lv].next[lv].Store(victim.next[lv].Load())
+		}
+		victim.mu.Unlock()
+		unlock(preds, top)
+		sl.length.Add(-1)
+		return true
+	}
+}
+
+// Update replaces the data of id under the lock of its node
+func (sl *SkipList[T]) Update(id int, data T) error {
+	if _, found := sl.swap(id, nil, data); !found {
+		return ErrSkipListDataNotFound
+	}
+	return nil
+}
+
+// CompareAndSwap replaces the data of id only if match reports true for the current
+// data, it returns false if the data does not exist or does not match
+func (sl *SkipList[T]) CompareAndSwap(id int, match func(current T) bool, data T) (bool, error) {
+	swapped, _ := sl.swap(id, match, data)
+	return swapped, nil
+}
+
+// swap replaces the data of id under the lock of its node if match reports true for the
+// current data, a nil match matches any data
+func (sl *SkipList[T]) swap(id int, match func(current T) bool, data T) (swapped, found bool) {
+	n := sl.lookup(id)
+	if n == nil {
+		return false, false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// the node was deleted after the lookup
+	if n.marked.Load() {
+		return false, false
+	}
+	if match != nil && !match(*n.data.Load()) {
+		return false, true
+	}
+	n.data.Store(&data)
+	return true, true
+}
+
+// first returns the first node in the list whose key is greater than after
+func (sl *SkipList[T]) first(after int) *node[T] {
+	pred := sl.head
+	for lv := maxLevel - 1; lv >= 0; lv-- {
+		for curr := pred.next[lv].Load(); curr != nil && curr.key <= after; curr = pred.next[lv].Load() {
+			pred = curr
+		}
+	}
+	return sl.next(pred)
+}
+
+// next returns the node in the list after n
+func (sl *SkipList[T]) next(n *node[T]) *node[T] {
+	n = n.next[0].Load()
+	for n != nil && !n.live() {
+		n = n.next[0].Load()
+	}
+	return n
+}
+
+// Range returns data with page i and page size j, returns empty slice if no data. The
+// list has no ranks, so the nodes before the page are walked
+func (sl *SkipList[T]) Range(i, j int) []T {
+	start := (i - 1) * j
+	result := []T{}
+	if start < 0 || j <= 0 {
+		return result
+	}
+	n := sl.next(sl.head)
+	for k := 0; n != nil && k < start; k++ {
+		n = sl.next(n)
+	}
+	for ; n != nil && len(result) < j; n = sl.next(n) {
+		result = append(result, *n.data.Load())
+	}
+	return result
+}
+
+// Scan returns at most n data whose key is greater than after, ordered by key
+func (sl *SkipList[T]) Scan(after, n int) []T {
+	result := make([]T, 0, max(min(n, sl.Count()), 0))
+	for curr := sl.first(after); curr != nil && len(result) < n; curr = sl.next(curr) {
+		result = append(result, *curr.data.Load())
+	}
+	return result
+}
+
+// Snapshot returns all the data ordered by id with the max id ever assigned, the data
+// changed during the snapshot may or may not be seen
+func (sl *SkipList[T]) Snapshot() storage.Snapshot[T] {
+	maxID := int(sl.maxID.Load())
+	items := make([]T, 0, sl.Count())
+	for n := sl.next(sl.head); n != nil; n = sl.next(n) {
+		items = append(items, *n.data.Load())
+	}
+	return storage.Snapshot[T]{MaxID: maxID, Items: items}
+}
+
+// Restore replaces the content of the list with the snapshot, it must not run with
+// the other changes
+func (sl *SkipList[T]) Restore(s storage.Snapshot[T]) error {
+	for n := sl.next(sl.head); n != nil; n = sl.next(n) {
+		sl.remove(n.key, nil)
+	}
+	for _, item := range s.Items {
+		sl.add(item.GetID(), item)
+	}
+	sl.maxID.Store(int64(s.MaxID))
+	return nil
+}
@@ -0,0 +1,241 @@
+package cskiplists
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestEngine(t *testing.T) {
+	storagetest.TestEngine(t, func(t *testing.T) storagetest.Engine {
+		return New[*storagetest.Data]()
+	})
+}
+
+func TestSkipList(t *testing.T) {
+	list := New[*storagetest.Data]()
+	for i := 0; i < 10; i++ {
+		if id, _ := list.Insert(&storagetest.Data{Key: i}); id != i+1 {
+			t.Fatalf("id should be %d, but got %d", i+1, id)
+		}
+	}
+	isKey := func(key int) func(*storagetest.Data) bool {
+		return func(current *storagetest.Data) bool { return current.Key == key }
+	}
+
+	testcases := []struct {
+		name   string
+		change func() bool
+		want   bool
+		// wantKeys are the keys in the list after the change
+		wantKeys []int
+	}{
+		{
+			name:     "delete",
+			change:   func() bool { return list.Delete(2) },
+			want:     true,
+			wantKeys: []int{1, 3, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name:     "delete the deleted",
+			change:   func() bool { return list.Delete(2) },
+			want:     false,
+			wantKeys: []int{1, 3, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name:     "compare and delete the unmatched",
+			change:   func() bool { return list.CompareAndDelete(3, isKey(0)) },
+			want:     false,
+			wantKeys: []int{1, 3, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name:     "compare and delete the matched",
+			change:   func() bool { return list.CompareAndDelete(3, isKey(2)) },
+			want:     true,
+			wantKeys: []int{1, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name: "compare and swap the matched",
+			change: func() bool {
+				swapped, _ := list.CompareAndSwap(4, isKey(3), &storagetest.Data{ID: 4, Name: "a"})
+				return swapped
+			},
+			want:     true,
+			wantKeys: []int{1, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name: "compare and swap the unmatched",
+			change: func() bool {
+				swapped, _ := list.CompareAndSwap(4, isKey(3), &storagetest.Data{ID: 4, Name: "b"})
+				return swapped
+			},
+			want:     false,
+			wantKeys: []int{1, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name:     "update the deleted",
+			change:   func() bool { return list.Update(3, &storagetest.Data{ID: 3}) == nil },
+			want:     false,
+			wantKeys: []int{1, 4, 5, 6, 7, 8, 9, 10},
+		},
+		{
+			name: "insert after delete",
+			change: func() bool {
+				id, _ := list.Insert(&storagetest.Data{})
+				return id == 11
+			},
+			want:     true,
+			wantKeys: []int{1, 4, 5, 6, 7, 8, 9, 10, 11},
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			if ans := tt.change(); ans != tt.want {
+				t.Fatalf("result should be %v, but got %v", tt.want, ans)
+			}
+			if ans := storagetest.IDs(list.Scan(0, 100)); !reflect.DeepEqual(ans, tt.wantKeys) {
+				t.Fatalf("keys should be %v, but got %v", tt.wantKeys, ans)
+			}
+			if list.Count() != len(tt.wantKeys) {
+				t.Fatalf("count should be %d, but got %d", len(tt.wantKeys), list.Count())
+			}
+		})
+	}
+
+	if data, ok := list.Get(4); !ok || data.Name != "a" {
+		t.Fatalf("data should be %v, but got %v", "a", data)
+	}
+	if ans := storagetest.IDs(list.Range(2, 3)); !reflect.DeepEqual(ans, []int{6, 7, 8}) {
+		t.Fatalf("range keys should be %v, but got %v", []int{6, 7, 8}, ans)
+	}
+	if ans := storagetest.IDs(list.Scan(5, 2)); !reflect.DeepEqual(ans, []int{6, 7}) {
+		t.Fatalf("scan keys should be %v, but got %v", []int{6, 7}, ans)
+	}
+
+	restored := New[*storagetest.Data]()
+	restored.Insert(&storagetest.Data{})
+	if err := restored.Restore(list.Snapshot()); err != nil {
+		t.Fatal("restore error", err)
+	}
+	if ans := storagetest.IDs(restored.Scan(0, 100)); !reflect.DeepEqual(ans, []int{1, 4, 5, 6, 7, 8, 9, 10, 11}) {
+		t.Fatalf("restored keys should be %v, but got %v", []int{1, 4, 5, 6, 7, 8, 9, 10, 11}, ans)
+	}
+	if id, _ := restored.Insert(&storagetest.Data{}); id != 12 {
+		t.Fatalf("id should be %d, but got %d", 12, id)
+	}
+}
+
+// TestConcurrent changes the list from many goroutines, it's meant to run with -race
+func TestConcurrent(t *testing.T) {
+	list := New[*storagetest.Data]()
+	const goroutines, n = 8, 500
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var ids []int
+			for i := 0; i < n; i++ {
+				id, _ := list.Insert(&storagetest.Data{Key: 0})
+				ids = append(ids, id)
+				// the goroutines increase the values of each other
+				for {
+					key := rand.Intn(id) + 1
+					current, ok := list.Get(key)
+					if !ok {
+						break
+					}
+					swapped, _ := list.CompareAndSwap(key, func(d *storagetest.Data) bool { return d == current }, &storagetest.Data{ID: key, Key: current.Key + 1})
+					if swapped {
+						break
+					}
+				}
+				list.Scan(rand.Intn(id), 10)
+			}
+			// delete the half of the inserted
+			for _, id := range ids[:n/2] {
+				if !list.Delete(id) {
+					t.Errorf("%d should be deleted", id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := goroutines * n / 2
+	if list.Count() != want {
+		t.Fatalf("count should be %d, but got %d", want, list.Count())
+	}
+	keys := storagetest.IDs(list.Scan(0, goroutines*n))
+	if len(keys) != want {
+		t.Fatalf("the number of keys should be %d, but got %d", want, len(keys))
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] >= keys[i] {
+			t.Fatalf("keys should be in order, but got %d before %d", keys[i-1], keys[i])
+		}
+	}
+}
+
+// BenchmarkStorage compares the Storage of the skip lists with the goroutines of -cpu,
+// the Storage serializes the changes of skiplists but not the ones of cskiplists. Run it
+// with -race to check the races, and without -race to compare, e.g.
+//
+//	go test -run - -bench Storage -cpu 1,4,8 ./storage/drivers/cskiplists
+func BenchmarkStorage(b *testing.B) {
+	const items = 10000
+	engines := []struct {
+		name string
+		new  func() storage.Enginer[*storagetest.Data]
+	}{
+		{"skiplist", func() storage.Enginer[*storagetest.Data] { return skiplists.New[*storagetest.Data]() }},
+		{"cskiplist", func() storage.Enginer[*storagetest.Data] { return New[*storagetest.Data]() }},
+	}
+	workloads := []struct {
+		name string
+		// writes is the percentage of updates, and inserts is the percentage of the inserts
+		// with the deletes of the inserted, the rest are gets
+		writes, inserts int
+	}{
+		{"read", 0, 0},
+		{"mixed", 10, 0},
+		{"write", 100, 0},
+		{"churn", 0, 100},
+		{"mixed-churn", 10, 10},
+	}
+	for _, w := range workloads {
+		for _, e := range engines {
+			b.Run(fmt.Sprintf("%s/%s", w.name, e.name), func(b *testing.B) {
+				s := storage.New[*storagetest.Data](e.new())
+				for i := 0; i < items; i++ {
+					s.Insert(&storagetest.Data{Key: i})
+				}
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					r := rand.New(rand.NewSource(rand.Int63()))
+					for pb.Next() {
+						id := r.Intn(items) + 1
+						switch p := r.Intn(100); {
+						case p < w.writes:
+							s.Update(id, &storagetest.Data{ID: id, Key: id})
+						case p < w.writes+w.inserts:
+							inserted, err := s.Insert(&storagetest.Data{Key: id})
+							if err == nil {
+								s.Delete(inserted)
+							}
+						default:
+							s.Get(id)
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -3,63 +3,78 @@ package skiplists
 import (
 	"fmt"
 	"math/rand"
-	"reflect"
-	"sync"
 
 	"github.com/pkg/errors"
 
 	"glookbs.github.com/storage"
 )
 
-func init() {
-	// implement the DataStorage once
-	var once sync.Once
-	once.Do(func() {
-		storage.DataStorage = *storage.New(New())
-	})
+// Driver opens SkipList as storage.Enginer, it takes no config. It's registered for the
+// data types by the caller with storage.Register
+type Driver[T storage.Entity] struct{}
+
+func (Driver[T]) Open(config string) (storage.Enginer[T], error) {
+	if len(config) > 0 {
+		return nil, errors.Errorf("skiplist: unexpected config %q", config)
+	}
+	return New[T](), nil
 }
 
-var (
-	MaxNodes = 512
-	MaxLevel = 10
-)
+// MaxLevel is the max level of the nodes, the capacity is limited by storage.Quota
+var MaxLevel = 10
 
-var (
-	ErrSkipListIsFull       = errors.New("skip list is full")
-	ErrSkipListDataNotFound = errors.New("data was not found")
-)
+var ErrSkipListDataNotFound = errors.New("data was not found")
+
+var ErrSkipListTxInProgress = errors.New("transaction is in progress")
 
-type Node struct {
+// Node is a node of the indexable skip list, span[i] is the number of nodes from
+// the node to next[i] at level 0, so a node can be found by its rank in log time
+type Node[T storage.Entity] struct {
 	key  int
-	data any
-	next []*Node
+	data T
+	next []*Node[T]
+	span []int
 }
 
-type SkipList struct {
-	head   *Node
+type SkipList[T storage.Entity] struct {
+	head   *Node[T]
 	level  int
 	length int
 	maxID  int
+	// undo is the undo log of the transaction, it's nil if no transaction is in progress
+	undo []undoEntry[T]
 }
 
-func newNode(key, level int, data any) *Node {
-	return &Node{
+// undoEntry reverts a change of the transaction
+type undoEntry[T storage.Entity] struct {
+	key int
+	// data is the data before the change, the key was inserted if it was not existed
+	data    T
+	existed bool
+	// maxID is the max id before the change
+	maxID int
+}
+
+func newNode[T storage.Entity](key, level int, data T) *Node[T] {
+	return &Node[T]{
 		key:  key,
 		data: data,
-		next: make([]*Node, level),
+		next: make([]*Node[T], level),
+		span: make([]int, level),
 	}
 }
 
-func New() *SkipList {
-	head := newNode(-1, MaxLevel, nil)
-	return &SkipList{head, 1, 0, 0}
+func New[T storage.Entity]() *SkipList[T] {
+	var zero T
+	head := newNode(-1, MaxLevel, zero)
+	return &SkipList[T]{head: head, level: 1}
 }
 
-func (sl *SkipList) Count() int {
+func (sl *SkipList[T]) Count() int {
 	return sl.len()
 }
 
-func (sl *SkipList) len() int {
+func (sl *SkipList[T]) len() int {
 	return sl.length
 }
 
@@ -72,35 +87,29 @@ func randomLevel() int {
 	return level
 }
 
-// Insert inserts data and returns id if success, otherwise id=-1 with error
-func (sl *SkipList) Insert(data any) (int, error) {
+// Insert inserts data and returns its id, the capacity is not limited by the list
+func (sl *SkipList[T]) Insert(data T) (int, error) {
 	id := sl.maxID + 1
-	// add the id into the data with field
-	value := reflect.ValueOf(data)
-	if value.Kind() == reflect.Pointer {
-		idField := value.Elem().FieldByName("ID")
-		if idField.CanSet() && idField.IsValid() {
-			idField.SetInt(int64(id))
-		}
-	}
+	data.SetID(id)
+	sl.record(id, nil)
 	// perform the low-level insert
-	if err := sl.insert(id, data); err != nil {
-		return -1, err
-	}
+	sl.insert(id, data)
 	sl.maxID = id
 	return id, nil
 }
 
-func (sl *SkipList) insert(key int, data any) error {
-	if sl.length >= MaxNodes {
-		return ErrSkipListIsFull
-	}
-
-	update := make([]*Node, MaxLevel)
+func (sl *SkipList[T]) insert(key int, data T) {
+	update := make([]*Node[T], MaxLevel)
+	// rank[i] is the rank of update[i]
+	rank := make([]int, MaxLevel)
 	current := sl.head
 
 	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
 		for current.next[i] != nil && current.next[i].key < key {
+			rank[i] += current.span[i]
 			current = current.next[i]
 		}
 		update[i] = current
@@ -110,6 +119,8 @@ func (sl *SkipList) insert(key int, data any) error {
 	if level > sl.level {
 		for i := sl.level; i < level; i++ {
 			update[i] = sl.head
+			rank[i] = 0
+			sl.head.span[i] = sl.length
 		}
 		sl.level = level
 	}
@@ -118,13 +129,19 @@ func (sl *SkipList) insert(key int, data any) error {
 	for i := 0; i < level; i++ {
 		node.next[i] = update[i].next[i]
 		update[i].next[i] = node
+		node.span[i] = update[i].span[i] - (rank[0] - rank[i])
+		update[i].span[i] = rank[0] - rank[i] + 1
+	}
+	// the higher levels step over the new node
+	for i := level; i < sl.level; i++ {
+		update[i].span[i]++
 	}
 
 	sl.length++
-	return nil
 }
 
-func (sl *SkipList) search(key int) (any, error) {
+// find returns the node of key, returns nil if it does not exist
+func (sl *SkipList[T]) find(key int) *Node[T] {
 	current := sl.head
 
 	for i := sl.level - 1; i >= 0; i-- {
@@ -134,14 +151,27 @@ func (sl *SkipList) search(key int) (any, error) {
 	}
 
 	if current.next[0] != nil && current.next[0].key == key {
-		return current.next[0].data, nil
+		return current.next[0]
 	}
+	return nil
+}
 
-	return nil, ErrSkipListDataNotFound
+func (sl *SkipList[T]) search(key int) (T, error) {
+	if node := sl.find(key); node != nil {
+		return node.data, nil
+	}
+	var zero T
+	return zero, ErrSkipListDataNotFound
+}
+
+// Get returns the data with key, returns false if it does not exist
+func (sl *SkipList[T]) Get(key int) (T, bool) {
+	data, err := sl.search(key)
+	return data, err == nil
 }
 
 // display shows the structure(only for debug)
-func (list *SkipList) display() {
+func (list *SkipList[T]) display() {
 	for i := list.level - 1; i >= 0; i-- {
 		current := list.head.next[i]
 		fmt.Printf("Level %d: ", i)
@@ -153,8 +183,19 @@ func (list *SkipList) display() {
 	}
 }
 
-func (sl *SkipList) Delete(key int) bool {
-	update := make([]*Node, MaxLevel)
+func (sl *SkipList[T]) Delete(key int) bool {
+	if sl.undo != nil {
+		node := sl.find(key)
+		if node == nil {
+			return false
+		}
+		sl.record(key, node)
+	}
+	return sl.delete(key)
+}
+
+func (sl *SkipList[T]) delete(key int) bool {
+	update := make([]*Node[T], MaxLevel)
 	current := sl.head
 
 	for i := sl.level - 1; i >= 0; i-- {
@@ -167,10 +208,12 @@ func (sl *SkipList) Delete(key int) bool {
 	target := current.next[0]
 	if target != nil && target.key == key {
 		for i := 0; i < sl.level; i++ {
-			if update[i].next[i] != target {
-				break
+			if update[i].next[i] == target {
+				update[i].span[i] += target.span[i] - 1
+				update[i].next[i] = target.next[i]
+			} else {
+				update[i].span[i]--
 			}
-			update[i].next[i] = target.next[i]
 		}
 		for sl.level > 1 && sl.head.next[sl.level-1] == nil {
 			sl.level--
@@ -182,32 +225,146 @@ func (sl *SkipList) Delete(key int) bool {
 	return false
 }
 
-// Range returns data with range, returns empty slice if no data
-func (sl *SkipList) Range(i, j int) []any {
-	current := sl.head.next[0]
-	result := make([]any, 0)
-	for current != nil {
+// byRank returns the node with the 1-based rank, returns nil if it's out of range
+func (sl *SkipList[T]) byRank(rank int) *Node[T] {
+	if rank < 1 || rank > sl.length {
+		return nil
+	}
+	current := sl.head
+	traversed := 0
+	for i := sl.level - 1; i >= 0; i-- {
+		for current.next[i] != nil && traversed+current.span[i] <= rank {
+			traversed += current.span[i]
+			current = current.next[i]
+		}
+		if traversed == rank {
+			return current
+		}
+	}
+	return nil
+}
+
+// Range returns data with page i and page size j, returns empty slice if no data.
+// The first node of the page is found by its rank, so only the page is walked
+func (sl *SkipList[T]) Range(i, j int) []T {
+	start := (i - 1) * j
+	current := sl.byRank(start + 1)
+	if current == nil {
+		return []T{}
+	}
+
+	result := make([]T, 0, min(j, sl.length-start))
+	for current != nil && len(result) < j {
 		result = append(result, current.data)
 		current = current.next[0]
 	}
+	return result
+}
 
-	start := (i - 1) * j
-	if start >= len(result) {
-		return []any{}
+// Scan returns at most n data whose key is greater than after, ordered by key
+func (sl *SkipList[T]) Scan(after, n int) []T {
+	current := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for current.next[i] != nil && current.next[i].key <= after {
+			current = current.next[i]
+		}
 	}
 
-	end := start + j
-	if end <= len(result) {
-		return result[start:end]
+	result := make([]T, 0, max(min(n, sl.length), 0))
+	for current = current.next[0]; current != nil && len(result) < n; current = current.next[0] {
+		result = append(result, current.data)
 	}
-	return result[start:]
+	return result
 }
 
-// Update performs delete+insert
-func (sl *SkipList) Update(id int, data any) error {
-	if !sl.Delete(id) {
+// Update replaces the data of id in place, the node keeps its level and spans, so the
+// data is never missing from the list during an update
+func (sl *SkipList[T]) Update(id int, data T) error {
+	node := sl.find(id)
+	if node == nil {
 		return ErrSkipListDataNotFound
 	}
+	sl.record(id, node)
+	node.data = data
+	return nil
+}
+
+// CompareAndSwap replaces the data of id in place only if match reports true for the
+// current data, it returns false if the data does not exist or does not match
+func (sl *SkipList[T]) CompareAndSwap(id int, match func(current T) bool, data T) (bool, error) {
+	node := sl.find(id)
+	if node == nil || !match(node.data) {
+		return false, nil
+	}
+	sl.record(id, node)
+	node.data = data
+	return true, nil
+}
+
+// Snapshot returns all the data ordered by id with the max id ever assigned
+func (sl *SkipList[T]) Snapshot() storage.Snapshot[T] {
+	items := make([]T, 0, sl.length)
+	for current := sl.head.next[0]; current != nil; current = current.next[0] {
+		items = append(items, current.data)
+	}
+	return storage.Snapshot[T]{MaxID: sl.maxID, Items: items}
+}
+
+// Restore replaces the content of the list with the snapshot
+func (sl *SkipList[T]) Restore(s storage.Snapshot[T]) error {
+	list := New[T]()
+	for _, item := range s.Items {
+		list.insert(item.GetID(), item)
+	}
+	list.maxID = s.MaxID
+	*sl = *list
+	return nil
+}
 
-	return sl.insert(id, data)
+// record appends the undo entry of the change of key to the undo log if a transaction
+// is in progress, node is the node of key before the change, nil if it did not exist
+func (sl *SkipList[T]) record(key int, node *Node[T]) {
+	if sl.undo == nil {
+		return
+	}
+	e := undoEntry[T]{key: key, maxID: sl.maxID}
+	if node != nil {
+		e.data, e.existed = node.data, true
+	}
+	sl.undo = append(sl.undo, e)
+}
+
+// Begin starts a transaction, the changes since then are recorded in the undo log
+func (sl *SkipList[T]) Begin() error {
+	if sl.undo != nil {
+		return ErrSkipListTxInProgress
+	}
+	sl.undo = make([]undoEntry[T], 0, 8)
+	return nil
+}
+
+// Commit ends the transaction and discards the undo log
+func (sl *SkipList[T]) Commit() error {
+	sl.undo = nil
+	return nil
+}
+
+// Rollback ends the transaction and undoes its changes in the reverse order, the ids
+// assigned by the transaction are reused
+func (sl *SkipList[T]) Rollback() {
+	undo := sl.undo
+	sl.undo = nil
+	for i := len(undo) - 1; i >= 0; i-- {
+		e := undo[i]
+		node := sl.find(e.key)
+		switch {
+		case !e.existed:
+			sl.delete(e.key)
+		case node != nil:
+			node.data = e.data
+		default:
+			sl.insert(e.key, e.data)
+		}
+		sl.maxID = e.maxID
+	}
 }
@@ -1,48 +1,47 @@
 package skiplists
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
+
+	"glookbs.github.com/storage/storagetest"
 )
 
-func testSetup(n, lv int) {
-	MaxNodes = 16
-	MaxLevel = 4
+func newTestData(key int) *storagetest.Data {
+	return &storagetest.Data{ID: key, Name: fmt.Sprintf("v%d", key)}
+}
+
+func testSetup(lv int) {
+	MaxLevel = lv
 }
 
 func TestInsert(t *testing.T) {
 	testcases := []struct {
 		name        string
-		maxNodes    int
 		maxLevel    int
 		insertNodes int
-		wantErr     error
-		wantLen     int
 	}{
 		{
-			name:        "insert nodes < maxNode",
-			maxNodes:    16,
+			name:        "insert nodes",
 			maxLevel:    4,
-			insertNodes: 15,
-			wantErr:     nil,
+			insertNodes: 16,
 		},
 		{
-			name:        "insert nodes = maxNode",
-			maxNodes:    16,
-			maxLevel:    4,
-			insertNodes: 16,
-			wantErr:     nil,
+			name:        "insert nodes more than the former limitation 512",
+			maxLevel:    10,
+			insertNodes: 1024,
 		},
 	}
 
 	for _, tt := range testcases {
 		t.Run(tt.name, func(t *testing.T) {
-			testSetup(tt.maxNodes, tt.maxLevel)
-			list := New()
+			testSetup(tt.maxLevel)
+			list := New[*storagetest.Data]()
 			for i := 1; i <= tt.insertNodes; i++ {
-				if err := list.insert(i, "test-data"); err != nil {
-					t.Fatalf("the error should be %v, but got %v", tt.wantErr, err)
-				}
+				list.insert(i, newTestData(i))
 			}
 			if list.len() != tt.insertNodes {
 				t.Fatalf("the length should be %d, but got %d", tt.insertNodes, list.len())
@@ -51,72 +50,15 @@ func TestInsert(t *testing.T) {
 	}
 }
 
-func TestInsertErr(t *testing.T) {
-	testcases := []struct {
-		name        string
-		maxNodes    int
-		maxLevel    int
-		insertNodes int
-		wantErr     error
-		wantLen     int
-	}{
-		{
-			name:        "insert nodes > maxNode",
-			maxNodes:    16,
-			maxLevel:    4,
-			insertNodes: 17,
-			wantErr:     ErrSkipListIsFull,
-		},
-	}
-
-	for _, tt := range testcases {
-		t.Run(tt.name, func(t *testing.T) {
-			testSetup(tt.maxNodes, tt.maxLevel)
-			list := New()
-			// insert nodes under the limitation
-			for i := 1; i <= tt.maxNodes; i++ {
-				if err := list.insert(i, "test-data"); err != nil {
-					t.Fatalf("insert valid error %v", err)
-				}
-			}
-
-			// insert additional nodes
-			for i := tt.maxNodes + 1; i <= tt.insertNodes; i++ {
-				if err := list.insert(i, "test-data"); err == nil {
-					t.Fatalf("the error should be %v, but got %v", tt.wantErr, err)
-				}
-			}
-		})
-	}
+func TestEngine(t *testing.T) {
+	storagetest.TestEngine(t, func(t *testing.T) storagetest.Engine {
+		return testList()
+	})
 }
 
-func testList() *SkipList {
-	maxNodes, maxLV := 16, 4
-	testSetup(maxNodes, maxLV)
-	return New()
-}
-
-func TestInsertWraperWithSequenceID(t *testing.T) {
-	t.Log("Start testing the sequence id creation by insert wrapper...")
-	list := testList()
-	items := []string{"a", "b", "c", "d", "e"}
-	prevID := 0
-	for _, item := range items {
-		id, err := list.Insert(item)
-		if err != nil {
-			t.Fatal("insert error", err)
-		}
-
-		// id should be greater than prevID and unique
-		if id <= prevID {
-			t.Fatal("id is less than and equal to prevID")
-		}
-		prevID = id
-	}
-
-	t.Log("show list")
-	list.display()
-	t.Log("... Passed")
+func testList() *SkipList[*storagetest.Data] {
+	testSetup(4)
+	return New[*storagetest.Data]()
 }
 
 func TestSearch(t *testing.T) {
@@ -125,13 +67,13 @@ func TestSearch(t *testing.T) {
 		in       []int
 		key      int
 		want     error
-		wantData any
+		wantData *storagetest.Data
 	}{
 		{
 			name:     "data was found",
 			in:       []int{1, 3, 5, 6, 7, 8},
 			key:      5,
-			wantData: 5,
+			wantData: newTestData(5),
 			want:     nil,
 		},
 		{
@@ -147,9 +89,7 @@ func TestSearch(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			list := testList()
 			for _, key := range tt.in {
-				if err := list.insert(key, tt.wantData); err != nil {
-					t.Fatal("insert error", err)
-				}
+				list.insert(key, newTestData(key))
 			}
 
 			data, err := list.search(tt.key)
@@ -164,150 +104,149 @@ func TestSearch(t *testing.T) {
 	}
 }
 
-func TestDelete(t *testing.T) {
-	testcases := []struct {
-		name string
-		in   []int
-		key  int
-		want bool
-	}{
-		{
-			name: "deleted",
-			in:   []int{1, 3, 5, 6, 7, 8},
-			key:  5,
-			want: true,
-		},
-		{
-			name: "deleted on non-exist data",
-			in:   []int{1, 3, 5, 6, 7, 8},
-			key:  9,
-			want: false,
-		},
+func TestUpdate(t *testing.T) {
+	// the node is updated in place, so it keeps its level
+	list := testList()
+	for i := 1; i <= 100; i++ {
+		list.insert(i, newTestData(i))
+	}
+	node := list.find(50)
+	if err := list.Update(50, &storagetest.Data{ID: 50, Name: "v2"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if updated := list.find(50); updated != node || updated.data.Name != "v2" {
+		t.Fatalf("node should be updated in place")
 	}
+	if err := list.Update(101, &storagetest.Data{ID: 101, Name: "v2"}); err != ErrSkipListDataNotFound {
+		t.Fatalf("update error should be %v, but got %v", ErrSkipListDataNotFound, err)
+	}
+}
 
-	for _, tt := range testcases {
-		t.Run(tt.name, func(t *testing.T) {
-			list := testList()
-			for _, key := range tt.in {
-				if err := list.insert(key, "test-data"); err != nil {
-					t.Fatal("insert error", err)
-				}
-			}
+func TestSnapshotRestore(t *testing.T) {
+	list := testList()
+	for _, item := range []string{"a", "b", "c", "d"} {
+		if _, err := list.Insert(&storagetest.Data{Name: item}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	list.Delete(4)
+	snapshot := list.Snapshot()
 
-			ans := list.Delete(tt.key)
-			if ans != tt.want {
-				t.Fatalf("deleted should be %v, but got %v", tt.want, ans)
-			}
+	restored := testList()
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal("restore error", err)
+	}
+	if !reflect.DeepEqual(restored.Range(1, 10), list.Range(1, 10)) {
+		t.Fatalf("it should be %v, but got %v", list.Range(1, 10), restored.Range(1, 10))
+	}
 
-			// using Search() to check the node is deleted
-			if _, err := list.search(tt.key); err != ErrSkipListDataNotFound {
-				t.Fatalf("search deleted value error should be %v, but got %v", ErrSkipListDataNotFound, err)
-			}
-		})
+	// the deleted max id is not reused
+	id, err := restored.Insert(&storagetest.Data{Name: "e"})
+	if err != nil {
+		t.Fatal("insert error", err)
+	}
+	if id != 5 {
+		t.Fatalf("id should be 5, but got %d", id)
 	}
 }
 
-func TestRangeQuery(t *testing.T) {
-	testcases := []struct {
-		name  string
-		in    []int
-		start int
-		end   int
-		want  []any
-	}{
-		{
-			name:  "query - 0",
-			in:    []int{1, 2, 3, 4, 5, 6},
-			start: 1,
-			end:   1,
-			want:  []any{1},
-		},
-		{
-			name:  "query - 1",
-			in:    []int{1, 2, 3, 4, 5, 6},
-			start: 1,
-			end:   5,
-			want:  []any{1, 2, 3, 4, 5},
-		},
-		{
-			name:  "query - 2",
-			in:    []int{1, 2, 3, 4, 5, 6},
-			start: 2,
-			end:   5,
-			want:  []any{6},
-		},
-		{
-			name:  "query - 3",
-			in:    []int{1, 2, 3, 4, 5, 6},
-			start: 3,
-			end:   5,
-			want:  []any{},
-		},
-		{
-			name:  "query - 4",
-			in:    []int{1, 2, 3, 4, 5, 6, 7},
-			start: 1,
-			end:   5,
-			want:  []any{1, 2, 3, 4, 5},
-		},
-		{
-			name:  "query - 5",
-			in:    []int{1, 2, 3, 4, 5, 6, 7},
-			start: 2,
-			end:   5,
-			want:  []any{6, 7},
-		},
+// TestRangeRandomOperations compares the pages with a sorted slice after random inserts and deletes
+func TestRangeRandomOperations(t *testing.T) {
+	testSetup(6)
+	list := New[*storagetest.Data]()
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([]int, 0)
+
+	for n := 0; n < 2000; n++ {
+		key := rnd.Intn(500)
+		i := sort.SearchInts(keys, key)
+		exist := i < len(keys) && keys[i] == key
+		if rnd.Intn(3) == 0 {
+			if list.Delete(key) != exist {
+				t.Fatalf("delete %d should be %v", key, exist)
+			}
+			if exist {
+				keys = append(keys[:i], keys[i+1:]...)
+			}
+		} else if !exist {
+			list.insert(key, newTestData(key))
+			keys = append(keys[:i], append([]int{key}, keys[i:]...)...)
+		}
 	}
 
-	for _, tt := range testcases {
-		t.Run(tt.name, func(t *testing.T) {
-			list := testList()
-			for _, key := range tt.in {
-				// abuse the key as data for convince
-				if err := list.insert(key, key); err != nil {
-					t.Fatal("insert error", err)
-				}
+	for size := 1; size <= 7; size += 3 {
+		for page := 1; (page-1)*size <= len(keys); page++ {
+			start := (page - 1) * size
+			want := keys[start:min(start+size, len(keys))]
+			if ans := storagetest.IDs(list.Range(page, size)); !reflect.DeepEqual(ans, want) {
+				t.Fatalf("page %d of size %d should be %v, but got %v", page, size, want, ans)
 			}
+		}
+	}
+}
 
-			ans := list.Range(tt.start, tt.end)
-			if !reflect.DeepEqual(ans, tt.want) {
-				t.Fatalf("it should be %v, but got %v", tt.want, ans)
-			}
-		})
+func BenchmarkRange(b *testing.B) {
+	testSetup(16)
+	list := New[*storagetest.Data]()
+	for i := 1; i <= 100000; i++ {
+		list.insert(i, newTestData(i))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		list.Range(n%10000+1, 10)
 	}
 }
 
-func TestUpdate(t *testing.T) {
-	testcases := []struct {
-		name    string
-		data    any
-		newData any
-	}{
-		{
-			name:    "test data updating",
-			data:    "data - v1",
-			newData: "data - v2",
-		},
+func TestRollback(t *testing.T) {
+	list := testList()
+	for _, item := range []string{"a", "b", "c", "d"} {
+		if _, err := list.Insert(&storagetest.Data{Name: item}); err != nil {
+			t.Fatal("insert error", err)
+		}
 	}
+	list.Delete(4)
+	before := list.Snapshot()
 
-	for _, tt := range testcases {
-		t.Run(tt.name, func(t *testing.T) {
-			list := testList()
-			id, err := list.Insert(tt.data)
-			if err != nil {
-				t.Fatal("insert error", err)
-			}
-			if err := list.Update(id, tt.newData); err != nil {
-				t.Fatal("update error", err)
-			}
-			// check if data is updated
-			data, err := list.search(id)
-			if err != nil {
-				t.Fatal("search error", err)
-			}
-			if !reflect.DeepEqual(data, tt.newData) {
-				t.Fatalf("failed to update data, it should be %v, but got %v", tt.newData, data)
-			}
-		})
+	if err := list.Begin(); err != nil {
+		t.Fatal("begin error", err)
+	}
+	if err := list.Begin(); err != ErrSkipListTxInProgress {
+		t.Fatalf("begin twice should be %v, but got %v", ErrSkipListTxInProgress, err)
+	}
+	// the changes of the same keys are undone in the reverse order
+	id, _ := list.Insert(&storagetest.Data{Name: "e"})
+	list.Update(id, &storagetest.Data{ID: id, Name: "f"})
+	list.Delete(id)
+	list.Update(1, &storagetest.Data{ID: 1, Name: "g"})
+	list.Update(1, &storagetest.Data{ID: 1, Name: "h"})
+	list.Delete(2)
+	list.CompareAndSwap(3, func(*storagetest.Data) bool { return true }, &storagetest.Data{ID: 3, Name: "i"})
+	list.Delete(3)
+	list.Insert(&storagetest.Data{Name: "j"})
+	if list.Delete(9) {
+		t.Fatal("delete non-exist key should be false")
+	}
+	list.Rollback()
+
+	if after := list.Snapshot(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("it should be %v, but got %v", before, after)
+	}
+	// the ids of the rolled back inserts are reused
+	id, err := list.Insert(&storagetest.Data{Name: "k"})
+	if err != nil || id != 5 {
+		t.Fatalf("id should be 5, but got %d, %v", id, err)
+	}
+
+	// the committed changes are kept
+	if err := list.Begin(); err != nil {
+		t.Fatal("begin error", err)
+	}
+	list.Delete(1)
+	if err := list.Commit(); err != nil {
+		t.Fatal("commit error", err)
+	}
+	list.Rollback()
+	if _, ok := list.Get(1); ok {
+		t.Fatal("the committed delete should be kept")
 	}
 }
@@ -0,0 +1,144 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type op uint8
+
+const (
+	opInsert op = iota + 1
+	opUpdate
+	opDelete
+	// opTx is a committed transaction, its data is the records of the transaction
+	opTx
+)
+
+// headerSize is the size of the frame header: payload length + crc32 of payload
+const headerSize = 8
+
+const segmentExt = ".wal"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// record is a single mutation in the log
+type record struct {
+	LSN  uint64          `json:"lsn"`
+	Op   op              `json:"op"`
+	ID   int             `json:"id"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// encode frames the record as [length][crc32][json payload]
+func (r record) encode() ([]byte, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal record")
+	}
+	return frame(payload), nil
+}
+
+// readRecord reads the next record, see readFrame for the errors
+func readRecord(r *bufio.Reader) (record, int64, error) {
+	var rec record
+	payload, n, err := readFrame(r)
+	if err != nil {
+		return rec, 0, err
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, 0, errors.Wrap(ErrCorrupted, err.Error())
+	}
+	return rec, n, nil
+}
+
+// frame prepends the header of payload length and crc32 to payload
+func frame(payload []byte) []byte {
+	buf := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[headerSize:], payload)
+	return buf
+}
+
+// readFrame reads the next frame, it returns io.EOF on a clean end of the file,
+// io.ErrUnexpectedEOF on a torn write and ErrCorrupted on a checksum mismatch
+func readFrame(r *bufio.Reader) ([]byte, int64, error) {
+	header := make([]byte, headerSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF && n == 0 {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	sum := binary.LittleEndian.Uint32(header[4:8])
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(payload, crcTable) != sum {
+		return nil, 0, ErrCorrupted
+	}
+	return payload, int64(headerSize) + int64(size), nil
+}
+
+// segmentName returns the file name of the log segment which starts at lsn
+func segmentName(lsn uint64) string {
+	return fmt.Sprintf("%020d%s", lsn, segmentExt)
+}
+
+// lsnFile is a log segment or a snapshot on disk, named by an lsn.
+// A segment is named by the first lsn it may contain, a snapshot by the last lsn it covers
+type lsnFile struct {
+	lsn  uint64
+	path string
+}
+
+// listFiles returns the files in dir with the extension ext ordered by lsn
+func listFiles(dir, ext string) ([]lsnFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read wal dir")
+	}
+	files := make([]lsnFile, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		lsn, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, lsnFile{lsn: lsn, path: filepath.Join(dir, name)})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].lsn < files[j].lsn
+	})
+	return files, nil
+}
+
+// syncDir fsyncs the directory to persist the created, renamed or removed files
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "open wal dir")
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return errors.Wrap(err, "sync wal dir")
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"glookbs.github.com/storage"
+)
+
+const snapshotExt = ".snap"
+
+// DefaultSnapshotRetain is the number of snapshots kept on disk
+const DefaultSnapshotRetain = 2
+
+var ErrSnapshotUnsupported = errors.New("engine does not support snapshot")
+
+// snapshotFile is the content of a snapshot which covers the log up to LSN
+type snapshotFile struct {
+	LSN   uint64         `json:"lsn"`
+	MaxID int            `json:"max_id"`
+	Items []snapshotItem `json:"items"`
+}
+
+type snapshotItem struct {
+	ID   int             `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// snapshotName returns the file name of the snapshot which covers the log up to lsn
+func snapshotName(lsn uint64) string {
+	return fmt.Sprintf("%020d%s", lsn, snapshotExt)
+}
+
+// Snapshot writes the whole content of the engine to disk, then starts a new log
+// segment and removes the segments and snapshots which are no longer needed. It waits
+// for the transaction in progress, so the uncommitted changes are never in a snapshot
+func (w *WAL[T]) Snapshot() error {
+	w.txMu.Lock()
+	defer w.txMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	snapshotter, ok := w.engine.(storage.Snapshotter[T])
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+
+	s := snapshotter.Snapshot()
+	content := snapshotFile{
+		LSN:   w.lsn,
+		MaxID: s.MaxID,
+		Items: make([]snapshotItem, 0, len(s.Items)),
+	}
+	for _, item := range s.Items {
+		raw, err := json.Marshal(item)
+		if err != nil {
+			return errors.Wrap(err, "encode data")
+		}
+		content.Items = append(content.Items, snapshotItem{ID: item.GetID(), Data: raw})
+	}
+	if err := writeSnapshot(w.dir, content); err != nil {
+		return err
+	}
+	if err := w.rotate(); err != nil {
+		w.err = err
+		return err
+	}
+	return w.compact()
+}
+
+// writeSnapshot writes the snapshot into a temporary file and renames it, so a
+// snapshot file is either complete or absent
+func writeSnapshot(dir string, content snapshotFile) error {
+	payload, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrap(err, "marshal snapshot")
+	}
+	path := filepath.Join(dir, snapshotName(content.LSN))
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "create snapshot")
+	}
+	if _, err := f.Write(frame(payload)); err != nil {
+		f.Close()
+		return errors.Wrap(err, "write snapshot")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "sync snapshot")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close snapshot")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "rename snapshot")
+	}
+	return syncDir(dir)
+}
+
+// readSnapshot reads and verifies the snapshot file
+func readSnapshot(path string) (snapshotFile, error) {
+	var content snapshotFile
+	f, err := os.Open(path)
+	if err != nil {
+		return content, errors.Wrap(err, "open snapshot")
+	}
+	defer f.Close()
+	payload, _, err := readFrame(bufio.NewReader(f))
+	if err != nil {
+		return content, errors.Wrapf(ErrCorrupted, "snapshot %s: %v", path, err)
+	}
+	if err := json.Unmarshal(payload, &content); err != nil {
+		return content, errors.Wrapf(ErrCorrupted, "snapshot %s: %v", path, err)
+	}
+	return content, nil
+}
+
+// restore loads the newest valid snapshot into the engine, the invalid ones are skipped
+func (w *WAL[T]) restore() error {
+	snapshots, err := listFiles(w.dir, snapshotExt)
+	if err != nil {
+		return err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		content, err := readSnapshot(snapshots[i].path)
+		if err != nil || content.LSN != snapshots[i].lsn {
+			continue
+		}
+		snapshotter, ok := w.engine.(storage.Snapshotter[T])
+		if !ok {
+			return ErrSnapshotUnsupported
+		}
+		s := storage.Snapshot[T]{
+			MaxID: content.MaxID,
+			Items: make([]T, 0, len(content.Items)),
+		}
+		for _, item := range content.Items {
+			data, err := w.decode(item.Data)
+			if err != nil {
+				return err
+			}
+			data.SetID(item.ID)
+			s.Items = append(s.Items, data)
+		}
+		if err := snapshotter.Restore(s); err != nil {
+			return errors.Wrap(err, "restore snapshot")
+		}
+		w.lsn = content.LSN
+		return nil
+	}
+	return nil
+}
+
+// rotate closes the current segment and appends to a new one starting at the next lsn
+func (w *WAL[T]) rotate() error {
+	next := w.lsn + 1
+	if w.segment == next {
+		// nothing was written since the last rotation
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return errors.Wrap(err, "sync wal")
+	}
+	if err := w.file.Close(); err != nil {
+		return errors.Wrap(err, "close wal segment")
+	}
+	file, err := os.OpenFile(filepath.Join(w.dir, segmentName(next)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "create wal segment")
+	}
+	w.file = file
+	w.segment = next
+	w.dirty = false
+	return syncDir(w.dir)
+}
+
+// compact keeps the newest snapshots and removes the older snapshots and the
+// segments covered by the oldest kept snapshot
+func (w *WAL[T]) compact() error {
+	snapshots, err := listFiles(w.dir, snapshotExt)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) > w.retain {
+		for _, s := range snapshots[:len(snapshots)-w.retain] {
+			if err := os.Remove(s.path); err != nil {
+				return errors.Wrap(err, "remove snapshot")
+			}
+		}
+		snapshots = snapshots[len(snapshots)-w.retain:]
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	segments, err := listFiles(w.dir, segmentExt)
+	if err != nil {
+		return err
+	}
+	oldest := snapshots[0].lsn
+	for i := 0; i+1 < len(segments); i++ {
+		// the segment contains the lsn in [segments[i].lsn, segments[i+1].lsn)
+		if segments[i+1].lsn > oldest+1 {
+			break
+		}
+		if err := os.Remove(segments[i].path); err != nil {
+			return errors.Wrap(err, "remove wal segment")
+		}
+	}
+	return syncDir(w.dir)
+}
@@ -0,0 +1,523 @@
+// Package wal is a storage driver which makes any storage.Enginer durable, it appends
+// every Insert/Update/Delete to a write-ahead log on disk and replays the log on open.
+// Snapshots of the engine are taken periodically to truncate the log, on open the
+// newest valid snapshot is loaded and only the tail of the log is replayed. A
+// transaction of the engine is logged as a single record when it's committed
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"glookbs.github.com/storage"
+)
+
+// SyncPolicy decides when the log is flushed to the disk
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the log before every write returns
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs the log periodically in background
+	SyncInterval
+	// SyncNever leaves the flushing to the operating system
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	}
+	return "unknown"
+}
+
+// ParseSyncPolicy returns the SyncPolicy by its name
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for _, p := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, errors.Errorf("unknown sync policy %q", s)
+}
+
+// DefaultSyncInterval is the period of fsync with SyncInterval
+const DefaultSyncInterval = time.Second
+
+var (
+	ErrCorrupted = errors.New("wal is corrupted")
+	ErrClosed    = errors.New("wal is closed")
+)
+
+// config is the configuration of WAL
+type config struct {
+	policy           SyncPolicy
+	interval         time.Duration
+	snapshotInterval time.Duration
+	retain           int
+}
+
+// Option is an option form to make configuration with WAL
+type Option func(*config)
+
+// WithSyncPolicy sets the fsync policy
+func WithSyncPolicy(p SyncPolicy) Option {
+	return func(c *config) {
+		c.policy = p
+	}
+}
+
+// WithSyncInterval sets the period of fsync with SyncInterval
+func WithSyncInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.interval = d
+	}
+}
+
+// WithSnapshotInterval sets the period of taking snapshot, no periodic snapshot if d is 0
+func WithSnapshotInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.snapshotInterval = d
+	}
+}
+
+// WithSnapshotRetain sets the number of snapshots kept on disk, at least 1
+func WithSnapshotRetain(n int) Option {
+	return func(c *config) {
+		c.retain = max(n, 1)
+	}
+}
+
+// WAL wraps a storage.Enginer and logs its mutations, it implements storage.Enginer.
+// The data is encoded as json, so T should be a pointer to a struct, e.g. *entity.Task
+type WAL[T storage.Entity] struct {
+	config
+	mu      sync.Mutex
+	engine  storage.Enginer[T]
+	dir     string
+	file    *os.File
+	segment uint64 // the first lsn of the current segment
+	lsn     uint64
+	dirty   bool
+	err     error // the first write error, the log refuses writes after it
+	// txMu is held from Begin to Commit or Rollback
+	txMu sync.Mutex
+	// pending are the records of the transaction in progress, it's nil out of a transaction
+	pending []record
+	done    chan struct{}
+	wg      sync.WaitGroup
+	// closeOnce closes done once even if Close is called concurrently
+	closeOnce sync.Once
+}
+
+// Open restores the newest snapshot and replays the log in dir into engine, then returns
+// the WAL which appends to it
+func Open[T storage.Entity](dir string, engine storage.Enginer[T], opts ...Option) (*WAL[T], error) {
+	w := &WAL[T]{
+		config: config{
+			policy:   SyncInterval,
+			interval: DefaultSyncInterval,
+			retain:   DefaultSnapshotRetain,
+		},
+		engine: engine,
+		dir:    dir,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&w.config)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "create wal dir")
+	}
+	if err := w.restore(); err != nil {
+		return nil, err
+	}
+	segments, err := listFiles(dir, segmentExt)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		segments = append(segments, lsnFile{lsn: w.lsn + 1, path: filepath.Join(dir, segmentName(w.lsn+1))})
+	}
+	for i, seg := range segments {
+		if err := w.replay(seg, i == len(segments)-1); err != nil {
+			return nil, err
+		}
+	}
+
+	current := segments[len(segments)-1]
+	file, err := os.OpenFile(current.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open wal segment")
+	}
+	w.file = file
+	w.segment = current.lsn
+
+	if w.policy == SyncInterval {
+		w.wg.Add(1)
+		go w.syncLoop()
+	}
+	if w.snapshotInterval > 0 {
+		w.wg.Add(1)
+		go w.snapshotLoop()
+	}
+	return w, nil
+}
+
+// replay applies the records of seg which are not covered by the snapshot to the engine,
+// a torn or corrupted tail of the last segment is a write which was never acknowledged
+// and is truncated
+func (w *WAL[T]) replay(seg lsnFile, last bool) error {
+	f, err := os.Open(seg.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "open wal segment")
+	}
+	defer f.Close()
+
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if !last {
+				return errors.Wrapf(ErrCorrupted, "segment %s at offset %d", seg.path, offset)
+			}
+			return os.Truncate(seg.path, offset)
+		}
+		offset += n
+		if rec.LSN <= w.lsn {
+			continue
+		}
+		if rec.LSN != w.lsn+1 {
+			return errors.Wrapf(ErrCorrupted, "missing lsn %d before %d", w.lsn+1, rec.LSN)
+		}
+		if err := w.apply(rec); err != nil {
+			return err
+		}
+		w.lsn = rec.LSN
+	}
+}
+
+func (w *WAL[T]) apply(rec record) error {
+	switch rec.Op {
+	case opInsert:
+		data, err := w.decode(rec.Data)
+		if err != nil {
+			return err
+		}
+		id, err := w.engine.Insert(data)
+		if err != nil {
+			return errors.Wrapf(err, "replay insert %d", rec.ID)
+		}
+		if id != rec.ID {
+			return errors.Wrapf(ErrCorrupted, "replay insert got id %d, want %d", id, rec.ID)
+		}
+	case opUpdate:
+		data, err := w.decode(rec.Data)
+		if err != nil {
+			return err
+		}
+		// updates are logged before being applied, so a failure mirrors the original call
+		_ = w.engine.Update(rec.ID, data)
+	case opDelete:
+		w.engine.Delete(rec.ID)
+	case opTx:
+		var records []record
+		if err := json.Unmarshal(rec.Data, &records); err != nil {
+			return errors.Wrapf(ErrCorrupted, "decode transaction at lsn %d", rec.LSN)
+		}
+		for _, r := range records {
+			r.LSN = rec.LSN
+			if err := w.apply(r); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.Wrapf(ErrCorrupted, "unknown op %d at lsn %d", rec.Op, rec.LSN)
+	}
+	return nil
+}
+
+func (w *WAL[T]) decode(raw json.RawMessage) (T, error) {
+	var data T
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return data, errors.Wrap(err, "decode record data")
+	}
+	return data, nil
+}
+
+// append writes the record to the log and flushes it according to the sync policy, the
+// record is kept in pending in a transaction
+func (w *WAL[T]) append(rec record) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.pending != nil {
+		w.pending = append(w.pending, rec)
+		return nil
+	}
+	rec.LSN = w.lsn + 1
+	buf, err := rec.encode()
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(buf); err != nil {
+		w.err = errors.Wrap(err, "write wal")
+		return w.err
+	}
+	w.lsn = rec.LSN
+	if w.policy != SyncAlways {
+		w.dirty = true
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		w.err = errors.Wrap(err, "sync wal")
+		return w.err
+	}
+	return nil
+}
+
+func (w *WAL[T]) syncLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			w.sync()
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *WAL[T]) snapshotLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if err := w.Snapshot(); err != nil {
+				log.Println("wal snapshot error:", err)
+			}
+		}
+	}
+}
+
+func (w *WAL[T]) sync() {
+	if !w.dirty || w.err != nil {
+		return
+	}
+	if err := w.file.Sync(); err != nil {
+		w.err = errors.Wrap(err, "sync wal")
+		return
+	}
+	w.dirty = false
+}
+
+// Insert inserts data into the engine and logs it, the insert is rolled back if logging fails
+func (w *WAL[T]) Insert(data T) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return -1, w.err
+	}
+	id, err := w.engine.Insert(data)
+	if err != nil {
+		return -1, err
+	}
+	raw, err := json.Marshal(data)
+	if err == nil {
+		err = w.append(record{Op: opInsert, ID: id, Data: raw})
+	}
+	if err != nil {
+		w.engine.Delete(id)
+		return -1, err
+	}
+	return id, nil
+}
+
+func (w *WAL[T]) Get(id int) (T, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.engine.Get(id)
+}
+
+func (w *WAL[T]) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.engine.Count()
+}
+
+func (w *WAL[T]) Range(i, j int) []T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.engine.Range(i, j)
+}
+
+func (w *WAL[T]) Scan(after, n int) []T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.engine.Scan(after, n)
+}
+
+// Delete logs the deletion then applies it, it returns false if logging fails, and Err
+// returns the error then
+func (w *WAL[T]) Delete(id int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.append(record{Op: opDelete, ID: id}); err != nil {
+		return false
+	}
+	return w.engine.Delete(id)
+}
+
+// Update logs the update then applies it
+func (w *WAL[T]) Update(id int, data T) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "encode data")
+	}
+	if err := w.append(record{Op: opUpdate, ID: id, Data: raw}); err != nil {
+		return err
+	}
+	return w.engine.Update(id, data)
+}
+
+// CompareAndSwap logs the update then applies it only if match reports true for the
+// current data, nothing is logged if it does not match
+func (w *WAL[T]) CompareAndSwap(id int, match func(current T) bool, data T) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	current, ok := w.engine.Get(id)
+	if !ok || !match(current) {
+		return false, nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return false, errors.Wrap(err, "encode data")
+	}
+	if err := w.append(record{Op: opUpdate, ID: id, Data: raw}); err != nil {
+		return false, err
+	}
+	return w.engine.CompareAndSwap(id, match, data)
+}
+
+// Begin begins a transaction of the engine, it returns storage.ErrTxNotSupported if the
+// engine is not a storage.Transactioner. The records of the transaction are kept in
+// memory until it's committed, and snapshots wait for it
+func (w *WAL[T]) Begin() error {
+	t, ok := w.engine.(storage.Transactioner)
+	if !ok {
+		return storage.ErrTxNotSupported
+	}
+	w.txMu.Lock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := t.Begin(); err != nil {
+		w.txMu.Unlock()
+		return err
+	}
+	w.pending = []record{}
+	return nil
+}
+
+// Commit logs the records of the transaction as a single record, so the transaction is
+// replayed all or nothing, then commits the engine. The transaction is still in
+// progress if it fails, and should be rolled back
+func (w *WAL[T]) Commit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending := w.pending
+	if len(pending) > 0 {
+		w.pending = nil
+		raw, err := json.Marshal(pending)
+		if err == nil {
+			err = w.append(record{Op: opTx, Data: raw})
+		}
+		if err != nil {
+			w.pending = pending
+			return err
+		}
+	}
+	w.pending = nil
+	if err := w.engine.(storage.Transactioner).Commit(); err != nil {
+		return err
+	}
+	w.txMu.Unlock()
+	return nil
+}
+
+// Rollback discards the records of the transaction and rolls back the engine
+func (w *WAL[T]) Rollback() {
+	w.mu.Lock()
+	w.pending = nil
+	w.engine.(storage.Transactioner).Rollback()
+	w.mu.Unlock()
+	w.txMu.Unlock()
+}
+
+// Err returns the error which stopped the log from accepting writes
+func (w *WAL[T]) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close flushes and closes the log, and closes the engine if it's an io.Closer, it
+// returns ErrClosed if the log was closed
+func (w *WAL[T]) Close() error {
+	err := ErrClosed
+	w.closeOnce.Do(func() {
+		err = w.close()
+	})
+	return err
+}
+
+func (w *WAL[T]) close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sync()
+	err := w.file.Close()
+	w.file = nil
+	if w.err == nil {
+		w.err = ErrClosed
+	}
+	if err != nil {
+		return errors.Wrap(err, "close wal")
+	}
+	if closer, ok := w.engine.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
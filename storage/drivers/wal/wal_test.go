@@ -0,0 +1,308 @@
+package wal
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func openTestWAL(t *testing.T, dir string) *WAL[*storagetest.Data] {
+	w, err := Open(dir, skiplists.New[*storagetest.Data](), WithSyncPolicy(SyncAlways))
+	if err != nil {
+		t.Fatal("open wal error", err)
+	}
+	return w
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if _, err := w.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	if err := w.Update(2, &storagetest.Data{ID: 2, Name: "b - v2"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if !w.Delete(3) {
+		t.Fatal("delete should be true")
+	}
+	// the failed calls are logged too and must not break the replay
+	if w.Delete(9) {
+		t.Fatal("delete non-exist data should be false")
+	}
+	if err := w.Update(9, &storagetest.Data{ID: 9}); err == nil {
+		t.Fatal("update non-exist data should be failed")
+	}
+	isName := func(name string) func(*storagetest.Data) bool {
+		return func(current *storagetest.Data) bool { return current.Name == name }
+	}
+	if swapped, err := w.CompareAndSwap(1, isName("a"), &storagetest.Data{ID: 1, Name: "a - v2"}); err != nil || !swapped {
+		t.Fatalf("swapped should be true, but got %v, %v", swapped, err)
+	}
+	// the unmatched swap is not logged
+	if swapped, err := w.CompareAndSwap(4, isName("c"), &storagetest.Data{ID: 4, Name: "d - v2"}); err != nil || swapped {
+		t.Fatalf("swapped should be false, but got %v, %v", swapped, err)
+	}
+	want := w.Range(1, 10)
+	if err := w.Close(); err != nil {
+		t.Fatal("close error", err)
+	}
+
+	w = openTestWAL(t, dir)
+	defer w.Close()
+	if got := w.Range(1, 10); !reflect.DeepEqual(got, want) {
+		t.Fatalf("it should be %v, but got %v", want, got)
+	}
+
+	// ids are never reused after the replay
+	id, err := w.Insert(&storagetest.Data{Name: "e"})
+	if err != nil {
+		t.Fatal("insert error", err)
+	}
+	if id != 5 {
+		t.Fatalf("id should be 5, but got %d", id)
+	}
+}
+
+func TestReplayTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	for _, name := range []string{"a", "b"} {
+		if _, err := w.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("close error", err)
+	}
+
+	// simulate a crash in the middle of writing a record
+	path := dir + "/" + segmentName(1)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{42, 0, 0, 0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w = openTestWAL(t, dir)
+	if n := w.Count(); n != 2 {
+		t.Fatalf("count should be 2, but got %d", n)
+	}
+	if _, err := w.Insert(&storagetest.Data{Name: "c"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	w.Close()
+
+	// the tail was truncated, so the new record is readable
+	w = openTestWAL(t, dir)
+	defer w.Close()
+	if n := w.Count(); n != 3 {
+		t.Fatalf("count should be 3, but got %d", n)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := openTestWAL(t, t.TempDir())
+	s := storage.New[*storagetest.Data](w)
+	if _, err := s.Insert(&storagetest.Data{Name: "a"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	// simulate an I/O error of the log
+	w.file.Close()
+
+	// the failed delete is not taken as a nonexistent data
+	err := s.Delete(1)
+	if err == nil || errors.Is(err, storage.ErrNotFound) || !errors.Is(err, w.Err()) {
+		t.Fatalf("delete error should be %v, but got %v", w.Err(), err)
+	}
+	if _, ok := w.Get(1); !ok {
+		t.Fatal("the data should be kept")
+	}
+	if err := s.Delete(9); !errors.Is(err, w.Err()) {
+		t.Fatalf("delete error of the stopped log should be %v, but got %v", w.Err(), err)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	w := openTestWAL(t, t.TempDir())
+	errs := make(chan error, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- w.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	closed := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			closed++
+		case !errors.Is(err, ErrClosed):
+			t.Fatalf("close error should be %v, but got %v", ErrClosed, err)
+		}
+	}
+	if closed != 1 {
+		t.Fatalf("the log should be closed once, but got %d", closed)
+	}
+	if err := w.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("close error should be %v, but got %v", ErrClosed, err)
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	testcases := []struct {
+		in      string
+		want    SyncPolicy
+		wantErr bool
+	}{
+		{in: "always", want: SyncAlways},
+		{in: "interval", want: SyncInterval},
+		{in: "never", want: SyncNever},
+		{in: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range testcases {
+		t.Run(tt.in, func(t *testing.T) {
+			p, err := ParseSyncPolicy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error should be %v, but got %v", tt.wantErr, err)
+			}
+			if err == nil && p != tt.want {
+				t.Fatalf("it should be %v, but got %v", tt.want, p)
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := w.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	w.Delete(3)
+	if err := w.Snapshot(); err != nil {
+		t.Fatal("snapshot error", err)
+	}
+	if err := w.Update(1, &storagetest.Data{ID: 1, Name: "a - v2"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if err := w.Snapshot(); err != nil {
+		t.Fatal("snapshot error", err)
+	}
+	if _, err := w.Insert(&storagetest.Data{Name: "d"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	want := w.Range(1, 10)
+	w.Close()
+
+	// the segment covered by the oldest kept snapshot was removed
+	segments, err := listFiles(dir, segmentExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 || segments[0].lsn != 5 || segments[1].lsn != 6 {
+		t.Fatalf("segments should start at lsn 5 and 6, but got %v", segments)
+	}
+
+	w = openTestWAL(t, dir)
+	if got := w.Range(1, 10); !reflect.DeepEqual(got, want) {
+		t.Fatalf("it should be %v, but got %v", want, got)
+	}
+	// the max id is restored with the snapshot, so the deleted id 3 is not reused
+	id, err := w.Insert(&storagetest.Data{Name: "e"})
+	if err != nil {
+		t.Fatal("insert error", err)
+	}
+	if id != 5 {
+		t.Fatalf("id should be 5, but got %d", id)
+	}
+	want = w.Range(1, 10)
+	w.Close()
+
+	// a broken newest snapshot falls back to the older one and a longer replay
+	snapshots, err := listFiles(dir, snapshotExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(snapshots[len(snapshots)-1].path, []byte("broken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w = openTestWAL(t, dir)
+	defer w.Close()
+	if got := w.Range(1, 10); !reflect.DeepEqual(got, want) {
+		t.Fatalf("it should be %v, but got %v", want, got)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	for _, name := range []string{"a", "b"} {
+		if _, err := w.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+
+	// a committed transaction
+	if err := w.Begin(); err != nil {
+		t.Fatal("begin error", err)
+	}
+	if _, err := w.Insert(&storagetest.Data{Name: "c"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	if err := w.Update(1, &storagetest.Data{ID: 1, Name: "a - v2"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal("commit error", err)
+	}
+
+	// a rolled back transaction is never logged
+	if err := w.Begin(); err != nil {
+		t.Fatal("begin error", err)
+	}
+	w.Delete(2)
+	if _, err := w.Insert(&storagetest.Data{Name: "d"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	w.Rollback()
+
+	want := []*storagetest.Data{{ID: 1, Name: "a - v2"}, {ID: 2, Name: "b"}, {ID: 3, Name: "c"}}
+	if got := w.Range(1, 10); !reflect.DeepEqual(got, want) {
+		t.Fatalf("it should be %v, but got %v", want, got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("close error", err)
+	}
+
+	w = openTestWAL(t, dir)
+	defer w.Close()
+	if got := w.Range(1, 10); !reflect.DeepEqual(got, want) {
+		t.Fatalf("it should be %v, but got %v", want, got)
+	}
+	// the transaction is a single record
+	if w.lsn != 3 {
+		t.Fatalf("lsn should be 3, but got %d", w.lsn)
+	}
+	if err := w.Snapshot(); err != nil {
+		t.Fatal("snapshot error", err)
+	}
+}
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// indexMaxLevel is the max level of the nodes of an index
+const indexMaxLevel = 16
+
+// ErrIndexNotFound is returned if a query refers to an index which was not added
+var ErrIndexNotFound = errors.New("index is not exist")
+
+// ErrIndexKeyType is returned if the key of a Cond is not the key type of the index
+var ErrIndexKeyType = errors.New("key type mismatches the index")
+
+// ErrIndexCond is returned if a Cond is not supported by the kind of the index, e.g. a
+// range of a tag index
+var ErrIndexCond = errors.New("condition is not supported by the index")
+
+// index is a secondary index of Storage, it orders the ids of data by a key of the data
+type index[T Entity] interface {
+	// insert indexes data by id, the old entry of id is replaced
+	insert(id int, data T)
+	// delete removes the entry of id
+	delete(id int)
+	// check returns ErrIndexKeyType if the keys of c can't be compared with the index
+	check(c Cond) error
+	// match reports whether the key of id is selected by c
+	match(id int, c Cond) bool
+	// count returns the number of ids selected by c
+	count(c *Cond) int
+	// walk calls fn with the ids selected by c in the order of keys until fn returns
+	// false, starting right after the position of after if it's not nil. A nil c
+	// selects all the ids
+	walk(c *Cond, after *T, desc bool, fn func(id int) bool)
+}
+
+// Cond selects the data by the keys of the index, it's created by Eq, Between or Prefix,
+// or by HasAny or HasAll for a tag index
+type Cond struct {
+	Index string
+	// from and to are the bounds of keys, nil is unbounded
+	from, to any
+	// toIncluded is whether to is selected
+	toIncluded bool
+	// tags are the tags of a tag index, all is whether all of them are required
+	tags []string
+	all  bool
+}
+
+// Eq selects the data whose key of the index equals key
+func Eq(index string, key any) Cond {
+	return Cond{Index: index, from: key, to: key, toIncluded: true}
+}
+
+// Between selects the data whose key of the index is in [from, to), a nil bound is unbounded
+func Between(index string, from, to any) Cond {
+	return Cond{Index: index, from: from, to: to}
+}
+
+// Prefix selects the data whose key of the string index starts with prefix
+func Prefix(index string, prefix string) Cond {
+	c := Cond{Index: index, from: prefix}
+	// the smallest string after all the strings with the prefix
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			c.to = string(end[:i+1])
+			break
+		}
+	}
+	return c
+}
+
+// AddIndex adds the index by name to the storage, the data are ordered by key then id
+// in the index. The existing data are indexed at once, and the index is maintained on
+// every change since then. An index with the same name is replaced
+func AddIndex[T Entity, K cmp.Ordered](s *Storage[T], name string, key func(data T) K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := newOrderedIndex(key)
+	s.walk(0, func(data T) bool {
+		idx.insert(data.GetID(), data)
+		return true
+	})
+	if s.indexes == nil {
+		s.indexes = make(map[string]index[T])
+	}
+	s.indexes[name] = idx
+}
+
+type indexNode[K cmp.Ordered] struct {
+	key  K
+	id   int
+	prev *indexNode[K]
+	next []*indexNode[K]
+}
+
+// orderedIndex is a skip list of (key, id) entries with the backward links at level 0,
+// the key of every id is kept, so an entry can be removed after the data was changed
+type orderedIndex[T Entity, K cmp.Ordered] struct {
+	key   func(data T) K
+	keys  map[int]K
+	head  *indexNode[K]
+	tail  *indexNode[K]
+	level int
+}
+
+func newOrderedIndex[T Entity, K cmp.Ordered](key func(data T) K) *orderedIndex[T, K] {
+	return &orderedIndex[T, K]{
+		key:   key,
+		keys:  make(map[int]K),
+		head:  &indexNode[K]{next: make([]*indexNode[K], indexMaxLevel)},
+		level: 1,
+	}
+}
+
+// less reports whether the entry of node is before (key, id)
+func (n *indexNode[K]) less(key K, id int) bool {
+	if c := cmp.Compare(n.key, key); c != 0 {
+		return c < 0
+	}
+	return n.id < id
+}
+
+// seek returns the last nodes before (key, id) of every level
+func (idx *orderedIndex[T, K]) seek(key K, id int) []*indexNode[K] {
+	update := make([]*indexNode[K], indexMaxLevel)
+	current := idx.head
+	for i := idx.level - 1; i >= 0; i-- {
+		for current.next[i] != nil && current.next[i].less(key, id) {
+			current = current.next[i]
+		}
+		update[i] = current
+	}
+	return update
+}
+
+func (idx *orderedIndex[T, K]) insert(id int, data T) {
+	idx.delete(id)
+	key := idx.key(data)
+	update := idx.seek(key, id)
+
+	level := 1
+	for rand.Float32() < 0.5 && level < indexMaxLevel {
+		level++
+	}
+	for i := idx.level; i < level; i++ {
+		update[i] = idx.head
+	}
+	idx.level = max(idx.level, level)
+
+	node := &indexNode[K]{key: key, id: id, prev: update[0], next: make([]*indexNode[K], level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node
+	} else {
+		idx.tail = node
+	}
+	idx.keys[id] = key
+}
+
+func (idx *orderedIndex[T, K]) delete(id int) {
+	key, ok := idx.keys[id]
+	if !ok {
+		return
+	}
+	update := idx.seek(key, id)
+	target := update[0].next[0]
+	for i := 0; i < idx.level; i++ {
+		if update[i].next[i] == target {
+			update[i].next[i] = target.next[i]
+		}
+	}
+	if target.next[0] != nil {
+		target.next[0].prev = target.prev
+	} else if target.prev != idx.head {
+		idx.tail = target.prev
+	} else {
+		idx.tail = nil
+	}
+	for idx.level > 1 && idx.head.next[idx.level-1] == nil {
+		idx.level--
+	}
+	delete(idx.keys, id)
+}
+
+// keyRange is Cond with the typed bounds
+type keyRange[K cmp.Ordered] struct {
+	from, to   *K
+	toIncluded bool
+}
+
+func (idx *orderedIndex[T, K]) keyRange(c Cond) (keyRange[K], error) {
+	r := keyRange[K]{toIncluded: c.toIncluded}
+	if c.tags != nil {
+		return r, ErrIndexCond
+	}
+	for _, b := range []struct {
+		v   any
+		ptr **K
+	}{{c.from, &r.from}, {c.to, &r.to}} {
+		if b.v == nil {
+			continue
+		}
+		k, ok := b.v.(K)
+		if !ok {
+			return r, ErrIndexKeyType
+		}
+		*b.ptr = &k
+	}
+	return r, nil
+}
+
+func (r keyRange[K]) afterFrom(key K) bool {
+	return r.from == nil || cmp.Compare(key, *r.from) >= 0
+}
+
+func (r keyRange[K]) beforeTo(key K) bool {
+	if r.to == nil {
+		return true
+	}
+	c := cmp.Compare(key, *r.to)
+	return c < 0 || (c == 0 && r.toIncluded)
+}
+
+func (idx *orderedIndex[T, K]) check(c Cond) error {
+	_, err := idx.keyRange(c)
+	return err
+}
+
+func (idx *orderedIndex[T, K]) match(id int, c Cond) bool {
+	key, ok := idx.keys[id]
+	if !ok {
+		return false
+	}
+	r, err := idx.keyRange(c)
+	return err == nil && r.afterFrom(key) && r.beforeTo(key)
+}
+
+func (idx *orderedIndex[T, K]) count(c *Cond) int {
+	if c == nil {
+		return len(idx.keys)
+	}
+	total := 0
+	idx.walk(c, nil, false, func(int) bool {
+		total++
+		return true
+	})
+	return total
+}
+
+func (idx *orderedIndex[T, K]) walk(c *Cond, after *T, desc bool, fn func(id int) bool) {
+	var r keyRange[K]
+	if c != nil {
+		var err error
+		if r, err = idx.keyRange(*c); err != nil {
+			return
+		}
+	}
+	if desc {
+		idx.walkDesc(r, after, fn)
+		return
+	}
+
+	node := idx.head.next[0]
+	if r.from != nil {
+		// the first node of the from key
+		node = idx.seek(*r.from, math.MinInt)[0].next[0]
+	}
+	if after != nil {
+		key, id := idx.key(*after), (*after).GetID()
+		next := idx.seek(key, id)[0].next[0]
+		if next != nil && next.key == key && next.id == id {
+			next = next.next[0]
+		}
+		if node != nil && (next == nil || node.less(next.key, next.id)) {
+			node = next
+		}
+	}
+	for node != nil && r.beforeTo(node.key) && fn(node.id) {
+		node = node.next[0]
+	}
+}
+
+func (idx *orderedIndex[T, K]) walkDesc(r keyRange[K], after *T, fn func(id int) bool) {
+	node := idx.tail
+	if r.to != nil {
+		// the last node of the to key, or the last node before it
+		bound := math.MinInt
+		if r.toIncluded {
+			bound = math.MaxInt
+		}
+		node = idx.seek(*r.to, bound)[0]
+	}
+	if after != nil {
+		prev := idx.seek(idx.key(*after), (*after).GetID())[0]
+		if node != nil && node != idx.head && (prev == idx.head || prev.less(node.key, node.id)) {
+			node = prev
+		}
+	}
+	for node != nil && node != idx.head && r.afterFrom(node.key) && fn(node.id) {
+		node = node.prev
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestOrderedIndexRandomOperations(t *testing.T) {
+	idx := newOrderedIndex(func(d *storagetest.Data) int { return d.Key })
+	keys := make(map[int]int)
+	for i := 0; i < 2000; i++ {
+		id := rand.Intn(200) + 1
+		if rand.Intn(3) == 0 {
+			idx.delete(id)
+			delete(keys, id)
+			continue
+		}
+		// few keys, so many entries share a key, and the key of an id may change
+		key := rand.Intn(7)
+		idx.insert(id, &storagetest.Data{ID: id, Key: key})
+		keys[id] = key
+	}
+
+	want := make([]int, 0, len(keys))
+	for id := range keys {
+		want = append(want, id)
+	}
+	sort.Slice(want, func(i, j int) bool {
+		if keys[want[i]] != keys[want[j]] {
+			return keys[want[i]] < keys[want[j]]
+		}
+		return want[i] < want[j]
+	})
+
+	collect := func(walk func(fn func(id int) bool), n int) []int {
+		ids := []int{}
+		walk(func(id int) bool {
+			ids = append(ids, id)
+			return len(ids) < n
+		})
+		return ids
+	}
+	reversed := func(ids []int) []int {
+		r := make([]int, 0, len(ids))
+		for i := len(ids) - 1; i >= 0; i-- {
+			r = append(r, ids[i])
+		}
+		return r
+	}
+
+	asc := collect(func(fn func(id int) bool) { idx.walk(nil, nil, false, fn) }, len(want)+1)
+	if !reflect.DeepEqual(asc, want) {
+		t.Fatalf("ascending walk should be %v, but got %v", want, asc)
+	}
+	desc := collect(func(fn func(id int) bool) { idx.walk(nil, nil, true, fn) }, len(want)+1)
+	if !reflect.DeepEqual(desc, reversed(want)) {
+		t.Fatalf("descending walk should be %v, but got %v", reversed(want), desc)
+	}
+
+	// walk the ranges of keys
+	conds := []Cond{Eq("", 3), Between("", 2, 5), Between("", nil, 1), Between("", 6, nil), Between("", 4, 4)}
+	for _, c := range conds {
+		var selected []int
+		for _, id := range want {
+			if idx.match(id, c) {
+				selected = append(selected, id)
+			}
+		}
+		if idx.count(&c) != len(selected) {
+			t.Fatalf("count of %+v should be %v, but got %v", c, len(selected), idx.count(&c))
+		}
+		// start after every entry, in or out of the range
+		for i, id := range want {
+			later, earlier := []int{}, []int{}
+			for _, other := range want[i+1:] {
+				if idx.match(other, c) {
+					later = append(later, other)
+				}
+			}
+			for _, other := range want[:i] {
+				if idx.match(other, c) {
+					earlier = append(earlier, other)
+				}
+			}
+			after := &storagetest.Data{ID: id, Key: keys[id]}
+			ans := collect(func(fn func(id int) bool) { idx.walk(&c, &after, false, fn) }, len(want))
+			if !reflect.DeepEqual(ans, later) {
+				t.Fatalf("walk %+v after %d should be %v, but got %v", c, id, later, ans)
+			}
+			ans = collect(func(fn func(id int) bool) { idx.walk(&c, &after, true, fn) }, len(want))
+			if expected := reversed(earlier); !reflect.DeepEqual(ans, expected) {
+				t.Fatalf("descending walk %+v after %d should be %v, but got %v", c, id, expected, ans)
+			}
+		}
+	}
+
+	for i, id := range want {
+		after := &storagetest.Data{ID: id, Key: keys[id]}
+		ans := collect(func(fn func(id int) bool) { idx.walk(nil, &after, false, fn) }, 3)
+		if expected := want[i+1 : min(i+4, len(want))]; !reflect.DeepEqual(ans, expected) {
+			t.Fatalf("walk after %d should be %v, but got %v", id, expected, ans)
+		}
+		ans = collect(func(fn func(id int) bool) { idx.walk(nil, &after, true, fn) }, 3)
+		if expected := reversed(want[max(i-3, 0):i]); !reflect.DeepEqual(ans, expected) {
+			t.Fatalf("descending walk after %d should be %v, but got %v", id, expected, ans)
+		}
+	}
+}
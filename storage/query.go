@@ -0,0 +1,245 @@
+package storage
+
+// scanBatch is the number of data fetched from the engine at once when walking
+const scanBatch = 256
+
+// Filter reports whether the data matches a query, a nil Filter matches all the data
+type Filter[T Entity] func(data T) bool
+
+// And returns the filter which matches the data matched by all the filters
+func And[T Entity](filters ...Filter[T]) Filter[T] {
+	var fs []Filter[T]
+	for _, f := range filters {
+		if f != nil {
+			fs = append(fs, f)
+		}
+	}
+	if len(fs) == 0 {
+		return nil
+	}
+	return func(data T) bool {
+		for _, f := range fs {
+			if !f(data) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Query selects and orders the data of Find and FindAfter
+type Query[T Entity] struct {
+	// Where selects the data by indexes, the conditions are checked with the keys kept
+	// by the indexes, so the unselected data are never fetched from the engine
+	Where []Cond
+	// Filter selects the data which can't be selected by indexes
+	Filter Filter[T]
+	// SortBy is the name of the index to order by, the data are ordered by id if it's empty
+	SortBy string
+	// Desc reverses the order of SortBy
+	Desc bool
+}
+
+func (q Query[T]) match(data T) bool {
+	return q.Filter == nil || q.Filter(data)
+}
+
+// plan is how a query is executed, it walks the range of an index or the engine in the
+// order of id if there is no index to walk
+type plan[T Entity] struct {
+	index index[T]
+	// cond is the range of index to walk, nil is the whole index
+	cond *Cond
+	// conds are checked for every id walked
+	conds []indexCond[T]
+}
+
+type indexCond[T Entity] struct {
+	index index[T]
+	cond  Cond
+}
+
+// plan returns the plan of q, an index walked by SortBy, by an Eq condition or by a tag
+// condition keeps the order of the query
+func (s *Storage[T]) plan(q Query[T]) (plan[T], error) {
+	var p plan[T]
+	for _, c := range q.Where {
+		idx, ok := s.indexes[c.Index]
+		if !ok {
+			return p, ErrIndexNotFound
+		}
+		if err := idx.check(c); err != nil {
+			return p, err
+		}
+		p.conds = append(p.conds, indexCond[T]{index: idx, cond: c})
+	}
+
+	if len(q.SortBy) > 0 {
+		idx, ok := s.indexes[q.SortBy]
+		if !ok {
+			return p, ErrIndexNotFound
+		}
+		p.index = idx
+		for i, c := range q.Where {
+			if c.Index == q.SortBy {
+				p.cond = &q.Where[i]
+				break
+			}
+		}
+		return p, nil
+	}
+	// the ids of an equal key or of tags are in the order of id
+	for i, c := range q.Where {
+		if (c.toIncluded && c.from == c.to) || c.tags != nil {
+			p.index = p.conds[i].index
+			p.cond = &q.Where[i]
+			break
+		}
+	}
+	return p, nil
+}
+
+// selects reports whether the id is selected by all the conditions
+func (p plan[T]) selects(id int) bool {
+	for _, c := range p.conds {
+		if !c.index.match(id, c.cond) {
+			return false
+		}
+	}
+	return true
+}
+
+// Find returns the matched data with page i and page size j in the order of the query,
+// and the number of all the matched data. The data is filtered while walking the engine
+// or the index, so only a page is copied
+func (s *Storage[T]) Find(q Query[T], i, j int) ([]T, int, error) {
+	s.rlock()
+	defer s.runlock()
+	return s.find(q, i, j)
+}
+
+// find is Find, the caller must hold the lock
+func (s *Storage[T]) find(q Query[T], i, j int) ([]T, int, error) {
+	if q.Filter == nil && len(q.Where) == 0 && len(q.SortBy) == 0 {
+		return s.engine.Range(i, j), s.engine.Count(), nil
+	}
+	p, err := s.plan(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	start := (i - 1) * j
+	result := []T{}
+	total := 0
+	s.each(q, p, nil, func(data T) bool {
+		if !q.match(data) {
+			return true
+		}
+		if total >= start && len(result) < j {
+			result = append(result, data)
+		}
+		total++
+		// the total without filter is counted by the indexes, so stop at the end of the page
+		return q.Filter != nil || len(result) < j
+	})
+	if q.Filter == nil {
+		total = s.count(p)
+	}
+	return result, total, nil
+}
+
+// FindAfter returns at most n matched data right after the data after in the order of
+// the query, and the number of all the matched data. Only the id and the keys of the
+// index of SortBy of after are used, so it works even if after was deleted. Without
+// SortBy the key of the first equal condition of after is used too
+func (s *Storage[T]) FindAfter(q Query[T], after T, n int) ([]T, int, error) {
+	s.rlock()
+	defer s.runlock()
+	p, err := s.plan(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := []T{}
+	if n > 0 {
+		s.each(q, p, &after, func(data T) bool {
+			if q.match(data) {
+				result = append(result, data)
+			}
+			return len(result) < n
+		})
+	}
+	if q.Filter == nil {
+		return result, s.count(p), nil
+	}
+	total := 0
+	s.each(q, p, nil, func(data T) bool {
+		if q.Filter(data) {
+			total++
+		}
+		return true
+	})
+	return result, total, nil
+}
+
+// count returns the number of the data selected by the conditions of the plan
+func (s *Storage[T]) count(p plan[T]) int {
+	if len(p.conds) == 0 {
+		return s.engine.Count()
+	}
+	if len(p.conds) == 1 && p.cond != nil {
+		return p.index.count(p.cond)
+	}
+	total := 0
+	walk := func(id int) bool {
+		if p.selects(id) {
+			total++
+		}
+		return true
+	}
+	if p.index != nil {
+		p.index.walk(p.cond, nil, false, walk)
+		return total
+	}
+	// walk the index of any condition for the ids
+	p.conds[0].index.walk(&p.conds[0].cond, nil, false, walk)
+	return total
+}
+
+// each calls fn with the data selected by the conditions of the plan in the order of the
+// query, starting right after the data after if it's not nil, until fn returns false.
+// The caller must hold the lock
+func (s *Storage[T]) each(q Query[T], p plan[T], after *T, fn func(data T) bool) {
+	if p.index == nil {
+		start := 0
+		if after != nil {
+			start = (*after).GetID()
+		}
+		s.walk(start, func(data T) bool {
+			return !p.selects(data.GetID()) || fn(data)
+		})
+		return
+	}
+	p.index.walk(p.cond, after, q.Desc, func(id int) bool {
+		if !p.selects(id) {
+			return true
+		}
+		data, ok := s.engine.Get(id)
+		return !ok || fn(data)
+	})
+}
+
+// walk calls fn with the data whose id is greater than after in the order of id until
+// fn returns false, the caller must hold the lock
+func (s *Storage[T]) walk(after int, fn func(data T) bool) {
+	for {
+		batch := s.engine.Scan(after, scanBatch)
+		for _, data := range batch {
+			if !fn(data) {
+				return
+			}
+		}
+		if len(batch) < scanBatch {
+			return
+		}
+		after = batch[len(batch)-1].GetID()
+	}
+}
@@ -0,0 +1,298 @@
+package storage_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestFind(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data]())
+	for i := 0; i < 600; i++ {
+		name := "odd"
+		if i%2 == 1 {
+			name = "even"
+		}
+		if _, err := s.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	even := func(d *storagetest.Data) bool { return d.Name == "even" }
+	prefix := func(d *storagetest.Data) bool { return strings.HasPrefix(d.Name, "ev") }
+	fifties := func(d *storagetest.Data) bool { return d.ID%50 == 0 }
+
+	testcases := []struct {
+		name      string
+		filter    storage.Filter[*storagetest.Data]
+		page      int
+		size      int
+		want      []int
+		wantTotal int
+	}{
+		{
+			name:      "no filter",
+			filter:    nil,
+			page:      2,
+			size:      3,
+			want:      []int{4, 5, 6},
+			wantTotal: 600,
+		},
+		{
+			name:      "filter across batches",
+			filter:    even,
+			page:      130,
+			size:      2,
+			want:      []int{518, 520},
+			wantTotal: 300,
+		},
+		{
+			name:      "combined filters",
+			filter:    storage.And(prefix, fifties),
+			page:      2,
+			size:      4,
+			want:      []int{250, 300, 350, 400},
+			wantTotal: 12,
+		},
+		{
+			name:      "page out of range",
+			filter:    storage.And(even, fifties),
+			page:      4,
+			size:      4,
+			want:      []int{},
+			wantTotal: 12,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, total, err := s.Find(storage.Query[*storagetest.Data]{Filter: tt.filter}, tt.page, tt.size)
+			if err != nil {
+				t.Fatal("find error", err)
+			}
+			if ids := storagetest.IDs(data); !reflect.DeepEqual(ids, tt.want) {
+				t.Fatalf("data should be %v, but got %v", tt.want, ids)
+			}
+			if total != tt.wantTotal {
+				t.Fatalf("total should be %v, but got %v", tt.wantTotal, total)
+			}
+		})
+	}
+
+	q := storage.Query[*storagetest.Data]{Filter: storage.And(even, fifties)}
+	data, total, err := s.FindAfter(q, &storagetest.Data{ID: 500}, 10)
+	if err != nil {
+		t.Fatal("find error", err)
+	}
+	if ids := storagetest.IDs(data); !reflect.DeepEqual(ids, []int{550, 600}) {
+		t.Fatalf("data should be %v, but got %v", []int{550, 600}, ids)
+	}
+	if total != 12 {
+		t.Fatalf("total should be %v, but got %v", 12, total)
+	}
+}
+
+func TestFindSorted(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data]())
+	names := []string{"c", "a", "d", "b", "a", "c"}
+	for _, name := range names {
+		if _, err := s.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	storage.AddIndex(s, "name", func(d *storagetest.Data) string { return d.Name })
+	// the index is maintained since it's added
+	if _, err := s.Insert(&storagetest.Data{Name: "b"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	if err := s.Update(3, &storagetest.Data{ID: 3, Name: "a"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if err := s.Delete(1); err != nil {
+		t.Fatal("delete error", err)
+	}
+	notB := func(d *storagetest.Data) bool { return d.Name != "b" }
+
+	testcases := []struct {
+		name      string
+		query     storage.Query[*storagetest.Data]
+		page      int
+		size      int
+		want      []int
+		wantTotal int
+	}{
+		{
+			name:      "ascending",
+			query:     storage.Query[*storagetest.Data]{SortBy: "name"},
+			page:      1,
+			size:      10,
+			want:      []int{2, 3, 5, 4, 7, 6},
+			wantTotal: 6,
+		},
+		{
+			name:      "descending",
+			query:     storage.Query[*storagetest.Data]{SortBy: "name", Desc: true},
+			page:      1,
+			size:      4,
+			want:      []int{6, 7, 4, 5},
+			wantTotal: 6,
+		},
+		{
+			name:      "filtered second page",
+			query:     storage.Query[*storagetest.Data]{SortBy: "name", Filter: notB},
+			page:      2,
+			size:      3,
+			want:      []int{6},
+			wantTotal: 4,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, total, err := s.Find(tt.query, tt.page, tt.size)
+			if err != nil {
+				t.Fatal("find error", err)
+			}
+			if ids := storagetest.IDs(data); !reflect.DeepEqual(ids, tt.want) {
+				t.Fatalf("data should be %v, but got %v", tt.want, ids)
+			}
+			if total != tt.wantTotal {
+				t.Fatalf("total should be %v, but got %v", tt.wantTotal, total)
+			}
+		})
+	}
+
+	// continue after a deleted data by its key
+	data, _, err := s.FindAfter(storage.Query[*storagetest.Data]{SortBy: "name"}, &storagetest.Data{ID: 1, Name: "c"}, 10)
+	if err != nil {
+		t.Fatal("find error", err)
+	}
+	if ids := storagetest.IDs(data); !reflect.DeepEqual(ids, []int{6}) {
+		t.Fatalf("data should be %v, but got %v", []int{6}, ids)
+	}
+	data, _, err = s.FindAfter(storage.Query[*storagetest.Data]{SortBy: "name", Desc: true}, &storagetest.Data{ID: 4, Name: "b"}, 10)
+	if err != nil {
+		t.Fatal("find error", err)
+	}
+	if ids := storagetest.IDs(data); !reflect.DeepEqual(ids, []int{5, 3, 2}) {
+		t.Fatalf("data should be %v, but got %v", []int{5, 3, 2}, ids)
+	}
+
+	if _, _, err := s.Find(storage.Query[*storagetest.Data]{SortBy: "size"}, 1, 10); !errors.Is(err, storage.ErrIndexNotFound) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrIndexNotFound, err)
+	}
+}
+
+func TestFindWhere(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data]())
+	names := []string{"ab", "b", "abc", "a", "ba", "abd", "b"}
+	for _, name := range names {
+		if _, err := s.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	storage.AddIndex(s, "name", func(d *storagetest.Data) string { return d.Name })
+	storage.AddIndex(s, "length", func(d *storagetest.Data) int { return len(d.Name) })
+	notABC := func(d *storagetest.Data) bool { return d.Name != "abc" }
+
+	testcases := []struct {
+		name      string
+		query     storage.Query[*storagetest.Data]
+		want      []int
+		wantTotal int
+	}{
+		{
+			name:      "equal",
+			query:     storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Eq("name", "b")}},
+			want:      []int{2, 7},
+			wantTotal: 2,
+		},
+		{
+			name:      "prefix in the order of id",
+			query:     storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Prefix("name", "ab")}},
+			want:      []int{1, 3, 6},
+			wantTotal: 3,
+		},
+		{
+			name:      "prefix sorted by itself",
+			query:     storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Prefix("name", "ab")}, SortBy: "name", Desc: true},
+			want:      []int{6, 3, 1},
+			wantTotal: 3,
+		},
+		{
+			name: "range sorted by another index",
+			query: storage.Query[*storagetest.Data]{
+				Where:  []storage.Cond{storage.Between("name", "ab", "b")},
+				SortBy: "length",
+			},
+			want:      []int{1, 3, 6},
+			wantTotal: 3,
+		},
+		{
+			name: "multiple conditions with filter",
+			query: storage.Query[*storagetest.Data]{
+				Where:  []storage.Cond{storage.Prefix("name", "a"), storage.Eq("length", 3)},
+				Filter: notABC,
+			},
+			want:      []int{6},
+			wantTotal: 1,
+		},
+		{
+			name: "multiple conditions",
+			query: storage.Query[*storagetest.Data]{
+				Where: []storage.Cond{storage.Between("length", 2, nil), storage.Between("name", nil, "b")},
+			},
+			want:      []int{1, 3, 6},
+			wantTotal: 3,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, total, err := s.Find(tt.query, 1, 10)
+			if err != nil {
+				t.Fatal("find error", err)
+			}
+			if ids := storagetest.IDs(data); !reflect.DeepEqual(ids, tt.want) {
+				t.Fatalf("data should be %v, but got %v", tt.want, ids)
+			}
+			if total != tt.wantTotal {
+				t.Fatalf("total should be %v, but got %v", tt.wantTotal, total)
+			}
+			// walk by one data
+			var ids []int
+			data, _, err = s.Find(tt.query, 1, 1)
+			for len(data) > 0 && err == nil {
+				ids = append(ids, data[0].ID)
+				data, total, err = s.FindAfter(tt.query, data[0], 1)
+			}
+			if err != nil {
+				t.Fatal("find error", err)
+			}
+			if !reflect.DeepEqual(ids, tt.want) || total != tt.wantTotal {
+				t.Fatalf("data after should be %v of %v, but got %v of %v", tt.want, tt.wantTotal, ids, total)
+			}
+		})
+	}
+
+	// the index is maintained on changes
+	if err := s.Update(2, &storagetest.Data{ID: 2, Name: "abe"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if err := s.Delete(1); err != nil {
+		t.Fatal("delete error", err)
+	}
+	data, total, err := s.Find(storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Prefix("name", "ab")}}, 1, 10)
+	if err != nil {
+		t.Fatal("find error", err)
+	}
+	if ids := storagetest.IDs(data); !reflect.DeepEqual(ids, []int{2, 3, 6}) || total != 3 {
+		t.Fatalf("data should be %v of %v, but got %v of %v", []int{2, 3, 6}, 3, ids, total)
+	}
+
+	_, _, err = s.Find(storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Eq("length", "3")}}, 1, 10)
+	if !errors.Is(err, storage.ErrIndexKeyType) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrIndexKeyType, err)
+	}
+}
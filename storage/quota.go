@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is matched by the QuotaError with errors.Is
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Quota limits the capacity of a Storage, a zero field means no limit
+type Quota struct {
+	// MaxItems is the max number of data
+	MaxItems int
+	// MaxBytes is the approximate budget of bytes of data, see Sizer
+	MaxBytes int64
+}
+
+// Sizer is implemented by the Entity which reports its approximate size in bytes,
+// the size of json encoding is used for the Entity which does not implement it
+type Sizer interface {
+	Size() int
+}
+
+// Quota resources of QuotaError
+const (
+	QuotaItems = "items"
+	QuotaBytes = "bytes"
+)
+
+// QuotaError is returned if an insert or update would exceed the Quota of the Storage
+type QuotaError struct {
+	// Resource is QuotaItems or QuotaBytes
+	Resource string
+	Limit    int64
+	Used     int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %d of %d %s in use", e.Used, e.Limit, e.Resource)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+func sizeOf[T Entity](data T) int64 {
+	if s, ok := any(data).(Sizer); ok {
+		return int64(s.Size())
+	}
+	b, _ := json.Marshal(data)
+	return int64(len(b))
+}
+
+// Option is an option form to make configuration with Storage
+type Option func(*config)
+
+type config struct {
+	quota Quota
+}
+
+// WithQuota sets the capacity of the Storage
+func WithQuota(q Quota) Option {
+	return func(c *config) {
+		c.quota = q
+	}
+}
+
+// initBytes sums the size of the data in the engine, it's only needed with a byte budget.
+// The data are scanned in batches, so they're never all loaded at once
+func (s *Storage[T]) initBytes() {
+	if s.quota.MaxBytes <= 0 {
+		return
+	}
+	s.walk(0, func(data T) bool {
+		s.bytes += sizeOf(data)
+		return true
+	})
+}
+
+// reserve checks the quota for adding an item of size bytes, or for replacing an item of
+// the old size if replace. It reads nothing without a Quota, since the changes without it
+// may hold the lock shared
+func (s *Storage[T]) reserve(size, old int64, replace bool) error {
+	if s.quota == (Quota{}) {
+		return nil
+	}
+	return s.quota.check(s.engine.Count(), s.bytes, size, old, replace)
+}
+
+// addBytes adds delta to the counted bytes, they're only counted with a byte budget,
+// which holds the lock exclusively for the changes
+func (s *Storage[T]) addBytes(delta int64) {
+	if s.quota.MaxBytes > 0 {
+		s.bytes += delta
+	}
+}
+
+// check checks the quota with the used items and bytes for adding an item of size bytes,
+// or for replacing an item of the old size if replace
+func (q Quota) check(items int, bytes, size, old int64, replace bool) error {
+	if !replace && q.MaxItems > 0 && items >= q.MaxItems {
+		return &QuotaError{Resource: QuotaItems, Limit: int64(q.MaxItems), Used: int64(items)}
+	}
+	if q.MaxBytes > 0 && size > old && bytes+size-old > q.MaxBytes {
+		return &QuotaError{Resource: QuotaBytes, Limit: q.MaxBytes, Used: bytes}
+	}
+	return nil
+}
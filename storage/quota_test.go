@@ -0,0 +1,103 @@
+package storage_test
+
+import (
+	"errors"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestQuotaItems(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data](), storage.WithQuota(storage.Quota{MaxItems: 2}))
+	for i := 0; i < 2; i++ {
+		if _, err := s.Insert(&storagetest.Data{Name: "a"}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+
+	_, err := s.Insert(&storagetest.Data{Name: "a"})
+	var qe *storage.QuotaError
+	if !errors.As(err, &qe) || !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrQuotaExceeded, err)
+	}
+	if qe.Resource != storage.QuotaItems || qe.Limit != 2 || qe.Used != 2 {
+		t.Fatalf("unexpected quota error %+v", qe)
+	}
+
+	// updating does not take more items
+	if err := s.Update(1, &storagetest.Data{ID: 1, Name: "b"}); err != nil {
+		t.Fatal("update error", err)
+	}
+
+	// the deleted item frees the quota
+	if err := s.Delete(1); err != nil {
+		t.Fatal("delete error", err)
+	}
+	if _, err := s.Insert(&storagetest.Data{Name: "a"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+}
+
+func TestQuotaBytes(t *testing.T) {
+	engine := skiplists.New[*storagetest.Data]()
+	if _, err := engine.Insert(&storagetest.Data{Name: "1234"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	// the existing data is counted
+	s := storage.New(engine, storage.WithQuota(storage.Quota{MaxBytes: 10}))
+
+	if _, err := s.Insert(&storagetest.Data{Name: "1234567"}); !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrQuotaExceeded, err)
+	}
+	id, err := s.Insert(&storagetest.Data{Name: "123456"})
+	if err != nil {
+		t.Fatal("insert error", err)
+	}
+
+	// growing data is limited, shrinking data is always allowed
+	if err := s.Update(id, &storagetest.Data{ID: id, Name: "1234567"}); !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrQuotaExceeded, err)
+	}
+	if err := s.Update(id, &storagetest.Data{ID: id, Name: "12"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if _, err := s.Insert(&storagetest.Data{Name: "1234"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+
+	if err := s.Delete(1); err != nil {
+		t.Fatal("delete error", err)
+	}
+	if _, err := s.Insert(&storagetest.Data{Name: "1234"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+}
+
+// scanEngine is the Enginer which only allows to read all the data by Scan
+type scanEngine[T storage.Entity] struct {
+	storage.Enginer[T]
+}
+
+func (scanEngine[T]) Range(i, j int) []T {
+	panic("all the data should be read by scan")
+}
+
+func TestQuotaBytesOfExisting(t *testing.T) {
+	engine := skiplists.New[*storagetest.Data]()
+	// more data than a batch of scan
+	for i := 0; i < 600; i++ {
+		if _, err := engine.Insert(&storagetest.Data{Name: "a"}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	s := storage.New[*storagetest.Data](scanEngine[*storagetest.Data]{engine}, storage.WithQuota(storage.Quota{MaxBytes: 601}))
+
+	if _, err := s.Insert(&storagetest.Data{Name: "ab"}); !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrQuotaExceeded, err)
+	}
+	if _, err := s.Insert(&storagetest.Data{Name: "a"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+}
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReferenced is returned by Reference.Delete if the data is still referenced and the
+// deletion doesn't cascade, and by the deletes of the data of a Tree with children
+var ErrReferenced = errors.New("data is referenced")
+
+// ErrDanglingReference is returned by the inserts and updates of the data referencing the
+// data which does not exist
+var ErrDanglingReference = errors.New("referenced data is not exist")
+
+// ErrCycle is returned by the inserts and updates of the data of a Tree which would be
+// its own ancestor
+var ErrCycle = errors.New("reference makes a cycle")
+
+// Reference is a reference from the data of a Storage to the data of another one by id,
+// like a foreign key, it's added by AddReference
+type Reference[T, R Entity] struct {
+	name string
+	from *Storage[T]
+	to   *Storage[R]
+	key  func(data T) int
+	// self is true if the data reference the data of the same Storage, see AddTree
+	self bool
+}
+
+// AddReference adds the reference by name from the data of s to the data of to, key
+// returns the id of the referenced data, and 0 references nothing. The data of s are
+// indexed by key with the name as AddIndex, so they can be queried by Eq(name, id).
+//
+// The inserts and updates of s fail with ErrDanglingReference if the referenced data
+// does not exist, and the referenced data should be deleted by Reference.Delete, so no
+// data is left referencing the deleted. The lock of s is held before the lock of to, so
+// the changes of to must not wait for s. A reference of s to itself is added by AddTree
+func AddReference[T, R Entity](s *Storage[T], name string, to *Storage[R], key func(data T) int) *Reference[T, R] {
+	AddIndex(s, name, key)
+	r := &Reference[T, R]{name: name, from: s, to: to, key: key, self: any(s) == any(to)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs = append(s.refs, r.check)
+	if r.self {
+		s.inbound = append(s.inbound, r.referenced)
+		// the parents are read by the changes of the data with parent and the deletes
+		s.reads = append(s.reads, func(data *T) bool { return data == nil || key(*data) != 0 })
+	}
+	return r
+}
+
+// check returns ErrDanglingReference if data references the data which does not exist,
+// and ErrCycle if data would be its own ancestor by a self reference. get reads the data
+// of from as seen by the change, the caller must hold the lock of from
+func (r *Reference[T, R]) check(data T, get func(id int) (T, bool)) error {
+	id := r.key(data)
+	if id == 0 {
+		return nil
+	}
+	if !r.self {
+		if _, err := r.to.Get(id); err != nil {
+			return fmt.Errorf("%w: %s %d", ErrDanglingReference, r.name, id)
+		}
+		return nil
+	}
+	// the ancestors never make a cycle, so there are fewer of them than the data
+	for n := r.from.engine.Count(); id != 0 && n >= 0; n-- {
+		if id == data.GetID() {
+			return fmt.Errorf("%w: %s %d", ErrCycle, r.name, r.key(data))
+		}
+		parent, ok := get(id)
+		if !ok && id == r.key(data) {
+			return fmt.Errorf("%w: %s %d", ErrDanglingReference, r.name, id)
+		}
+		if !ok {
+			break
+		}
+		id = r.key(parent)
+	}
+	return nil
+}
+
+// referenced returns ErrReferenced if any data of from references the data of id by a
+// self reference, get reads the data as seen by the change, and pending are the data
+// changed by it which are not indexed yet. The caller must hold the lock of from
+func (r *Reference[T, R]) referenced(id int, get func(id int) (T, bool), pending []T) error {
+	found := false
+	c := Eq(r.name, id)
+	r.from.indexes[r.name].walk(&c, nil, false, func(ref int) bool {
+		data, ok := get(ref)
+		found = ok && r.key(data) == id
+		return !found
+	})
+	for _, data := range pending {
+		found = found || r.key(data) == id
+	}
+	if found {
+		return fmt.Errorf("%w: %s %d", ErrReferenced, r.name, id)
+	}
+	return nil
+}
+
+// Count returns the number of the data referencing the data of id
+func (r *Reference[T, R]) Count(id int) int {
+	s := r.from
+	s.rlock()
+	defer s.runlock()
+	c := Eq(r.name, id)
+	return s.indexes[r.name].count(&c)
+}
+
+// Delete deletes the referenced data of id only if match reports true for it, a nil
+// match matches any data, and it fails as Storage.CompareAndDelete. It returns
+// ErrReferenced if the data is referenced, unless cascade, then the data referencing it
+// are deleted with it, in a transaction if s is a Transactioner so they're deleted all or
+// none. The cascade of a Tree deletes the whole subtree. It returns the number of the
+// deleted data referencing it
+func (r *Reference[T, R]) Delete(id int, match func(current R) bool, cascade bool) (int, error) {
+	defer r.lock()()
+	return r.delete(id, match, cascade)
+}
+
+// delete is Delete, the caller must hold the locks
+func (r *Reference[T, R]) delete(id int, match func(current R) bool, cascade bool) (int, error) {
+	if err := r.match(id, match); err != nil {
+		return 0, err
+	}
+	refs := r.referencing(id, true)
+	if len(refs) > 0 && !cascade {
+		return 0, ErrReferenced
+	}
+	if len(refs) == 0 {
+		return 0, r.to.compareAndDelete(id, nil)
+	}
+
+	// the deleted data may only be referenced by each other, they're deleted regardless
+	// of the order then
+	s := r.from
+	deleted := make(map[int]bool, len(refs))
+	for _, ref := range refs {
+		deleted[ref] = true
+	}
+	get := func(id int) (T, bool) {
+		if deleted[id] {
+			var zero T
+			return zero, false
+		}
+		return s.engine.Get(id)
+	}
+	for _, ref := range refs {
+		if err := s.checkReferenced(ref, get, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := s.begin()
+	if err != nil && !errors.Is(err, ErrTxNotSupported) {
+		return 0, err
+	}
+	for _, ref := range refs {
+		if tx != nil {
+			tx.changed[ref] = struct{}{}
+		}
+		if err = s.remove(ref, nil); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = r.deleteReferenced(tx, id)
+	}
+	if err = tx.end(err); err != nil {
+		return 0, err
+	}
+	return len(refs), nil
+}
+
+// Orphan deletes the referenced data of id only if match reports true for it as Delete,
+// and keeps the data referencing it, they're updated with unset, which returns the
+// updated data referencing nothing. The changes are made in a transaction if s is a
+// Transactioner. It returns the number of the updated data
+func (r *Reference[T, R]) Orphan(id int, match func(current R) bool, unset func(data T) T) (int, error) {
+	defer r.lock()()
+	return r.orphan(id, match, unset)
+}
+
+// orphan is Orphan, the caller must hold the locks
+func (r *Reference[T, R]) orphan(id int, match func(current R) bool, unset func(data T) T) (int, error) {
+	if err := r.match(id, match); err != nil {
+		return 0, err
+	}
+	s := r.from
+	refs := r.referencing(id, false)
+	tx, err := s.begin()
+	if err != nil && !errors.Is(err, ErrTxNotSupported) {
+		return 0, err
+	}
+	for _, ref := range refs {
+		data, ok := s.engine.Get(ref)
+		if !ok {
+			err = ErrNotFound
+			break
+		}
+		if tx != nil {
+			err = tx.Update(ref, unset(data))
+		} else {
+			err = s.compareAndSwap(ref, nil, unset(data))
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = r.deleteReferenced(tx, id)
+	}
+	if err = tx.end(err); err != nil {
+		return 0, err
+	}
+	return len(refs), nil
+}
+
+// lock locks from and to exclusively in order, and returns the unlock, the Storage of a
+// self reference is locked once
+func (r *Reference[T, R]) lock() func() {
+	r.from.mu.Lock()
+	if r.self {
+		return r.from.mu.Unlock
+	}
+	r.to.mu.Lock()
+	return func() {
+		r.to.mu.Unlock()
+		r.from.mu.Unlock()
+	}
+}
+
+// match returns ErrNotFound if the referenced data of id does not exist, and ErrConflict
+// if match reports false for it, the caller must hold the locks
+func (r *Reference[T, R]) match(id int, match func(current R) bool) error {
+	current, ok := r.to.engine.Get(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if match != nil && !match(current) {
+		return ErrConflict
+	}
+	return nil
+}
+
+// referencing returns the ids of the data referencing the data of id, and the data under
+// them for a Tree if all, parents before their children. The caller must hold the lock
+// of from
+func (r *Reference[T, R]) referencing(id int, all bool) []int {
+	var ids []int
+	idx := r.from.indexes[r.name]
+	seen := make(map[int]bool)
+	for next := []int{id}; len(next) > 0; next = next[1:] {
+		c := Eq(r.name, next[0])
+		idx.walk(&c, nil, false, func(ref int) bool {
+			if r.self && seen[ref] {
+				return true
+			}
+			seen[ref] = true
+			ids = append(ids, ref)
+			if r.self && all {
+				next = append(next, ref)
+			}
+			return true
+		})
+	}
+	return ids
+}
+
+// deleteReferenced deletes the referenced data of id after the data referencing it were
+// deleted or updated, tx is the transaction of the changes of from
+func (r *Reference[T, R]) deleteReferenced(tx *Tx[T], id int) error {
+	if r.self && tx != nil {
+		tx.changed[id] = struct{}{}
+	}
+	return r.to.compareAndDelete(id, nil)
+}
+
+// Tree is the reference of the data of a Storage to their parents in the same Storage,
+// it's added by AddTree
+type Tree[T Entity] struct {
+	*Reference[T, T]
+}
+
+// AddTree adds the reference by name of the data of s to their parents in s as
+// AddReference, parent returns the id of the parent, and 0 is a root. The inserts and
+// updates of s also fail with ErrCycle if the data would be its own ancestor, and the
+// deletes of s fail with ErrReferenced if the data has children, they're deleted with
+// their parents by Tree.Delete, or kept as roots by Tree.Orphan. The changes of the data
+// with parent and the deletes hold the lock of s exclusively since they read the other
+// data, the other changes may still run in parallel, see ThreadSafe
+func AddTree[T Entity](s *Storage[T], name string, parent func(data T) int) *Tree[T] {
+	return &Tree[T]{AddReference(s, name, s, parent)}
+}
+
+// Subtree returns the data of id and all the data under it, parents before their
+// children. It returns ErrNotFound if the data does not exist
+func (t *Tree[T]) Subtree(id int) ([]T, error) {
+	s := t.from
+	s.rlock()
+	defer s.runlock()
+	root, ok := s.engine.Get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := []T{root}
+	for _, ref := range t.referencing(id, true) {
+		if data, ok := s.engine.Get(ref); ok {
+			result = append(result, data)
+		}
+	}
+	return result, nil
+}
+
+// Batch is Storage.Batch with the deletes of the data with children as Delete if cascade,
+// or as Orphan with unset if unset is not nil, otherwise they fail with ErrReferenced.
+// The children of an atomic batch are read before it's applied, and they're changed only
+// if they're kept under the deleted data by the operations before, the delete fails with
+// ErrConflict otherwise. The children kept as roots must not be changed by them either
+func (t *Tree[T]) Batch(ops []BatchOp[T], atomic, cascade bool, unset func(data T) T) []error {
+	defer t.lock()()
+	s := t.from
+	if !cascade && unset == nil {
+		return s.batch(ops, atomic)
+	}
+	errs := make([]error, len(ops))
+	if !atomic {
+		for i, op := range ops {
+			switch {
+			case op.Kind != OpDelete:
+				errs[i] = s.apply(op)
+			case cascade:
+				_, errs[i] = t.delete(op.ID, op.Match, true)
+			default:
+				_, errs[i] = t.orphan(op.ID, op.Match, unset)
+			}
+		}
+		return errs
+	}
+
+	// every operation is expanded into the changes of the children and itself, ends are
+	// the ends of them in expanded
+	var expanded []BatchOp[T]
+	ends := make([]int, len(ops))
+	for i, op := range ops {
+		if op.Kind == OpDelete {
+			expanded = append(expanded, t.childOps(op.ID, cascade, unset)...)
+		}
+		expanded = append(expanded, op)
+		ends[i] = len(expanded)
+	}
+	start := 0
+	results := s.batch(expanded, true)
+	for i, end := range ends {
+		// an operation fails with the first error of its changes
+		for _, err := range results[start:end] {
+			if err != nil && (errs[i] == nil || errors.Is(errs[i], ErrBatchAborted)) {
+				errs[i] = err
+			}
+		}
+		start = end
+	}
+	return errs
+}
+
+// childOps returns the operations of Batch which delete the subtree under the data of id
+// if cascade, or update its children by unset, the children first. The caller must hold
+// the lock
+func (t *Tree[T]) childOps(id int, cascade bool, unset func(data T) T) []BatchOp[T] {
+	s := t.from
+	refs := t.referencing(id, cascade)
+	ops := make([]BatchOp[T], 0, len(refs))
+	for i := len(refs) - 1; i >= 0; i-- {
+		data, ok := s.engine.Get(refs[i])
+		if !ok {
+			continue
+		}
+		parent, version := t.key(data), versionOf(data)
+		op := BatchOp[T]{Kind: OpDelete, ID: refs[i], Match: func(current T) bool {
+			return t.key(current) == parent
+		}}
+		if !cascade {
+			op = BatchOp[T]{Kind: OpUpdate, ID: refs[i], Data: unset(data), Match: func(current T) bool {
+				return t.key(current) == parent && versionOf(current) == version
+			}}
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
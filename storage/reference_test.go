@@ -0,0 +1,470 @@
+package storage_test
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/cskiplists"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestReference(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		engine storage.Enginer[*storagetest.Data]
+	}{
+		{name: "transactioner", engine: skiplists.New[*storagetest.Data]()},
+		{name: "no transaction", engine: plainEngine[*storagetest.Data]{skiplists.New[*storagetest.Data]()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testReference(t, tt.engine)
+		})
+	}
+}
+
+func testReference(t *testing.T, engine storage.Enginer[*storagetest.Data]) {
+	parents := storage.New(skiplists.New[*storagetest.Data]())
+	children := storage.New(engine)
+	ref := storage.AddReference(children, "parent", parents, func(d *storagetest.Data) int { return d.Parent })
+	for _, name := range []string{"p1", "p2"} {
+		if _, err := parents.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+
+	changes := []struct {
+		name    string
+		change  func() error
+		wantErr error
+	}{
+		{
+			name:   "insert without reference",
+			change: func() error { _, err := children.Insert(&storagetest.Data{}); return err },
+		},
+		{
+			name:   "insert referencing",
+			change: func() error { _, err := children.Insert(&storagetest.Data{Parent: 1}); return err },
+		},
+		{
+			name:    "insert dangling",
+			change:  func() error { _, err := children.Insert(&storagetest.Data{Parent: 3}); return err },
+			wantErr: storage.ErrDanglingReference,
+		},
+		{
+			name:   "move",
+			change: func() error { return children.Update(1, &storagetest.Data{ID: 1, Parent: 2}) },
+		},
+		{
+			name:    "move to dangling",
+			change:  func() error { return children.Update(1, &storagetest.Data{ID: 1, Parent: 3}) },
+			wantErr: storage.ErrDanglingReference,
+		},
+		{
+			name: "atomic batch with dangling",
+			change: func() error {
+				errs := children.Batch([]storage.BatchOp[*storagetest.Data]{
+					{Kind: storage.OpInsert, Data: &storagetest.Data{Parent: 1}},
+					{Kind: storage.OpInsert, Data: &storagetest.Data{Parent: 3}},
+				}, true)
+				return errs[1]
+			},
+			wantErr: storage.ErrDanglingReference,
+		},
+		{
+			name: "batch",
+			change: func() error {
+				errs := children.Batch([]storage.BatchOp[*storagetest.Data]{
+					{Kind: storage.OpInsert, Data: &storagetest.Data{Parent: 1}},
+				}, false)
+				return errs[0]
+			},
+		},
+	}
+	for _, tt := range changes {
+		if err := tt.change(); !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: error should be %v, but got %v", tt.name, tt.wantErr, err)
+		}
+	}
+	// 1 is in p2, 2 and 3 are in p1, the failed atomic batch inserted nothing
+	if ref.Count(1) != 2 || ref.Count(2) != 1 {
+		t.Fatalf("counts should be 2 and 1, but got %d and %d", ref.Count(1), ref.Count(2))
+	}
+	data, total, err := children.Find(storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Eq("parent", 1)}}, 1, 10)
+	if err != nil || total != 2 || data[0].ID != 2 || data[1].ID != 3 {
+		t.Fatalf("children of p1 should be 2 and 3, but got %v, %v", data, err)
+	}
+
+	deletes := []struct {
+		name        string
+		id          int
+		match       func(current *storagetest.Data) bool
+		cascade     bool
+		wantErr     error
+		wantDeleted int
+	}{
+		{name: "not found", id: 3, wantErr: storage.ErrNotFound},
+		{name: "referenced", id: 1, wantErr: storage.ErrReferenced},
+		{
+			name:    "unmatched",
+			id:      1,
+			match:   func(current *storagetest.Data) bool { return current.Name == "p2" },
+			cascade: true,
+			wantErr: storage.ErrConflict,
+		},
+		{name: "cascade", id: 1, cascade: true, wantDeleted: 2},
+	}
+	for _, tt := range deletes {
+		deleted, err := ref.Delete(tt.id, tt.match, tt.cascade)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: error should be %v, but got %v", tt.name, tt.wantErr, err)
+		}
+		if deleted != tt.wantDeleted {
+			t.Fatalf("%s: deleted should be %d, but got %d", tt.name, tt.wantDeleted, deleted)
+		}
+	}
+	if _, err := parents.Get(1); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("p1 should be deleted, but got %v", err)
+	}
+	if children.Count() != 1 || ref.Count(1) != 0 {
+		t.Fatalf("the children of p1 should be deleted, but got %d children", children.Count())
+	}
+
+	// p2 is deleted after its child leaves
+	if err := children.Update(1, &storagetest.Data{ID: 1}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if deleted, err := ref.Delete(2, nil, false); err != nil || deleted != 0 {
+		t.Fatalf("p2 should be deleted without children, but got %d, %v", deleted, err)
+	}
+}
+
+func TestTree(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		engine storage.Enginer[*storagetest.Data]
+	}{
+		{name: "transactioner", engine: skiplists.New[*storagetest.Data]()},
+		{name: "no transaction", engine: plainEngine[*storagetest.Data]{skiplists.New[*storagetest.Data]()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			testTree(t, tt.engine)
+		})
+	}
+}
+
+func testTree(t *testing.T, engine storage.Enginer[*storagetest.Data]) {
+	s := storage.New(engine)
+	tree := storage.AddTree(s, "parent", func(d *storagetest.Data) int { return d.Parent })
+	// 1 -> 2 -> 4, 1 -> 3, 5
+	for _, parent := range []int{0, 1, 1, 2, 0} {
+		if _, err := s.Insert(&storagetest.Data{Parent: parent}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	subtree := func(id int) []int {
+		data, err := tree.Subtree(id)
+		if err != nil {
+			t.Fatal("subtree error", err)
+		}
+		ids := []int{}
+		for _, d := range data {
+			ids = append(ids, d.ID)
+		}
+		return ids
+	}
+	if ids := subtree(1); !reflect.DeepEqual(ids, []int{1, 2, 3, 4}) {
+		t.Fatalf("subtree of 1 should be [1 2 3 4], but got %v", ids)
+	}
+
+	changes := []struct {
+		name    string
+		change  func() error
+		wantErr error
+	}{
+		{
+			name:    "insert dangling",
+			change:  func() error { _, err := s.Insert(&storagetest.Data{Parent: 9}); return err },
+			wantErr: storage.ErrDanglingReference,
+		},
+		{
+			name:    "parent of itself",
+			change:  func() error { return s.Update(1, &storagetest.Data{ID: 1, Parent: 1}) },
+			wantErr: storage.ErrCycle,
+		},
+		{
+			name:    "under its descendant",
+			change:  func() error { return s.Update(1, &storagetest.Data{ID: 1, Parent: 4}) },
+			wantErr: storage.ErrCycle,
+		},
+		{
+			name: "atomic batch with cycle",
+			change: func() error {
+				errs := s.Batch([]storage.BatchOp[*storagetest.Data]{
+					{Kind: storage.OpUpdate, ID: 5, Data: &storagetest.Data{ID: 5, Parent: 3}},
+					{Kind: storage.OpUpdate, ID: 1, Data: &storagetest.Data{ID: 1, Parent: 5}},
+				}, true)
+				return errs[1]
+			},
+			wantErr: storage.ErrCycle,
+		},
+		{
+			name:    "delete parent",
+			change:  func() error { return s.Delete(2) },
+			wantErr: storage.ErrReferenced,
+		},
+		{
+			name: "atomic batch deleting parent of inserted",
+			change: func() error {
+				errs := s.Batch([]storage.BatchOp[*storagetest.Data]{
+					{Kind: storage.OpInsert, Data: &storagetest.Data{Parent: 5}},
+					{Kind: storage.OpDelete, ID: 5},
+				}, true)
+				return errs[1]
+			},
+			wantErr: storage.ErrReferenced,
+		},
+		{
+			name: "atomic batch deleting parent of moved",
+			change: func() error {
+				errs := s.Batch([]storage.BatchOp[*storagetest.Data]{
+					{Kind: storage.OpUpdate, ID: 3, Data: &storagetest.Data{ID: 3, Parent: 5}},
+					{Kind: storage.OpDelete, ID: 5},
+				}, true)
+				return errs[1]
+			},
+			wantErr: storage.ErrReferenced,
+		},
+		{
+			name: "atomic batch moving children first",
+			change: func() error {
+				errs := s.Batch([]storage.BatchOp[*storagetest.Data]{
+					{Kind: storage.OpInsert, Data: &storagetest.Data{Parent: 5}},
+					{Kind: storage.OpUpdate, ID: 4, Data: &storagetest.Data{ID: 4, Parent: 5}},
+					{Kind: storage.OpDelete, ID: 2},
+				}, true)
+				return errors.Join(errs...)
+			},
+		},
+	}
+	for _, tt := range changes {
+		if err := tt.change(); !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: error should be %v, but got %v", tt.name, tt.wantErr, err)
+		}
+	}
+	// 1 -> 3, 5 -> 4, 5 -> 6
+	if ids := subtree(5); !reflect.DeepEqual(ids, []int{5, 4, 6}) {
+		t.Fatalf("subtree of 5 should be [5 4 6], but got %v", ids)
+	}
+	if _, err := tree.Subtree(2); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("subtree of deleted should be %v, but got %v", storage.ErrNotFound, err)
+	}
+
+	// 3 is kept as a root
+	orphaned, err := tree.Orphan(1, nil, func(d *storagetest.Data) *storagetest.Data { return &storagetest.Data{ID: d.ID} })
+	if err != nil || orphaned != 1 {
+		t.Fatalf("1 should be deleted with 1 orphan, but got %d, %v", orphaned, err)
+	}
+	if ids := subtree(3); !reflect.DeepEqual(ids, []int{3}) {
+		t.Fatalf("subtree of 3 should be [3], but got %v", ids)
+	}
+	// 7 is under 4 under 5
+	if _, err := s.Insert(&storagetest.Data{Parent: 4}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	if _, err := tree.Delete(5, nil, false); !errors.Is(err, storage.ErrReferenced) {
+		t.Fatalf("delete without cascade should be %v, but got %v", storage.ErrReferenced, err)
+	}
+	deleted, err := tree.Delete(5, nil, true)
+	if err != nil || deleted != 3 {
+		t.Fatalf("5 should be deleted with 3 descendants, but got %d, %v", deleted, err)
+	}
+	if s.Count() != 1 {
+		t.Fatalf("only 3 should be left, but got %d", s.Count())
+	}
+}
+
+func TestTreeBatch(t *testing.T) {
+	unset := func(d *storagetest.Data) *storagetest.Data { return &storagetest.Data{ID: d.ID} }
+	never := func(*storagetest.Data) bool { return false }
+	// 1 -> 2 -> 4, 1 -> 3, 5
+	unchanged := map[int]int{1: 0, 2: 1, 3: 1, 4: 2, 5: 0}
+	testcases := []struct {
+		name     string
+		ops      []storage.BatchOp[*storagetest.Data]
+		atomic   bool
+		cascade  bool
+		unset    func(d *storagetest.Data) *storagetest.Data
+		wantErrs []error
+		// want are the parents of the data left by id
+		want map[int]int
+	}{
+		{
+			name:     "reject",
+			ops:      []storage.BatchOp[*storagetest.Data]{{Kind: storage.OpDelete, ID: 2}},
+			wantErrs: []error{storage.ErrReferenced},
+			want:     unchanged,
+		},
+		{
+			name: "cascade",
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpInsert, Data: &storagetest.Data{Parent: 5}},
+				{Kind: storage.OpDelete, ID: 1},
+			},
+			cascade:  true,
+			wantErrs: []error{nil, nil},
+			want:     map[int]int{5: 0, 6: 5},
+		},
+		{
+			name: "atomic cascade",
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpInsert, Data: &storagetest.Data{Parent: 5}},
+				{Kind: storage.OpDelete, ID: 1},
+			},
+			atomic:   true,
+			cascade:  true,
+			wantErrs: []error{nil, nil},
+			want:     map[int]int{5: 0, 6: 5},
+		},
+		{
+			name:     "orphan",
+			ops:      []storage.BatchOp[*storagetest.Data]{{Kind: storage.OpDelete, ID: 1}},
+			unset:    unset,
+			wantErrs: []error{nil},
+			want:     map[int]int{2: 0, 3: 0, 4: 2, 5: 0},
+		},
+		{
+			name:     "atomic orphan",
+			ops:      []storage.BatchOp[*storagetest.Data]{{Kind: storage.OpDelete, ID: 1}},
+			atomic:   true,
+			unset:    unset,
+			wantErrs: []error{nil},
+			want:     map[int]int{2: 0, 3: 0, 4: 2, 5: 0},
+		},
+		{
+			name: "cascade after moving a child out",
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpUpdate, ID: 3, Data: &storagetest.Data{ID: 3, Parent: 5}},
+				{Kind: storage.OpDelete, ID: 1},
+			},
+			cascade:  true,
+			wantErrs: []error{nil, nil},
+			want:     map[int]int{3: 5, 5: 0},
+		},
+		{
+			name: "atomic cascade after moving a child out",
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpUpdate, ID: 3, Data: &storagetest.Data{ID: 3, Parent: 5}},
+				{Kind: storage.OpDelete, ID: 1},
+			},
+			atomic:   true,
+			cascade:  true,
+			wantErrs: []error{storage.ErrBatchAborted, storage.ErrConflict},
+			want:     unchanged,
+		},
+		{
+			name: "atomic cascade after moving a child in",
+			ops: []storage.BatchOp[*storagetest.Data]{
+				{Kind: storage.OpUpdate, ID: 5, Data: &storagetest.Data{ID: 5, Parent: 4}},
+				{Kind: storage.OpDelete, ID: 1},
+			},
+			atomic:   true,
+			cascade:  true,
+			wantErrs: []error{storage.ErrBatchAborted, storage.ErrReferenced},
+			want:     unchanged,
+		},
+		{
+			name:     "atomic cascade unmatched",
+			ops:      []storage.BatchOp[*storagetest.Data]{{Kind: storage.OpDelete, ID: 1, Match: never}},
+			atomic:   true,
+			cascade:  true,
+			wantErrs: []error{storage.ErrConflict},
+			want:     unchanged,
+		},
+	}
+	for _, tt := range testcases {
+		for _, engine := range []struct {
+			name   string
+			engine storage.Enginer[*storagetest.Data]
+		}{
+			{name: "transactioner", engine: skiplists.New[*storagetest.Data]()},
+			{name: "no transaction", engine: plainEngine[*storagetest.Data]{skiplists.New[*storagetest.Data]()}},
+		} {
+			t.Run(tt.name+"/"+engine.name, func(t *testing.T) {
+				s := storage.New(engine.engine)
+				tree := storage.AddTree(s, "parent", func(d *storagetest.Data) int { return d.Parent })
+				for _, parent := range []int{0, 1, 1, 2, 0} {
+					if _, err := s.Insert(&storagetest.Data{Parent: parent}); err != nil {
+						t.Fatal("insert error", err)
+					}
+				}
+				errs := tree.Batch(tt.ops, tt.atomic, tt.cascade, tt.unset)
+				for i, err := range errs {
+					if !errors.Is(err, tt.wantErrs[i]) {
+						t.Fatalf("error of op %d should be %v, but got %v", i, tt.wantErrs[i], err)
+					}
+				}
+				parents := map[int]int{}
+				for _, d := range s.Scan(0, 10) {
+					parents[d.ID] = d.Parent
+				}
+				if !reflect.DeepEqual(parents, tt.want) {
+					t.Fatalf("parents should be %v, but got %v", tt.want, parents)
+				}
+			})
+		}
+	}
+}
+
+func TestTreeThreadSafe(t *testing.T) {
+	s := storage.New(cskiplists.New[*storagetest.Data]())
+	storage.AddTree(s, "parent", func(d *storagetest.Data) int { return d.Parent })
+	for i := 0; i < 10; i++ {
+		if _, err := s.Insert(&storagetest.Data{}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+
+	// the moves hold the lock exclusively, so they never make a cycle together, and the
+	// changes of the roots run in parallel with them
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(g)))
+			for i := 0; i < 50; i++ {
+				id, parent := rnd.Intn(10)+1, rnd.Intn(11)
+				if err := s.Update(id, &storagetest.Data{ID: id, Parent: parent}); err != nil && !errors.Is(err, storage.ErrCycle) {
+					t.Error("update error", err)
+				}
+				inserted, err := s.Insert(&storagetest.Data{Parent: rnd.Intn(11)})
+				if err != nil {
+					t.Error("insert error", err)
+				}
+				if err := s.Delete(inserted); err != nil {
+					t.Error("delete error", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for id := 1; id <= 10; id++ {
+		ancestor := id
+		for n := 0; ancestor != 0; n++ {
+			if n > 10 {
+				t.Fatalf("%d should have no cycle", id)
+			}
+			data, err := s.Get(ancestor)
+			if err != nil {
+				t.Fatalf("ancestor %d of %d should exist, but got %v", ancestor, id, err)
+			}
+			ancestor = data.Parent
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Driver is the interface that must be implemented by a storage driver, see Register
+type Driver[T Entity] interface {
+	// Open returns a new Enginer with the driver-specific config string, e.g. "max_nodes=1024"
+	Open(config string) (Enginer[T], error)
+}
+
+var (
+	driversMu sync.RWMutex
+	// drivers are the drivers by name and by data type
+	drivers = make(map[string]map[reflect.Type]any)
+)
+
+// Register makes a storage driver available by the name for the data type T, a name can
+// be registered for several data types. It panics if Register is called twice with the
+// same name and data type or if driver is nil
+func Register[T Entity](name string, driver Driver[T]) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("storage: Register driver is nil")
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if _, dup := drivers[name][typ]; dup {
+		panic(fmt.Sprintf("storage: Register called twice for driver %s of %v", name, typ))
+	}
+	if drivers[name] == nil {
+		drivers[name] = make(map[reflect.Type]any)
+	}
+	drivers[name][typ] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	list := make([]string, 0, len(drivers))
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// OpenEnginer opens the Enginer of the driver by the name with the config string
+func OpenEnginer[T Entity](name, config string) (Enginer[T], error) {
+	driversMu.RLock()
+	types, ok := drivers[name]
+	d := types[reflect.TypeOf((*T)(nil)).Elem()]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("storage: unknown driver %q (forgotten import?)", name)
+	}
+	if d == nil {
+		var zero T
+		return nil, fmt.Errorf("storage: driver %q does not support %T", name, zero)
+	}
+	return d.(Driver[T]).Open(config)
+}
+
+// Open returns the Storage with the Enginer of the driver by the name, see OpenEnginer
+func Open[T Entity](name, config string, opts ...Option) (*Storage[T], error) {
+	engine, err := OpenEnginer[T](name, config)
+	if err != nil {
+		return nil, err
+	}
+	return New(engine, opts...), nil
+}
+
+// Close closes the Enginer if it holds resources, e.g. files
+func (s *Storage[T]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if closer, ok := s.engine.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"glookbs.github.com/storage/storagetest"
+)
+
+// testEngine is a nil Enginer which is enough for testing the registry
+type testEngine struct {
+	Enginer[*storagetest.Data]
+	config string
+}
+
+type testDriver struct{}
+
+func (testDriver) Open(config string) (Enginer[*storagetest.Data], error) {
+	return &testEngine{config: config}, nil
+}
+
+type otherData struct{ storagetest.Data }
+
+type otherDriver struct{}
+
+func (otherDriver) Open(config string) (Enginer[*otherData], error) {
+	return nil, nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register[*storagetest.Data]("test-driver", testDriver{})
+
+	found := false
+	for _, name := range Drivers() {
+		found = found || name == "test-driver"
+	}
+	if !found {
+		t.Fatalf("test-driver should be in %v", Drivers())
+	}
+
+	engine, err := OpenEnginer[*storagetest.Data]("test-driver", "k=v")
+	if err != nil {
+		t.Fatal("open error", err)
+	}
+	if !reflect.DeepEqual(engine, &testEngine{config: "k=v"}) {
+		t.Fatalf("config should be passed to the driver, but got %v", engine)
+	}
+
+	if _, err := Open[*storagetest.Data]("unknown-driver", ""); err == nil {
+		t.Fatal("open unknown driver should be failed")
+	}
+
+	if _, err := Open[*otherData]("test-driver", ""); err == nil {
+		t.Fatal("open driver with unsupported data type should be failed")
+	}
+	// the name is registered for another data type
+	Register[*otherData]("test-driver", otherDriver{})
+	if _, err := OpenEnginer[*otherData]("test-driver", ""); err != nil {
+		t.Fatal("open driver of another data type error", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("register twice should panic")
+		}
+	}()
+	Register[*storagetest.Data]("test-driver", testDriver{})
+}
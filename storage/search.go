@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// prefixWeight is the weight of a term matched by prefix relative to an exact match
+const prefixWeight = 0.5
+
+// SearchResult is a data matched by Search
+type SearchResult[T Entity] struct {
+	Data  T
+	Score float64
+	// Highlights are the matched parts of the text in the order of offset
+	Highlights []Highlight
+}
+
+// Highlight is the byte offsets [Start, End) of a matched part of the text
+type Highlight struct {
+	Start int
+	End   int
+}
+
+// token is a word of the text, the term is the lower case of the word
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits the text into the words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// textIndex is an inverted index of the text of data for full-text search
+type textIndex[T Entity] struct {
+	text func(data T) string
+	// postings is the term frequency of every id by term
+	postings map[string]map[int]int
+	// terms are the terms of postings in order, the terms of a prefix are adjacent
+	terms []string
+	// docs are the tokens of every id
+	docs map[int][]token
+}
+
+// AddTextIndex adds the full-text index by name of the text of data to the storage, it's
+// queried by Search. The existing data are indexed at once, and the index is maintained
+// on every change since then. An index with the same name is replaced
+func AddTextIndex[T Entity](s *Storage[T], name string, text func(data T) string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := &textIndex[T]{
+		text:     text,
+		postings: make(map[string]map[int]int),
+		docs:     make(map[int][]token),
+	}
+	s.walk(0, func(data T) bool {
+		idx.insert(data.GetID(), data)
+		return true
+	})
+	if s.texts == nil {
+		s.texts = make(map[string]*textIndex[T])
+	}
+	s.texts[name] = idx
+}
+
+func (idx *textIndex[T]) insert(id int, data T) {
+	idx.delete(id)
+	tokens := tokenize(idx.text(data))
+	if len(tokens) == 0 {
+		return
+	}
+	idx.docs[id] = tokens
+	for _, tok := range tokens {
+		ids, ok := idx.postings[tok.term]
+		if !ok {
+			ids = make(map[int]int)
+			idx.postings[tok.term] = ids
+			i := sort.SearchStrings(idx.terms, tok.term)
+			idx.terms = append(idx.terms, "")
+			copy(idx.terms[i+1:], idx.terms[i:])
+			idx.terms[i] = tok.term
+		}
+		ids[id]++
+	}
+}
+
+func (idx *textIndex[T]) delete(id int) {
+	for _, tok := range idx.docs[id] {
+		ids := idx.postings[tok.term]
+		if ids[id]--; ids[id] > 0 {
+			continue
+		}
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(idx.postings, tok.term)
+			i := sort.SearchStrings(idx.terms, tok.term)
+			idx.terms = append(idx.terms[:i], idx.terms[i+1:]...)
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// search returns the scores of the ids matching all the query terms, a query term
+// matches the terms starting with it. A score is the sum of tf-idf of the best matched
+// term of every query term, normalized by the number of tokens of the text
+func (idx *textIndex[T]) search(terms []string) map[int]float64 {
+	var scores map[int]float64
+	for i, qt := range terms {
+		matched := make(map[int]float64)
+		for j := sort.SearchStrings(idx.terms, qt); j < len(idx.terms) && strings.HasPrefix(idx.terms[j], qt); j++ {
+			term := idx.terms[j]
+			weight := 1.0
+			if term != qt {
+				weight = prefixWeight * float64(len(qt)) / float64(len(term))
+			}
+			ids := idx.postings[term]
+			idf := math.Log(1 + float64(len(idx.docs))/float64(len(ids)))
+			for id, tf := range ids {
+				matched[id] = max(matched[id], float64(tf)*idf*weight)
+			}
+		}
+		if i == 0 {
+			scores = matched
+			continue
+		}
+		for id := range scores {
+			if score, ok := matched[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	for id := range scores {
+		scores[id] /= math.Sqrt(float64(len(idx.docs[id])))
+	}
+	return scores
+}
+
+// highlights returns the parts of the text of id matched by the query terms
+func (idx *textIndex[T]) highlights(id int, text string, terms []string) []Highlight {
+	var result []Highlight
+	for _, tok := range idx.docs[id] {
+		// the longest query term matched by the token
+		n := 0
+		for _, qt := range terms {
+			if strings.HasPrefix(tok.term, qt) {
+				n = max(n, utf8.RuneCountInString(qt))
+			}
+		}
+		if n == 0 || tok.end > len(text) {
+			continue
+		}
+		// the prefix of n runes in the original text
+		end := tok.start
+		for k := 0; k < n && end < tok.end; k++ {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+		result = append(result, Highlight{Start: tok.start, End: end})
+	}
+	return result
+}
+
+// queryTerms returns the distinct terms of the query
+func queryTerms(q string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, tok := range tokenize(q) {
+		if !seen[tok.term] {
+			seen[tok.term] = true
+			terms = append(terms, tok.term)
+		}
+	}
+	return terms
+}
+
+// Search returns the data matching all the words of q by the text index name with page
+// i and page size j, and the number of all the matched data. The data are ordered by
+// relevance, a word of q matches the words of the text starting with it, and the exact
+// matches and the short texts are more relevant
+func (s *Storage[T]) Search(name, q string, i, j int) ([]SearchResult[T], int, error) {
+	s.rlock()
+	defer s.runlock()
+	idx, ok := s.texts[name]
+	if !ok {
+		return nil, 0, ErrIndexNotFound
+	}
+	terms := queryTerms(q)
+	if len(terms) == 0 {
+		return []SearchResult[T]{}, 0, nil
+	}
+	scores := idx.search(terms)
+	ids := make([]int, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		if scores[ids[a]] != scores[ids[b]] {
+			return scores[ids[a]] > scores[ids[b]]
+		}
+		return ids[a] < ids[b]
+	})
+
+	start := min(max((i-1)*j, 0), len(ids))
+	end := min(start+j, len(ids))
+	result := make([]SearchResult[T], 0, end-start)
+	for _, id := range ids[start:end] {
+		data, ok := s.engine.Get(id)
+		if !ok {
+			continue
+		}
+		result = append(result, SearchResult[T]{
+			Data:       data,
+			Score:      scores[id],
+			Highlights: idx.highlights(id, idx.text(data), terms),
+		})
+	}
+	return result, len(ids), nil
+}
@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestSearch(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data]())
+	names := []string{
+		"Write the API doc",
+		"write tests",
+		"Review the documentation of the writer",
+		"deploy",
+		"Write, write, write!",
+	}
+	for _, name := range names {
+		if _, err := s.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	storage.AddTextIndex(s, "name", func(d *storagetest.Data) string { return d.Name })
+
+	testcases := []struct {
+		name           string
+		q              string
+		want           []int
+		wantHighlights [][]storage.Highlight
+	}{
+		{
+			name: "exact match ranks first",
+			q:    "write",
+			want: []int{5, 2, 1, 3},
+			wantHighlights: [][]storage.Highlight{
+				{{Start: 0, End: 5}, {Start: 7, End: 12}, {Start: 14, End: 19}},
+				{{Start: 0, End: 5}},
+				{{Start: 0, End: 5}},
+				{{Start: 32, End: 37}},
+			},
+		},
+		{
+			name: "all words match by prefix",
+			q:    "DOC wr",
+			want: []int{1, 3},
+			wantHighlights: [][]storage.Highlight{
+				{{Start: 0, End: 2}, {Start: 14, End: 17}},
+				{{Start: 11, End: 14}, {Start: 32, End: 34}},
+			},
+		},
+		{
+			name: "no match",
+			q:    "deploy test",
+			want: []int{},
+		},
+		{
+			name: "no word",
+			q:    "!?",
+			want: []int{},
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			results, total, err := s.Search("name", tt.q, 1, 10)
+			if err != nil {
+				t.Fatal("search error", err)
+			}
+			ids := []int{}
+			var highlights [][]storage.Highlight
+			for _, r := range results {
+				ids = append(ids, r.Data.ID)
+				highlights = append(highlights, r.Highlights)
+			}
+			if !reflect.DeepEqual(ids, tt.want) || total != len(tt.want) {
+				t.Fatalf("result should be %v, but got %v of %v", tt.want, ids, total)
+			}
+			if !reflect.DeepEqual(highlights, tt.wantHighlights) {
+				t.Fatalf("highlights should be %v, but got %v", tt.wantHighlights, highlights)
+			}
+		})
+	}
+
+	// the index is maintained on changes
+	if err := s.Update(4, &storagetest.Data{ID: 4, Name: "write release note"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if err := s.Delete(5); err != nil {
+		t.Fatal("delete error", err)
+	}
+	results, total, err := s.Search("name", "write", 2, 2)
+	if err != nil {
+		t.Fatal("search error", err)
+	}
+	if ids := []int{results[0].Data.ID, results[1].Data.ID}; !reflect.DeepEqual(ids, []int{1, 3}) || total != 4 {
+		t.Fatalf("result should be %v of %v, but got %v of %v", []int{1, 3}, 4, ids, total)
+	}
+	if results, _, _ := s.Search("name", "documentation", 1, 10); len(results) != 1 {
+		t.Fatalf("result should be %v, but got %v", 1, len(results))
+	}
+
+	if _, _, err := s.Search("title", "write", 1, 10); !errors.Is(err, storage.ErrIndexNotFound) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrIndexNotFound, err)
+	}
+}
@@ -5,66 +5,426 @@ import (
 	"sync"
 )
 
+// Entity is the interface for the data stored in the Enginer, the Enginer assigns the id by SetID on insert
+type Entity interface {
+	GetID() int
+	SetID(id int)
+}
+
+// Versioner is implemented by the Entity which keeps the version of its content, the
+// Storage sets the version to 1 on insert and increments it on every update, so a change
+// can be detected by comparing the versions
+type Versioner interface {
+	GetVersion() int
+	SetVersion(version int)
+}
+
 // Enginer is the inteface for the data low-level contronl
-type Enginer interface {
+type Enginer[T Entity] interface {
 	// Insert inserts data into engine, and returns the id of the data if success
-	Insert(data any) (int, error)
+	Insert(data T) (int, error)
+	// Get returns the data with id, return false if data was nonexist
+	Get(id int) (T, bool)
 	// Count returns the number of data
 	Count() int
 	// Range returns data with i and j
-	Range(i, j int) []any
+	Range(i, j int) []T
+	// Scan returns at most n data whose id is greater than after, ordered by id
+	Scan(after, n int) []T
 	// Delete deletes the data with id, return false if data was nonexist
 	Delete(i int) bool
 	// Update updates data if it does exist
-	Update(id int, data any) error
+	Update(id int, data T) error
+	// CompareAndSwap replaces the data of id with data only if match reports true for
+	// the current data, it returns false if the data does not exist or does not match
+	CompareAndSwap(id int, match func(current T) bool, data T) (bool, error)
+}
+
+// ThreadSafe is implemented by the Enginer which is safe for concurrent use, the Storage
+// holds its lock shared for the single changes of such an Enginer if ThreadSafe reports
+// true and there's no Quota, so the changes run in parallel. A Batch or a Tx still holds
+// the lock exclusively
+type ThreadSafe[T Entity] interface {
+	ThreadSafe() bool
+	// CompareAndDelete deletes the data of id only if match reports true for the current
+	// data, it returns false if the data does not exist or does not match
+	CompareAndDelete(id int, match func(current T) bool) bool
+}
+
+// Failer is implemented by the Enginer which stops accepting changes on a failure of its
+// own, e.g. an I/O error, Err returns the error which stopped it. A Delete which returns
+// false is taken as the failure instead of a nonexistent data if Err returns an error
+type Failer interface {
+	Err() error
+}
+
+// Snapshotter is implemented by the Enginer which can dump and restore its whole content
+type Snapshotter[T Entity] interface {
+	// Snapshot returns all the data ordered by id
+	Snapshot() Snapshot[T]
+	// Restore replaces the content with the snapshot
+	Restore(s Snapshot[T]) error
+}
+
+// Snapshot is the point-in-time content of an Enginer
+type Snapshot[T Entity] struct {
+	// MaxID is the largest id ever assigned, ids are never reused after restoring
+	MaxID int
+	Items []T
 }
 
+// ErrNotFound is returned if the data with the id does not exist
+var ErrNotFound = errors.New("data is not exist")
+
+// ErrConflict is returned by CompareAndSwap if the data does not match
+var ErrConflict = errors.New("data was changed")
+
+// ErrTooLarge is returned by the Enginer which can't keep the data for its size
+var ErrTooLarge = errors.New("data is too large")
+
 // New returns storage with injecting the Enginer
-func New(enginer Enginer) *Storage {
-	return &Storage{
+func New[T Entity](enginer Enginer[T], opts ...Option) *Storage[T] {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	s := &Storage[T]{
 		engine: enginer,
+		quota:  c.quota,
+	}
+	if ts, ok := enginer.(ThreadSafe[T]); ok && ts.ThreadSafe() && c.quota == (Quota{}) {
+		s.safe = ts
 	}
+	s.initBytes()
+	return s
 }
 
-// Storage is an object for low-level data engine controling, including thread-safe and error handling
-// TODO: instead of mutex by the data engine, if it exports the locker
-type Storage struct {
+// Storage is an object for low-level data engine controling, including thread-safe, quota and error handling
+type Storage[T Entity] struct {
+	// mu is held exclusively for the changes, or shared if the engine is thread-safe
 	mu     sync.RWMutex
-	engine Enginer
+	engine Enginer[T]
+	// safe is the engine if the changes hold mu shared, see ThreadSafe
+	safe ThreadSafe[T]
+	// imu guards the indexes against the changes holding mu shared
+	imu   sync.RWMutex
+	quota Quota
+	bytes int64 // the approximate size of data, only counted with the byte budget
+	// indexes are the secondary indexes by name, see AddIndex
+	indexes map[string]index[T]
+	// texts are the full-text indexes by name, see AddTextIndex
+	texts map[string]*textIndex[T]
+	// refs check the references of the inserted and updated data, see AddReference
+	refs []func(data T, get func(id int) (T, bool)) error
+	// inbound check the references to the deleted data from the other data of the
+	// Storage, see AddTree
+	inbound []func(id int, get func(id int) (T, bool), pending []T) error
+	// reads report whether the change of data reads the other data, a nil data is a
+	// delete, such a change holds the lock exclusively, see AddTree
+	reads []func(data *T) bool
 }
 
-func (s *Storage) Insert(data any) (int, error) {
+// lock locks for a single change of data, a nil data is a delete, and returns the
+// unlock. It's the shared lock if the engine is thread-safe and the change doesn't read
+// the other data, the engine is never thread-safe with a Quota, so the changes under the
+// shared lock don't touch the counted bytes
+func (s *Storage[T]) lock(data *T) func() {
+	shared := s.safe != nil
+	for _, reads := range s.reads {
+		shared = shared && !reads(data)
+	}
+	if shared {
+		s.mu.RLock()
+		return s.mu.RUnlock
+	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.engine.Insert(data)
+	return s.mu.Unlock
 }
 
-func (s *Storage) Count() int {
+// rlock locks for reading the data and the indexes
+func (s *Storage[T]) rlock() {
+	s.mu.RLock()
+	if s.safe != nil {
+		s.imu.RLock()
+	}
+}
+
+func (s *Storage[T]) runlock() {
+	if s.safe != nil {
+		s.imu.RUnlock()
+	}
+	s.mu.RUnlock()
+}
+
+// Insert inserts data, it returns QuotaError if the quota would be exceeded
+func (s *Storage[T]) Insert(data T) (int, error) {
+	defer s.lock(&data)()
+	return s.insert(data)
+}
+
+// insert inserts data, the caller must hold the lock
+func (s *Storage[T]) insert(data T) (int, error) {
+	if err := s.checkRefs(data, s.engine.Get); err != nil {
+		return -1, err
+	}
+	var size int64
+	if s.quota.MaxBytes > 0 {
+		size = sizeOf(data)
+	}
+	if err := s.reserve(size, 0, false); err != nil {
+		return -1, err
+	}
+	setVersion(data, 1)
+	id, err := s.engine.Insert(data)
+	if err != nil {
+		return id, err
+	}
+	s.addBytes(size)
+	s.indexChanged(id, &data)
+	return id, nil
+}
+
+func (s *Storage[T]) Get(id int) (T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.engine.Get(id)
+	if !ok {
+		var zero T
+		return zero, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *Storage[T]) Count() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.engine.Count()
 }
 
-func (s *Storage) Range(i, j int) []any {
+func (s *Storage[T]) Range(i, j int) []T {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.engine.Range(i, j)
 }
 
-func (s *Storage) Delete(i int) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *Storage[T]) Scan(after, n int) []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.engine.Scan(after, n)
+}
+
+func (s *Storage[T]) Delete(i int) error {
+	return s.CompareAndDelete(i, nil)
+}
+
+// CompareAndDelete deletes data only if match reports true for the current data, a nil
+// match matches any data. It returns ErrNotFound if the data does not exist, and
+// ErrConflict if it does not match
+func (s *Storage[T]) CompareAndDelete(i int, match func(current T) bool) error {
+	defer s.lock(nil)()
+	return s.compareAndDelete(i, match)
+}
+
+// compareAndDelete is CompareAndDelete, it returns ErrReferenced if other data still
+// reference the data. The caller must hold the lock
+func (s *Storage[T]) compareAndDelete(i int, match func(current T) bool) error {
+	if err := s.checkReferenced(i, s.engine.Get, nil); err != nil {
+		return err
+	}
+	return s.remove(i, match)
+}
+
+// remove is compareAndDelete without checking the references to the data, the caller
+// must hold the lock
+func (s *Storage[T]) remove(i int, match func(current T) bool) error {
+	if s.safe != nil && match != nil {
+		// the match and the delete are atomic in the engine
+		if !s.safe.CompareAndDelete(i, match) {
+			if _, ok := s.engine.Get(i); ok {
+				return ErrConflict
+			}
+			return s.deleteErr()
+		}
+		s.indexChanged(i, nil)
+		return nil
+	}
+	var size int64
+	if s.quota.MaxBytes > 0 || match != nil {
+		old, ok := s.engine.Get(i)
+		if !ok {
+			return ErrNotFound
+		}
+		if match != nil && !match(old) {
+			return ErrConflict
+		}
+		if s.quota.MaxBytes > 0 {
+			size = sizeOf(old)
+		}
+	}
 	if !s.engine.Delete(i) {
-		return errors.New("data is not exsit")
+		return s.deleteErr()
 	}
+	s.addBytes(-size)
+	s.indexChanged(i, nil)
 	return nil
 }
 
-func (s *Storage) Update(id int, data any) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.engine.Update(id, data)
+// deleteErr returns the error of a failed delete of the engine, it's ErrNotFound unless
+// the engine is a Failer which failed
+func (s *Storage[T]) deleteErr() error {
+	if f, ok := s.engine.(Failer); ok {
+		if err := f.Err(); err != nil {
+			return err
+		}
+	}
+	return ErrNotFound
+}
+
+// Update updates data, it returns ErrNotFound if the data does not exist, and QuotaError
+// if the byte budget would be exceeded
+func (s *Storage[T]) Update(id int, data T) error {
+	return s.CompareAndSwap(id, nil, data)
+}
+
+// CompareAndSwap updates data only if match reports true for the current data, a nil
+// match matches any data. It returns ErrNotFound if the data does not exist, ErrConflict
+// if it does not match, and QuotaError if the byte budget would be exceeded
+func (s *Storage[T]) CompareAndSwap(id int, match func(current T) bool, data T) error {
+	defer s.lock(&data)()
+	return s.compareAndSwap(id, match, data)
+}
+
+// compareAndSwap is CompareAndSwap, the caller must hold the lock
+func (s *Storage[T]) compareAndSwap(id int, match func(current T) bool, data T) error {
+	if err := s.checkRefs(data, s.engine.Get); err != nil {
+		return err
+	}
+	for {
+		prev, ok := s.engine.Get(id)
+		if !ok {
+			return ErrNotFound
+		}
+		if match != nil && !match(prev) {
+			return ErrConflict
+		}
+		var size, old int64
+		if s.quota.MaxBytes > 0 {
+			size = sizeOf(data)
+			old = sizeOf(prev)
+		}
+		if err := s.reserve(size, old, true); err != nil {
+			return err
+		}
+		version := versionOf(prev)
+		setVersion(data, version+1)
+		if s.safe != nil {
+			// another change may come after Get under the shared lock, so the version
+			// is matched too, and it's tried again with the data changed by others
+			swapped, err := s.engine.CompareAndSwap(id, func(current T) bool {
+				return versionOf(current) == version && (match == nil || match(current))
+			}, data)
+			if err != nil {
+				return err
+			}
+			if !swapped {
+				continue
+			}
+		} else if match == nil {
+			if err := s.engine.Update(id, data); err != nil {
+				return err
+			}
+		} else {
+			swapped, err := s.engine.CompareAndSwap(id, match, data)
+			if err != nil {
+				return err
+			}
+			if !swapped {
+				return ErrConflict
+			}
+		}
+		s.addBytes(size - old)
+		s.indexChanged(id, &data)
+		return nil
+	}
+}
+
+// checkRefs returns the error of the first reference of data which fails, get reads the
+// data as seen by the change. The caller must hold the lock
+func (s *Storage[T]) checkRefs(data T, get func(id int) (T, bool)) error {
+	for _, check := range s.refs {
+		if err := check(data, get); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// DataStorage is the default Storage
-var DataStorage Storage
+// checkReferenced returns ErrReferenced if the data of id is referenced by other data of
+// the Storage, get reads the data as seen by the change, and pending are the data
+// changed by it which are not indexed yet. The caller must hold the lock
+func (s *Storage[T]) checkReferenced(id int, get func(id int) (T, bool), pending []T) error {
+	for _, check := range s.inbound {
+		if err := check(id, get, pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setVersion sets the version of data if it's a Versioner
+func setVersion[T Entity](data T, version int) {
+	if v, ok := any(data).(Versioner); ok {
+		v.SetVersion(version)
+	}
+}
+
+// versionOf returns the version of data, it's 0 if data is not a Versioner
+func versionOf[T Entity](data T) int {
+	if v, ok := any(data).(Versioner); ok {
+		return v.GetVersion()
+	}
+	return 0
+}
+
+// indexChanged updates the indexes with the change of id, data is nil if it was deleted,
+// the caller must hold the lock. The changes of an id may be indexed out of order under
+// the shared lock, so the data is read again from the engine then, and the indexes end
+// with the last change
+func (s *Storage[T]) indexChanged(id int, data *T) {
+	if len(s.indexes) == 0 && len(s.texts) == 0 {
+		return
+	}
+	if s.safe != nil {
+		s.imu.Lock()
+		defer s.imu.Unlock()
+		data = nil
+		if current, ok := s.engine.Get(id); ok {
+			data = &current
+		}
+	}
+	if data == nil {
+		s.unindex(id)
+	} else {
+		s.reindex(id, *data)
+	}
+}
+
+// reindex updates all the indexes with the data of id, the caller must hold the lock
+func (s *Storage[T]) reindex(id int, data T) {
+	for _, idx := range s.indexes {
+		idx.insert(id, data)
+	}
+	for _, idx := range s.texts {
+		idx.insert(id, data)
+	}
+}
+
+// unindex removes id from all the indexes, the caller must hold the lock
+func (s *Storage[T]) unindex(id int) {
+	for _, idx := range s.indexes {
+		idx.delete(id)
+	}
+	for _, idx := range s.texts {
+		idx.delete(id)
+	}
+}
@@ -0,0 +1,262 @@
+package storage_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/cskiplists"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data]())
+	if _, err := s.Insert(&storagetest.Data{Name: "a"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	storage.AddIndex(s, "name", func(d *storagetest.Data) string { return d.Name })
+	isName := func(name string) func(*storagetest.Data) bool {
+		return func(current *storagetest.Data) bool { return current.Name == name }
+	}
+
+	testcases := []struct {
+		name    string
+		id      int
+		match   func(*storagetest.Data) bool
+		data    *storagetest.Data
+		wantErr error
+		want    string
+	}{
+		{
+			name:  "swap the matched data",
+			id:    1,
+			match: isName("a"),
+			data:  &storagetest.Data{ID: 1, Name: "b"},
+			want:  "b",
+		},
+		{
+			name:    "keep the changed data",
+			id:      1,
+			match:   isName("a"),
+			data:    &storagetest.Data{ID: 1, Name: "c"},
+			wantErr: storage.ErrConflict,
+			want:    "b",
+		},
+		{
+			name:    "non-exist data",
+			id:      2,
+			match:   isName("b"),
+			data:    &storagetest.Data{ID: 2, Name: "c"},
+			wantErr: storage.ErrNotFound,
+			want:    "b",
+		},
+		{
+			name:  "nil match",
+			id:    1,
+			match: nil,
+			data:  &storagetest.Data{ID: 1, Name: "d"},
+			want:  "d",
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.CompareAndSwap(tt.id, tt.match, tt.data); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error should be %v, but got %v", tt.wantErr, err)
+			}
+			data, err := s.Get(1)
+			if err != nil {
+				t.Fatal("get error", err)
+			}
+			if data.Name != tt.want {
+				t.Fatalf("name should be %v, but got %v", tt.want, data.Name)
+			}
+			// the index follows the swap
+			_, total, err := s.Find(storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Eq("name", tt.want)}}, 1, 1)
+			if err != nil || total != 1 {
+				t.Fatalf("indexed total should be 1, but got %v, %v", total, err)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data]())
+	id, err := s.Insert(&storagetest.Data{Version: 5})
+	if err != nil {
+		t.Fatal("insert error", err)
+	}
+	isVersion := func(version int) func(*storagetest.Data) bool {
+		return func(current *storagetest.Data) bool { return current.Version == version }
+	}
+	for _, want := range []int{2, 3} {
+		if err := s.CompareAndSwap(id, isVersion(want-1), &storagetest.Data{ID: id}); err != nil {
+			t.Fatal("compare and swap error", err)
+		}
+		data, err := s.Get(id)
+		if err != nil {
+			t.Fatal("get error", err)
+		}
+		if data.Version != want {
+			t.Fatalf("version should be %v, but got %v", want, data.Version)
+		}
+	}
+	if err := s.CompareAndSwap(id, isVersion(2), &storagetest.Data{ID: id}); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrConflict, err)
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data](), storage.WithQuota(storage.Quota{MaxBytes: 10}))
+	if _, err := s.Insert(&storagetest.Data{Name: "abcde"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	storage.AddIndex(s, "name", func(d *storagetest.Data) string { return d.Name })
+	isName := func(name string) func(*storagetest.Data) bool {
+		return func(current *storagetest.Data) bool { return current.Name == name }
+	}
+
+	testcases := []struct {
+		name    string
+		id      int
+		match   func(*storagetest.Data) bool
+		wantErr error
+		want    int
+	}{
+		{
+			name:    "keep the unmatched data",
+			id:      1,
+			match:   isName("b"),
+			wantErr: storage.ErrConflict,
+			want:    1,
+		},
+		{
+			name:  "delete the matched data",
+			id:    1,
+			match: isName("abcde"),
+			want:  0,
+		},
+		{
+			name:    "non-exist data",
+			id:      1,
+			match:   isName("abcde"),
+			wantErr: storage.ErrNotFound,
+			want:    0,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.CompareAndDelete(tt.id, tt.match); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error should be %v, but got %v", tt.wantErr, err)
+			}
+			if s.Count() != tt.want {
+				t.Fatalf("count should be %v, but got %v", tt.want, s.Count())
+			}
+			_, total, err := s.Find(storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Eq("name", "abcde")}}, 1, 1)
+			if err != nil || total != tt.want {
+				t.Fatalf("indexed total should be %v, but got %v, %v", tt.want, total, err)
+			}
+		})
+	}
+
+	// the bytes of the deleted data are released
+	if _, err := s.Insert(&storagetest.Data{Name: "0123456789"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+}
+
+func TestThreadSafeInsertDelete(t *testing.T) {
+	// no index, so the changes only share the engine and the quota state of the Storage
+	s := storage.New(cskiplists.New[*storagetest.Data]())
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				id, err := s.Insert(&storagetest.Data{})
+				if err != nil {
+					t.Error("insert error", err)
+					return
+				}
+				if i%2 == 0 {
+					continue
+				}
+				if err := s.Delete(id); err != nil {
+					t.Error("delete error", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if s.Count() != 8*100 {
+		t.Fatalf("count should be %v, but got %v", 8*100, s.Count())
+	}
+
+	// the changes hold the lock exclusively with a quota, so it's never exceeded
+	s = storage.New(cskiplists.New[*storagetest.Data](), storage.WithQuota(storage.Quota{MaxItems: 100}))
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := s.Insert(&storagetest.Data{}); err != nil && !errors.Is(err, storage.ErrQuotaExceeded) {
+					t.Error("insert error", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if s.Count() != 100 {
+		t.Fatalf("count should be %v, but got %v", 100, s.Count())
+	}
+}
+
+func TestThreadSafe(t *testing.T) {
+	s := storage.New(cskiplists.New[*storagetest.Data]())
+	for i := 0; i < 10; i++ {
+		if _, err := s.Insert(&storagetest.Data{}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	storage.AddIndex(s, "version", func(d *storagetest.Data) int { return d.Version })
+
+	// the changes hold the lock shared, no update is lost and the indexes end with the
+	// last changes
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			id := g%10 + 1
+			for i := 0; i < 50; i++ {
+				if err := s.Update(id, &storagetest.Data{ID: id}); err != nil {
+					t.Error("update error", err)
+				}
+				inserted, err := s.Insert(&storagetest.Data{})
+				if err != nil {
+					t.Error("insert error", err)
+				}
+				if err := s.CompareAndDelete(inserted, func(*storagetest.Data) bool { return true }); err != nil {
+					t.Error("delete error", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if s.Count() != 10 {
+		t.Fatalf("count should be %v, but got %v", 10, s.Count())
+	}
+	for id := 1; id <= 10; id++ {
+		data, err := s.Get(id)
+		if err != nil || data.Version != 101 {
+			t.Fatalf("version should be %v, but got %v, %v", 101, data, err)
+		}
+	}
+	_, total, err := s.Find(storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.Eq("version", 101)}}, 1, 10)
+	if err != nil || total != 10 {
+		t.Fatalf("indexed total should be %v, but got %v, %v", 10, total, err)
+	}
+}
@@ -0,0 +1,48 @@
+// Package storagetest provides the data and the conformance tests shared by the tests of
+// the storage and its drivers. It doesn't import the storage, so the tests inside the
+// storage package can use it too
+package storagetest
+
+// Data is the entity of the tests, the fields are used by the tests as they need
+type Data struct {
+	ID   int
+	Name string
+	// Key is an int field to index or to change
+	Key int
+	// Parent is the id of the parent data of a reference or a tree
+	Parent int
+	// Version is set by the storage, it's not changed by the drivers
+	Version int
+	// Tags are the strings of a tag index
+	Tags []string
+}
+
+func (d *Data) GetID() int {
+	return d.ID
+}
+
+func (d *Data) SetID(id int) {
+	d.ID = id
+}
+
+func (d *Data) GetVersion() int {
+	return d.Version
+}
+
+func (d *Data) SetVersion(version int) {
+	d.Version = version
+}
+
+// Size is the size of the data for the byte quota, it's the length of Name
+func (d *Data) Size() int {
+	return len(d.Name)
+}
+
+// IDs returns the ids of data for convenience of comparing
+func IDs(data []*Data) []int {
+	ids := make([]int, 0, len(data))
+	for _, d := range data {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
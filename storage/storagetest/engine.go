@@ -0,0 +1,316 @@
+package storagetest
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// Engine is storage.Enginer of *Data, it's declared here since the package doesn't
+// import the storage
+type Engine interface {
+	Insert(data *Data) (int, error)
+	Get(id int) (*Data, bool)
+	Count() int
+	Range(i, j int) []*Data
+	Scan(after, n int) []*Data
+	Delete(i int) bool
+	Update(id int, data *Data) error
+	CompareAndSwap(id int, match func(current *Data) bool, data *Data) (bool, error)
+}
+
+// TestEngine runs the conformance tests of a storage driver, open returns an empty
+// engine for every test, and the caller cleans it up by t.Cleanup
+func TestEngine(t *testing.T, open func(t *testing.T) Engine) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, engine Engine)
+	}{
+		{"insert", testInsert},
+		{"get", testGet},
+		{"delete", testDelete},
+		{"range", testRange},
+		{"scan", testScan},
+		{"update", testUpdate},
+		{"compare and swap", testCompareAndSwap},
+		{"random operations", testRandomOperations},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, open(t))
+		})
+	}
+}
+
+// insertN inserts the data named v1 to vn
+func insertN(t *testing.T, engine Engine, n int) {
+	for i := 1; i <= n; i++ {
+		if _, err := engine.Insert(&Data{Name: fmt.Sprintf("v%d", i)}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+}
+
+func testInsert(t *testing.T, engine Engine) {
+	prevID := 0
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		data := &Data{Name: name}
+		id, err := engine.Insert(data)
+		if err != nil {
+			t.Fatal("insert error", err)
+		}
+
+		// id should be greater than prevID, unique and assigned to the data
+		if id <= prevID {
+			t.Fatal("id is less than and equal to prevID")
+		}
+		if data.ID != id {
+			t.Fatalf("id of data should be %d, but got %d", id, data.ID)
+		}
+		prevID = id
+	}
+	if engine.Count() != 5 {
+		t.Fatalf("count should be 5, but got %d", engine.Count())
+	}
+}
+
+func testGet(t *testing.T, engine Engine) {
+	insertN(t, engine, 100)
+
+	data, ok := engine.Get(42)
+	if !ok {
+		t.Fatal("data should be found")
+	}
+	if want := (&Data{ID: 42, Name: "v42"}); !reflect.DeepEqual(data, want) {
+		t.Fatalf("it should be %v, but got %v", want, data)
+	}
+	if _, ok := engine.Get(101); ok {
+		t.Fatal("data should not be found")
+	}
+}
+
+func testDelete(t *testing.T, engine Engine) {
+	insertN(t, engine, 8)
+	engine.Delete(2)
+
+	testcases := []struct {
+		name  string
+		key   int
+		want  bool
+		count int
+	}{
+		{name: "deleted", key: 5, want: true, count: 6},
+		{name: "deleted on non-exist data", key: 9, want: false, count: 6},
+		{name: "deleted twice", key: 5, want: false, count: 6},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			if ans := engine.Delete(tt.key); ans != tt.want {
+				t.Fatalf("deleted should be %v, but got %v", tt.want, ans)
+			}
+			if _, ok := engine.Get(tt.key); ok {
+				t.Fatal("deleted data should not be found")
+			}
+			if engine.Count() != tt.count {
+				t.Fatalf("count should be %d, but got %d", tt.count, engine.Count())
+			}
+		})
+	}
+}
+
+func testRange(t *testing.T, engine Engine) {
+	insertN(t, engine, 7)
+	testcases := []struct {
+		name  string
+		start int
+		end   int
+		want  []int
+	}{
+		{name: "query - 0", start: 1, end: 1, want: []int{1}},
+		{name: "query - 1", start: 1, end: 5, want: []int{1, 2, 3, 4, 5}},
+		{name: "query - 2", start: 2, end: 5, want: []int{6, 7}},
+		{name: "query - 3", start: 3, end: 5, want: []int{}},
+		{name: "query - 4", start: 2, end: 3, want: []int{4, 5, 6}},
+	}
+	for _, tt := range testcases {
+		if ans := IDs(engine.Range(tt.start, tt.end)); !reflect.DeepEqual(ans, tt.want) {
+			t.Fatalf("%s should be %v, but got %v", tt.name, tt.want, ans)
+		}
+	}
+
+	// the data across pages
+	insertN(t, engine, 493)
+	want := []int{301, 302, 303, 304, 305, 306, 307, 308, 309, 310}
+	if ans := IDs(engine.Range(31, 10)); !reflect.DeepEqual(ans, want) {
+		t.Fatalf("query across pages should be %v, but got %v", want, ans)
+	}
+}
+
+func testScan(t *testing.T, engine Engine) {
+	insertN(t, engine, 300)
+	for id := 100; id <= 200; id++ {
+		engine.Delete(id)
+	}
+
+	testcases := []struct {
+		after int
+		n     int
+		want  []int
+	}{
+		{after: 0, n: 3, want: []int{1, 2, 3}},
+		{after: 98, n: 4, want: []int{99, 201, 202, 203}},
+		{after: 150, n: 1, want: []int{201}},
+		{after: 298, n: 5, want: []int{299, 300}},
+		{after: 300, n: 5, want: []int{}},
+	}
+	for _, tt := range testcases {
+		if ans := IDs(engine.Scan(tt.after, tt.n)); !reflect.DeepEqual(ans, tt.want) {
+			t.Fatalf("scan after %d should be %v, but got %v", tt.after, tt.want, ans)
+		}
+	}
+}
+
+func testUpdate(t *testing.T, engine Engine) {
+	insertN(t, engine, 50)
+
+	newData := &Data{ID: 20, Name: "data - v2"}
+	if err := engine.Update(20, newData); err != nil {
+		t.Fatal("update error", err)
+	}
+	data, ok := engine.Get(20)
+	if !ok {
+		t.Fatal("data should be found")
+	}
+	if !reflect.DeepEqual(data, newData) {
+		t.Fatalf("failed to update data, it should be %v, but got %v", newData, data)
+	}
+	if err := engine.Update(51, &Data{ID: 51}); err == nil {
+		t.Fatal("update non-exist data should be failed")
+	}
+	if engine.Count() != 50 {
+		t.Fatalf("count should be 50, but got %d", engine.Count())
+	}
+}
+
+func testCompareAndSwap(t *testing.T, engine Engine) {
+	insertN(t, engine, 50)
+	isName := func(name string) func(*Data) bool {
+		return func(current *Data) bool { return current.Name == name }
+	}
+
+	testcases := []struct {
+		name  string
+		id    int
+		match func(*Data) bool
+		data  *Data
+		want  bool
+		value string
+	}{
+		{
+			name:  "swap the matched data",
+			id:    20,
+			match: isName("v20"),
+			data:  &Data{ID: 20, Name: "v2"},
+			want:  true,
+			value: "v2",
+		},
+		{
+			name:  "keep the changed data",
+			id:    20,
+			match: isName("v20"),
+			data:  &Data{ID: 20, Name: "v3"},
+			want:  false,
+			value: "v2",
+		},
+		{
+			name:  "non-exist data",
+			id:    51,
+			match: isName("v2"),
+			data:  &Data{ID: 51, Name: "v3"},
+			want:  false,
+			value: "v2",
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			swapped, err := engine.CompareAndSwap(tt.id, tt.match, tt.data)
+			if err != nil {
+				t.Fatal("compare and swap error", err)
+			}
+			if swapped != tt.want {
+				t.Fatalf("swapped should be %v, but got %v", tt.want, swapped)
+			}
+			if data, _ := engine.Get(20); data.Name != tt.value {
+				t.Fatalf("value should be %v, but got %v", tt.value, data.Name)
+			}
+		})
+	}
+	if engine.Count() != 50 {
+		t.Fatalf("count should be 50, but got %d", engine.Count())
+	}
+}
+
+// testRandomOperations compares the engine with a map after random inserts, updates and
+// deletes
+func testRandomOperations(t *testing.T, engine Engine) {
+	model := make(map[int]string)
+	rnd := rand.New(rand.NewSource(1))
+	maxID := 0
+
+	for i := 0; i < 3000; i++ {
+		switch op := rnd.Intn(10); {
+		case op < 6:
+			name := fmt.Sprintf("v%d", i)
+			id, err := engine.Insert(&Data{Name: name})
+			if err != nil {
+				t.Fatal("insert error", err)
+			}
+			model[id], maxID = name, max(maxID, id)
+		case op < 8 && len(model) > 0:
+			id := rnd.Intn(maxID) + 1
+			name := fmt.Sprintf("u%d", i)
+			err := engine.Update(id, &Data{ID: id, Name: name})
+			if _, ok := model[id]; ok != (err == nil) {
+				t.Fatalf("update %d error %v", id, err)
+			}
+			if err == nil {
+				model[id] = name
+			}
+		case len(model) > 0:
+			id := rnd.Intn(maxID) + 1
+			_, ok := model[id]
+			if engine.Delete(id) != ok {
+				t.Fatalf("delete %d should be %v", id, ok)
+			}
+			delete(model, id)
+		}
+	}
+
+	keys := make([]int, 0, len(model))
+	for id := 1; id <= maxID; id++ {
+		if _, ok := model[id]; ok {
+			keys = append(keys, id)
+		}
+	}
+	if engine.Count() != len(keys) {
+		t.Fatalf("count should be %d, but got %d", len(keys), engine.Count())
+	}
+	for size := 1; size <= 7; size += 3 {
+		for page := 1; (page-1)*size < len(keys); page++ {
+			want := keys[(page-1)*size : min(page*size, len(keys))]
+			data := engine.Range(page, size)
+			if !reflect.DeepEqual(IDs(data), want) {
+				t.Fatalf("page %d of size %d should be %v, but got %v", page, size, want, IDs(data))
+			}
+			for _, d := range data {
+				if d.Name != model[d.ID] {
+					t.Fatalf("value of %d should be %v, but got %v", d.ID, model[d.ID], d.Name)
+				}
+			}
+		}
+	}
+	if ans := IDs(engine.Scan(0, len(keys)+1)); !reflect.DeepEqual(ans, keys) {
+		t.Fatalf("scan should be %v, but got %v", keys, ans)
+	}
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+)
+
+// TagCount is a tag of a tag index with the number of the data tagged by it
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// HasAny selects the data of the tag index tagged by any of the tags
+func HasAny(index string, tags ...string) Cond {
+	return Cond{Index: index, tags: nonNil(tags)}
+}
+
+// HasAll selects the data of the tag index tagged by all the tags
+func HasAll(index string, tags ...string) Cond {
+	return Cond{Index: index, tags: nonNil(tags), all: true}
+}
+
+// nonNil returns tags, an empty slice if it's nil, since a nil tags is not a tag Cond
+func nonNil(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// AddTagIndex adds the inverted index of the tags of the data by name to the storage,
+// the data are selected by the index with HasAny and HasAll, and the tags are counted by
+// Tags. The existing data are indexed at once, and the index is maintained on every
+// change since then. An index with the same name is replaced
+func AddTagIndex[T Entity](s *Storage[T], name string, tags func(data T) []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := &tagIndex[T]{
+		tags:     tags,
+		postings: make(map[string][]int),
+		keys:     make(map[int][]string),
+	}
+	s.walk(0, func(data T) bool {
+		idx.insert(data.GetID(), data)
+		return true
+	})
+	if s.indexes == nil {
+		s.indexes = make(map[string]index[T])
+	}
+	s.indexes[name] = idx
+}
+
+// tagIndex keeps the ids of every tag in the order of id, and the tags of every id, so
+// an entry can be removed after the data was changed
+type tagIndex[T Entity] struct {
+	tags     func(data T) []string
+	postings map[string][]int
+	keys     map[int][]string
+}
+
+func (idx *tagIndex[T]) insert(id int, data T) {
+	idx.delete(id)
+	var tags []string
+	for _, tag := range idx.tags(data) {
+		if slices.Contains(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+		ids := idx.postings[tag]
+		if i, found := slices.BinarySearch(ids, id); !found {
+			idx.postings[tag] = slices.Insert(ids, i, id)
+		}
+	}
+	if len(tags) > 0 {
+		idx.keys[id] = tags
+	}
+}
+
+func (idx *tagIndex[T]) delete(id int) {
+	for _, tag := range idx.keys[id] {
+		ids := idx.postings[tag]
+		if i, found := slices.BinarySearch(ids, id); found {
+			ids = slices.Delete(ids, i, i+1)
+		}
+		if len(ids) == 0 {
+			delete(idx.postings, tag)
+		} else {
+			idx.postings[tag] = ids
+		}
+	}
+	delete(idx.keys, id)
+}
+
+func (idx *tagIndex[T]) check(c Cond) error {
+	if c.tags == nil {
+		return ErrIndexCond
+	}
+	return nil
+}
+
+func (idx *tagIndex[T]) match(id int, c Cond) bool {
+	tags := idx.keys[id]
+	for _, tag := range c.tags {
+		has := slices.Contains(tags, tag)
+		if has && !c.all {
+			return true
+		}
+		if !has && c.all {
+			return false
+		}
+	}
+	return c.all && len(c.tags) > 0
+}
+
+func (idx *tagIndex[T]) count(c *Cond) int {
+	if c == nil {
+		return len(idx.keys)
+	}
+	return len(idx.ids(c))
+}
+
+// walk walks the ids in the order of id, a nil c selects the ids with any tag
+func (idx *tagIndex[T]) walk(c *Cond, after *T, desc bool, fn func(id int) bool) {
+	ids := idx.ids(c)
+	if desc {
+		i := len(ids)
+		if after != nil {
+			i, _ = slices.BinarySearch(ids, (*after).GetID())
+		}
+		for i--; i >= 0 && fn(ids[i]); i-- {
+		}
+		return
+	}
+	i := 0
+	if after != nil {
+		var found bool
+		if i, found = slices.BinarySearch(ids, (*after).GetID()); found {
+			i++
+		}
+	}
+	for ; i < len(ids) && fn(ids[i]); i++ {
+	}
+}
+
+// ids returns the ids selected by c in the order of id
+func (idx *tagIndex[T]) ids(c *Cond) []int {
+	if c == nil {
+		ids := make([]int, 0, len(idx.keys))
+		for id := range idx.keys {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		return ids
+	}
+	if len(c.tags) == 0 {
+		return nil
+	}
+	if !c.all {
+		var ids []int
+		for _, tag := range c.tags {
+			ids = append(ids, idx.postings[tag]...)
+		}
+		sort.Ints(ids)
+		return slices.Compact(ids)
+	}
+	// the ids of the rarest tag which have the other tags
+	rarest := idx.postings[c.tags[0]]
+	for _, tag := range c.tags[1:] {
+		if len(idx.postings[tag]) < len(rarest) {
+			rarest = idx.postings[tag]
+		}
+	}
+	var ids []int
+	for _, id := range rarest {
+		if idx.match(id, *c) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Tags returns the tags of the tag index by name with the number of the data tagged by
+// every tag, ordered by tag
+func (s *Storage[T]) Tags(index string) ([]TagCount, error) {
+	s.rlock()
+	defer s.runlock()
+	idx, ok := s.indexes[index].(*tagIndex[T])
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	counts := make([]TagCount, 0, len(idx.postings))
+	for tag, ids := range idx.postings {
+		counts = append(counts, TagCount{Tag: tag, Count: len(ids)})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Tag < counts[j].Tag })
+	return counts, nil
+}
@@ -0,0 +1,96 @@
+package storage_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+func TestTagIndex(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data]())
+	for _, d := range []*storagetest.Data{
+		{Tags: []string{"a", "b"}},
+		{Tags: []string{"b"}, Key: 1},
+		{Tags: []string{"a", "c", "a"}, Key: 1},
+		{},
+	} {
+		if _, err := s.Insert(d); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	// the existing data are indexed
+	storage.AddTagIndex(s, "tags", func(d *storagetest.Data) []string { return d.Tags })
+	storage.AddIndex(s, "kind", func(d *storagetest.Data) int { return d.Key })
+	if err := s.Update(4, &storagetest.Data{ID: 4, Tags: []string{"c", "b"}}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if _, err := s.Insert(&storagetest.Data{Tags: []string{"d"}}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	if err := s.Delete(5); err != nil {
+		t.Fatal("delete error", err)
+	}
+
+	testcases := []struct {
+		name      string
+		where     []storage.Cond
+		desc      bool
+		i, j      int
+		want      []int
+		wantTotal int
+	}{
+		{name: "any", where: []storage.Cond{storage.HasAny("tags", "a", "c")}, i: 1, j: 10, want: []int{1, 3, 4}, wantTotal: 3},
+		{name: "all", where: []storage.Cond{storage.HasAll("tags", "a", "c")}, i: 1, j: 10, want: []int{3}, wantTotal: 1},
+		{name: "all of one", where: []storage.Cond{storage.HasAll("tags", "b")}, i: 1, j: 10, want: []int{1, 2, 4}, wantTotal: 3},
+		{name: "page", where: []storage.Cond{storage.HasAny("tags", "b", "c")}, i: 2, j: 2, want: []int{3, 4}, wantTotal: 4},
+		{name: "unknown tag", where: []storage.Cond{storage.HasAny("tags", "x")}, i: 1, j: 10, want: []int{}, wantTotal: 0},
+		{name: "deleted tag", where: []storage.Cond{storage.HasAll("tags", "d")}, i: 1, j: 10, want: []int{}, wantTotal: 0},
+		{name: "no tag", where: []storage.Cond{storage.HasAny("tags")}, i: 1, j: 10, want: []int{}, wantTotal: 0},
+		{
+			name:      "with another index",
+			where:     []storage.Cond{storage.Eq("kind", 1), storage.HasAny("tags", "b")},
+			i:         1,
+			j:         10,
+			want:      []int{2},
+			wantTotal: 1,
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, total, err := s.Find(storage.Query[*storagetest.Data]{Where: tt.where}, tt.i, tt.j)
+			if err != nil {
+				t.Fatal("find error", err)
+			}
+			if ids := storagetest.IDs(data); !reflect.DeepEqual(ids, tt.want) || total != tt.wantTotal {
+				t.Fatalf("result should be %v of %d, but got %v of %d", tt.want, tt.wantTotal, ids, total)
+			}
+		})
+	}
+
+	// the cursor of the tag index is the id
+	data, total, err := s.FindAfter(storage.Query[*storagetest.Data]{Where: []storage.Cond{storage.HasAny("tags", "b", "c")}}, &storagetest.Data{ID: 2}, 10)
+	if err != nil || total != 4 || len(data) != 2 || data[0].ID != 3 || data[1].ID != 4 {
+		t.Fatalf("data after 2 should be 3 and 4 of 4, but got %v of %d, %v", data, total, err)
+	}
+
+	tags, err := s.Tags("tags")
+	if err != nil {
+		t.Fatal("tags error", err)
+	}
+	want := []storage.TagCount{{Tag: "a", Count: 2}, {Tag: "b", Count: 3}, {Tag: "c", Count: 2}}
+	if !reflect.DeepEqual(tags, want) {
+		t.Fatalf("tags should be %v, but got %v", want, tags)
+	}
+	if _, err := s.Tags("kind"); !errors.Is(err, storage.ErrIndexNotFound) {
+		t.Fatalf("tags of an ordered index should be %v, but got %v", storage.ErrIndexNotFound, err)
+	}
+	for _, c := range []storage.Cond{storage.Eq("tags", "a"), storage.HasAny("kind", "a")} {
+		if _, _, err := s.Find(storage.Query[*storagetest.Data]{Where: []storage.Cond{c}}, 1, 10); !errors.Is(err, storage.ErrIndexCond) {
+			t.Fatalf("error should be %v, but got %v", storage.ErrIndexCond, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package storage
+
+import "errors"
+
+// ErrTxNotSupported is returned by Tx if the Enginer can't roll back its changes
+var ErrTxNotSupported = errors.New("transaction is not supported by the engine")
+
+// Transactioner is implemented by the Enginer which can roll back its changes, it's
+// required by Storage.Tx. The Storage never begins a transaction before the last one ends
+type Transactioner interface {
+	// Begin starts to record the changes
+	Begin() error
+	// Commit keeps the changes since Begin, the Storage rolls back the changes if it fails
+	Commit() error
+	// Rollback undoes the changes since Begin
+	Rollback()
+}
+
+// Tx is a transaction of Storage, the changes are seen by the reads of the transaction at
+// once, and by the others after the transaction is committed. It's only valid in the
+// function of Storage.Tx
+type Tx[T Entity] struct {
+	s *Storage[T]
+	// bytes is the size of data when the transaction began
+	bytes int64
+	// changed are the ids changed by the transaction
+	changed map[int]struct{}
+}
+
+// Tx calls fn with a transaction under the lock of the Storage, so the data read and
+// written by fn are not changed by others. The changes of fn are committed if fn returns
+// nil, or rolled back if fn returns an error or panics. It returns ErrTxNotSupported if
+// the Enginer is not a Transactioner
+func (s *Storage[T]) Tx(fn func(tx *Tx[T]) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	ended := false
+	defer func() {
+		// fn panicked
+		if !ended {
+			tx.rollback()
+		}
+	}()
+	err = fn(tx)
+	ended = true
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+	return tx.commit()
+}
+
+// begin begins a transaction, the caller must hold the lock until it ends
+func (s *Storage[T]) begin() (*Tx[T], error) {
+	t, ok := s.engine.(Transactioner)
+	if !ok {
+		return nil, ErrTxNotSupported
+	}
+	if err := t.Begin(); err != nil {
+		return nil, err
+	}
+	return &Tx[T]{s: s, bytes: s.bytes, changed: make(map[int]struct{})}, nil
+}
+
+// commit commits the changes, they're rolled back if it fails
+func (tx *Tx[T]) commit() error {
+	if err := tx.s.engine.(Transactioner).Commit(); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+// end commits the transaction if err is nil, or rolls it back, and returns the error of
+// them. A nil tx is the changes without a transaction, which are kept as they are
+func (tx *Tx[T]) end(err error) error {
+	switch {
+	case tx == nil:
+		return err
+	case err != nil:
+		tx.rollback()
+		return err
+	}
+	return tx.commit()
+}
+
+// rollback undoes the changes of the engine, and restores the indexes and the size of
+// the changed data
+func (tx *Tx[T]) rollback() {
+	s := tx.s
+	s.engine.(Transactioner).Rollback()
+	s.bytes = tx.bytes
+	for id := range tx.changed {
+		if data, ok := s.engine.Get(id); ok {
+			s.reindex(id, data)
+		} else {
+			s.unindex(id)
+		}
+	}
+}
+
+func (tx *Tx[T]) Get(id int) (T, error) {
+	data, ok := tx.s.engine.Get(id)
+	if !ok {
+		var zero T
+		return zero, ErrNotFound
+	}
+	return data, nil
+}
+
+func (tx *Tx[T]) Count() int {
+	return tx.s.engine.Count()
+}
+
+// Find is Storage.Find in the transaction
+func (tx *Tx[T]) Find(q Query[T], i, j int) ([]T, int, error) {
+	return tx.s.find(q, i, j)
+}
+
+// Insert is Storage.Insert in the transaction
+func (tx *Tx[T]) Insert(data T) (int, error) {
+	id, err := tx.s.insert(data)
+	if err == nil {
+		tx.changed[id] = struct{}{}
+	}
+	return id, err
+}
+
+// Update is Storage.Update in the transaction
+func (tx *Tx[T]) Update(id int, data T) error {
+	return tx.CompareAndSwap(id, nil, data)
+}
+
+// CompareAndSwap is Storage.CompareAndSwap in the transaction
+func (tx *Tx[T]) CompareAndSwap(id int, match func(current T) bool, data T) error {
+	tx.changed[id] = struct{}{}
+	return tx.s.compareAndSwap(id, match, data)
+}
+
+// Delete is Storage.Delete in the transaction
+func (tx *Tx[T]) Delete(id int) error {
+	return tx.CompareAndDelete(id, nil)
+}
+
+// CompareAndDelete is Storage.CompareAndDelete in the transaction
+func (tx *Tx[T]) CompareAndDelete(id int, match func(current T) bool) error {
+	tx.changed[id] = struct{}{}
+	return tx.s.compareAndDelete(id, match)
+}
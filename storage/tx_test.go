@@ -0,0 +1,143 @@
+package storage_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"glookbs.github.com/storage"
+	"glookbs.github.com/storage/drivers/skiplists"
+	"glookbs.github.com/storage/storagetest"
+)
+
+// plainEngine hides the transaction of the engine
+type plainEngine[T storage.Entity] struct {
+	storage.Enginer[T]
+}
+
+func TestTx(t *testing.T) {
+	s := storage.New(skiplists.New[*storagetest.Data](), storage.WithQuota(storage.Quota{MaxBytes: 11}))
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := s.Insert(&storagetest.Data{Name: name}); err != nil {
+			t.Fatal("insert error", err)
+		}
+	}
+	storage.AddIndex(s, "name", func(d *storagetest.Data) string { return d.Name })
+	names := func() []string {
+		data, _, err := s.Find(storage.Query[*storagetest.Data]{SortBy: "name"}, 1, 10)
+		if err != nil {
+			t.Fatal("find error", err)
+		}
+		result := []string{}
+		for _, d := range data {
+			result = append(result, d.Name)
+		}
+		return result
+	}
+	errFailed := errors.New("failed")
+
+	testcases := []struct {
+		name    string
+		fn      func(tx *storage.Tx[*storagetest.Data]) error
+		wantErr error
+		want    []string
+	}{
+		{
+			name: "rolled back by error",
+			fn: func(tx *storage.Tx[*storagetest.Data]) error {
+				if err := tx.Delete(1); err != nil {
+					return err
+				}
+				if err := tx.Update(2, &storagetest.Data{ID: 2, Name: "bbbb"}); err != nil {
+					return err
+				}
+				if _, err := tx.Insert(&storagetest.Data{Name: "d"}); err != nil {
+					return err
+				}
+				// the changes are seen in the transaction
+				data, total, err := tx.Find(storage.Query[*storagetest.Data]{SortBy: "name"}, 1, 10)
+				if err != nil || total != 3 || data[0].Name != "bbbb" || tx.Count() != 3 {
+					t.Fatalf("data in the transaction should be bbbb, c, d, but got %v, %v", data, err)
+				}
+				return errFailed
+			},
+			wantErr: errFailed,
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name: "rolled back by quota",
+			fn: func(tx *storage.Tx[*storagetest.Data]) error {
+				if err := tx.Update(1, &storagetest.Data{ID: 1, Name: "aaaa"}); err != nil {
+					return err
+				}
+				_, err := tx.Insert(&storagetest.Data{Name: "ddddddd"})
+				return err
+			},
+			wantErr: storage.ErrQuotaExceeded,
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name: "rolled back by panic",
+			fn: func(tx *storage.Tx[*storagetest.Data]) error {
+				if err := tx.Delete(3); err != nil {
+					return err
+				}
+				panic(errFailed)
+			},
+			wantErr: errFailed,
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name: "committed",
+			fn: func(tx *storage.Tx[*storagetest.Data]) error {
+				data, err := tx.Get(1)
+				if err != nil {
+					return err
+				}
+				if err := tx.Update(3, &storagetest.Data{ID: 3, Name: data.Name + "a"}); err != nil {
+					return err
+				}
+				if err := tx.Delete(1); err != nil {
+					return err
+				}
+				_, err = tx.Insert(&storagetest.Data{Name: "aaaaaa"})
+				return err
+			},
+			want: []string{"aa", "aaaaaa", "b"},
+		},
+	}
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := func() (err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						err = r.(error)
+					}
+				}()
+				return s.Tx(tt.fn)
+			}()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error should be %v, but got %v", tt.wantErr, err)
+			}
+			if ans := names(); !reflect.DeepEqual(ans, tt.want) {
+				t.Fatalf("names should be %v, but got %v", tt.want, ans)
+			}
+		})
+	}
+
+	// the bytes of the rolled back changes are released, so the rest budget fits exactly
+	if err := s.Update(2, &storagetest.Data{ID: 2, Name: "bb"}); err != nil {
+		t.Fatal("update error", err)
+	}
+	if _, err := s.Insert(&storagetest.Data{Name: "e"}); err != nil {
+		t.Fatal("insert error", err)
+	}
+	if _, err := s.Insert(&storagetest.Data{Name: "f"}); !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrQuotaExceeded, err)
+	}
+
+	unsupported := storage.New[*storagetest.Data](plainEngine[*storagetest.Data]{skiplists.New[*storagetest.Data]()})
+	if err := unsupported.Tx(func(*storage.Tx[*storagetest.Data]) error { return nil }); !errors.Is(err, storage.ErrTxNotSupported) {
+		t.Fatalf("error should be %v, but got %v", storage.ErrTxNotSupported, err)
+	}
+}